@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const (
+	accessFile     = "access.json"
+	accessPath     = "/admin/access"
+	accessTemplate = "access.html"
+)
+
+// jobPaths find the job a request refers to, so access can be checked in
+// one place instead of in every handler.
+var jobPaths = []struct {
+	re    *regexp.Regexp
+	group int
+}{
+	{validPath, 2},
+	{validAttachmentPath, 1},
+	{validAPIJobPath, 1},
+	{validQueuePath, 4},
+}
+
+// accessStore lists the users allowed into each named queue. A queue with
+// no entry is open to everyone; admins can always see every queue.
+type accessStore struct {
+	sync.RWMutex
+	byQueue map[string][]string
+}
+
+var access = accessStore{byQueue: map[string][]string{}}
+
+type queueAccess struct {
+	Name  string
+	Title string
+	Users []string
+}
+
+func (s *accessStore) load() error {
+	data, err := os.ReadFile(path.Join(contentPath, accessFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := json.Unmarshal(data, &s.byQueue); err != nil {
+		return fmt.Errorf("parse %s: %v", accessFile, err)
+	}
+	return nil
+}
+
+func (s *accessStore) users(queueName string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]string{}, s.byQueue[queueName]...)
+}
+
+func (s *accessStore) grant(queueName string, users []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(users) == 0 {
+		delete(s.byQueue, queueName)
+	} else {
+		s.byQueue[queueName] = users
+	}
+
+	data, err := json.MarshalIndent(s.byQueue, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(contentPath, accessFile), data)
+}
+
+func (s *accessStore) allowed(queueName string, userName string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	users, ok := s.byQueue[queueName]
+	if !ok {
+		return true
+	}
+	for _, name := range users {
+		if name == userName {
+			return true
+		}
+	}
+	return false
+}
+
+func canAccessQueue(r *http.Request, q *queue) bool {
+	return q.name == "" || isAdmin(r) || access.allowed(q.name, currentUser(r).Name)
+}
+
+func canAccessJob(r *http.Request, id int) bool {
+	return canAccessQueue(r, queueOf(id))
+}
+
+func accessibleQueues(r *http.Request) []*queue {
+	visible := []*queue{}
+	for _, q := range queues {
+		if canAccessQueue(r, q) {
+			visible = append(visible, q)
+		}
+	}
+	return visible
+}
+
+func inQueues(name string, list []*queue) bool {
+	for _, q := range list {
+		if q.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleQueues is the queue list offered to the requesting user.
+func visibleQueues(r *http.Request) []queueConfig {
+	visible := []queueConfig{}
+	for _, q := range accessibleQueues(r) {
+		if q.name != "" {
+			visible = append(visible, queueConfig{Name: q.name, Title: q.title})
+		}
+	}
+	return visible
+}
+
+func requestJobID(r *http.Request) (int, bool) {
+	for _, p := range jobPaths {
+		if m := p.re.FindStringSubmatch(r.URL.Path); m != nil {
+			id, err := strconv.Atoi(m[p.group])
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
+
+// accessHandler hides jobs and queues the user has not been granted, as
+// if they did not exist.
+func accessHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Submitters never see the review side, only their own jobs
+		if isSubmitter(r) {
+			if !submitterPath(r) {
+				httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if m := validQueuePath.FindStringSubmatch(r.URL.Path); m != nil {
+			if q, ok := lookupQueue(m[1]); ok && !canAccessQueue(r, q) {
+				notFound(rw, r)
+				return
+			}
+		}
+		if id, ok := requestJobID(r); ok && !canAccessJob(r, id) {
+			notFound(rw, r)
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func queueAccessHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		page := []queueAccess{}
+		for _, q := range queues[1:] {
+			page = append(page, queueAccess{Name: q.name, Title: q.title, Users: access.users(q.name)})
+		}
+		renderTemplate(rw, r, accessTemplate, page)
+		return
+	}
+
+	q, ok := lookupQueue(r.FormValue("queue"))
+	if !ok || q.name == "" {
+		httpError(rw, r, "unknown queue", http.StatusBadRequest)
+		return
+	}
+	users := splitMembers(r.FormValue("users"))
+	for _, name := range users {
+		if !knownUser(name) {
+			httpError(rw, r, fmt.Sprintf("unknown user: %s", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := access.grant(q.name, users); err != nil {
+		warnf("Access update failed: %s [%v]\n", q.name, err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, urlFor(accessPath), http.StatusSeeOther)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiJobsPath = "/jobs"
+	maxMemory   = 32 << 20
+)
+
+// Jobs come in through the API directly or relayed by a gateway, such as
+// a mail bridge or a webhook receiver, that submits on behalf of the
+// actual sender. Only admin accounts may relay.
+const (
+	sourceAPI     = "api"
+	sourceEmail   = "email"
+	sourceWebhook = "webhook"
+
+	submitterHeader = "X-Submitter"
+	sourceHeader    = "X-Submission-Source"
+)
+
+var validSubmitter = regexp.MustCompile(`^[^\x00-\x1f\x7f]{1,254}$`)
+
+var errNotRelay = errors.New("only admins may submit on behalf of others")
+
+var validAPIJobsPath = regexp.MustCompile("^/api/v[0-9]+/jobs$")
+var validAPIJobPath = regexp.MustCompile("^/api/v[0-9]+/jobs/([0-9]+)(/attachments|/revisions|/diff|/decision|/appeal|/rereview)?(?:/([0-9]+))?$")
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		warnf("Encode failed: %v\n", err)
+	}
+}
+
+func isMultipart(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// submissionBody reads the job body either from the "body" field of a
+// multipart form or from the raw request body.
+func submissionBody(r *http.Request) ([]byte, error) {
+	if !isMultipart(r) {
+		return io.ReadAll(r.Body)
+	}
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return nil, err
+	}
+	if file, _, err := r.FormFile("body"); err == nil {
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+	return []byte(r.FormValue("body")), nil
+}
+
+// submissionUpload spools the body of a new job to disk as it arrives,
+// from the request body or the body part of a multipart form.
+func submissionUpload(r *http.Request) (*spooledBody, error) {
+	if !isMultipart(r) {
+		return spoolBody(r.Body)
+	}
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return nil, err
+	}
+	if file, _, err := r.FormFile("body"); err == nil {
+		defer file.Close()
+		return spoolBody(file)
+	}
+	return spoolBody(strings.NewReader(r.FormValue("body")))
+}
+
+// inspectsContent reports whether anything configured looks at the
+// content of new jobs in q, which then has to be read into memory.
+func inspectsContent(q *queue) bool {
+	return q.schema != nil || len(cfg.Rules) > 0 || cfg.Scoring.URL != "" || len(cfg.Hooks.OnIngest) > 0
+}
+
+// writeSubmitError answers a submission that submitJob failed.
+func writeSubmitError(rw http.ResponseWriter, r *http.Request, err error) {
+	var refused *submitRefusal
+	switch {
+	case errors.As(err, &refused):
+		writeAPIError(rw, r, refused.status, refused.apiError)
+	case errors.Is(err, errScanUnavailable):
+		warnf("Virus scan failed: [%v]\n", err)
+		writeError(rw, r, http.StatusServiceUnavailable, codeUnavailable, errScanUnavailable.Error())
+	default:
+		warnf("Submit failed: %v\n", err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// submissionIdentity tells who a submission is from and how it came in.
+// Relays name the sender in X-Submitter, or the submitter form field, and
+// the channel in X-Submission-Source; the relaying account is kept too.
+func submissionIdentity(r *http.Request) (jobMeta, error) {
+	account := currentUser(r).Name
+	id := jobMeta{Submitter: account, Source: sourceAPI}
+
+	sender := r.Header.Get(submitterHeader)
+	if sender == "" {
+		sender = r.FormValue("submitter")
+	}
+	source := r.Header.Get(sourceHeader)
+	if sender == "" && source == "" {
+		return id, nil
+	}
+	if !isAdmin(r) {
+		return id, errNotRelay
+	}
+
+	switch source {
+	case "":
+	case sourceAPI, sourceEmail, sourceWebhook:
+		id.Source = source
+	default:
+		return id, fmt.Errorf("unknown source: %q", source)
+	}
+	if sender != "" {
+		sender = strings.TrimSpace(sender)
+		if !validSubmitter.MatchString(sender) {
+			return id, fmt.Errorf("invalid submitter: %q", sender)
+		}
+		id.Submitter = sender
+	}
+	if id.Submitter != account {
+		id.RelayedBy = account
+	}
+	return id, nil
+}
+
+func apiJobsHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		apiListJobs(rw, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	upload, err := submissionUpload(r)
+	if writeTooLarge(rw, r, err) {
+		return
+	}
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer upload.close()
+	if upload.size == 0 {
+		writeError(rw, r, http.StatusBadRequest, codeEmptyBody, "empty job body")
+		return
+	}
+
+	team := r.FormValue("team")
+	if team != "" && !teams.exists(team) {
+		writeError(rw, r, http.StatusBadRequest, codeUnknownTeam, "unknown team")
+		return
+	}
+
+	q, ok := lookupQueue(r.FormValue("queue"))
+	if !ok || !canAccessQueue(r, q) {
+		writeError(rw, r, http.StatusBadRequest, codeUnknownQueue, "unknown queue")
+		return
+	}
+
+	fields, err := parseFields(q.fields, r)
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidField, err.Error())
+		return
+	}
+	if writeTooLarge(rw, r, checkAttachmentSizes(r.MultipartForm)) {
+		return
+	}
+	if err := checkAttachmentNames(r.MultipartForm); err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidAttachment, err.Error())
+		return
+	}
+
+	identity, err := submissionIdentity(r)
+	if err == errNotRelay {
+		writeError(rw, r, http.StatusForbidden, codeForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidSubmitter, err.Error())
+		return
+	}
+
+	m, err := submitJob(q, upload, r.MultipartForm, jobMeta{
+		Team:      team,
+		Fields:    fields,
+		Submitter: identity.Submitter,
+		Source:    identity.Source,
+		RelayedBy: identity.RelayedBy,
+	})
+	if err != nil {
+		writeSubmitError(rw, r, err)
+		return
+	}
+
+	rw.Header().Set("Location", apiURL(r, apiJobsPath, "/", strconv.Itoa(m.ID)))
+	writeJSON(rw, http.StatusCreated, m)
+}
+
+func apiJobHandler(rw http.ResponseWriter, r *http.Request) {
+	match := validAPIJobPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(match[1])
+	if err != nil {
+		notFound(rw, r)
+		return
+	}
+
+	m, ok := metadata.get(id)
+	if !ok {
+		notFound(rw, r)
+		return
+	}
+	// Submitters only get here to appeal, which checks they may
+	if match[2] != "/appeal" && !canViewJob(rw, r, id, findState(id)) {
+		return
+	}
+
+	switch {
+	case match[2] == "" && match[3] == "" && r.Method == http.MethodGet:
+		rw.Header().Set("ETag", jobETag(m, findState(id)))
+		writeJSON(rw, http.StatusOK, m)
+
+	case match[2] == "" && match[3] == "" && r.Method == http.MethodPut:
+		apiResubmit(rw, r, m)
+
+	case match[2] == "/attachments" && match[3] == "" && r.Method == http.MethodPost:
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			if !writeTooLarge(rw, r, err) {
+				httpError(rw, r, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if err := checkAttachmentNames(r.MultipartForm); err != nil {
+			writeError(rw, r, http.StatusBadRequest, codeInvalidAttachment, err.Error())
+			return
+		}
+		if err := scanChange(id, currentUser(r).Name, nil, r.MultipartForm); err != nil {
+			writeSubmitError(rw, r, err)
+			return
+		}
+		added, err := addAttachments(id, r.MultipartForm)
+		if writeTooLarge(rw, r, err) {
+			return
+		}
+		if err != nil {
+			warnf("Attachment failed: ID: %d [%v]\n", id, err)
+			writeError(rw, r, http.StatusBadRequest, codeInvalidAttachment, err.Error())
+			return
+		}
+		writeJSON(rw, http.StatusCreated, added)
+
+	case match[2] == "/revisions" && r.Method == http.MethodGet:
+		if match[3] == "" {
+			writeJSON(rw, http.StatusOK, jobRevisions(m))
+			return
+		}
+		rev, _ := strconv.Atoi(match[3])
+		body, err := readRevision(id, rev)
+		if err != nil {
+			notFound(rw, r)
+			return
+		}
+		setRawHeaders(rw, r, sniffContentType(body), revisionName(id, rev))
+		body, _ = displayBody(r, id, body)
+		rw.Write(body)
+
+	case match[2] == "/diff" && match[3] == "" && r.Method == http.MethodGet:
+		a, b := parseRevisionPair(r, m)
+		hunks, binary, err := diffRevisions(r, id, a, b)
+		if err != nil {
+			notFound(rw, r)
+			return
+		}
+		if binary {
+			writeError(rw, r, http.StatusUnprocessableEntity, codeBinaryDiff, "binary revisions can't be diffed")
+			return
+		}
+		rw.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		fmt.Fprint(rw, formatUnified(revisionName(id, a), revisionName(id, b), hunks))
+
+	case match[2] == "/decision" && match[3] == "" && r.Method == http.MethodPost:
+		idempotent(func(rw http.ResponseWriter, r *http.Request) {
+			apiDecide(rw, r, id)
+		})(rw, r)
+
+	case match[2] == "/appeal" && match[3] == "" && r.Method == http.MethodPost:
+		idempotent(func(rw http.ResponseWriter, r *http.Request) {
+			apiAppeal(rw, r, id)
+		})(rw, r)
+
+	case match[2] == "/rereview" && match[3] == "" && r.Method == http.MethodPost:
+		idempotent(func(rw http.ResponseWriter, r *http.Request) {
+			apiRereview(rw, r, id)
+		})(rw, r)
+
+	default:
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decisionReport describes a decision taken, or with dry_run=1 one that
+// would be taken.
+type decisionReport struct {
+	ID     int    `json:"id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+	By     string `json:"by"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// checkDecision validates moving job id to dest for reason on behalf of the
+// request's user and returns the HTTP status and error to fail with.
+func checkDecision(r *http.Request, id int, dest string, reason string) (decisionReport, int, *apiError) {
+	userName := currentUser(r).Name
+	report := decisionReport{ID: id, To: dest, Reason: reason, By: userName}
+
+	switch dest {
+	case "accept", "reject", "escalate":
+	default:
+		return report, http.StatusBadRequest, &apiError{Code: codeInvalidDecision, Message: fmt.Sprintf("invalid decision: %q", dest)}
+	}
+
+	report.From = findState(id)
+	if report.From == "" {
+		return report, http.StatusNotFound, &apiError{Code: codeNotFound, Message: fmt.Sprintf("entry not present: %d", id)}
+	}
+	if status, failure := checkIfMatch(r, id); failure != nil {
+		return report, status, failure
+	}
+	switch {
+	case !canMove(report.From, dest):
+		return report, http.StatusConflict, &apiError{
+			Code:    codeInvalidTransition,
+			Message: fmt.Sprintf("job %d can't move from %s to %s", id, report.From, dest),
+			Details: map[string]string{"from": report.From, "to": dest},
+		}
+	case !canDecide(r, id, report.From):
+		return report, http.StatusForbidden, &apiError{Code: codeForbidden, Message: tr(r, "forbidden")}
+	case heldByOther(id, userName, time.Now()):
+		c, _ := claims.get(id, time.Now())
+		return report, http.StatusConflict, &apiError{
+			Code:    codeClaimed,
+			Message: fmt.Sprintf("job %d is claimed by %s", id, c.User),
+			Details: map[string]interface{}{"claimed_by": c.User, "expires": c.Expires},
+		}
+	}
+	if err := checkDecisionHook(report); err != nil {
+		return report, http.StatusUnprocessableEntity, &apiError{Code: codeHookRefused, Message: err.Error()}
+	}
+	return report, http.StatusOK, nil
+}
+
+// update is the update that applies a decision passed by checkDecision.
+func (d decisionReport) update() msg {
+	return msg{id: d.ID, dest: d.To, reason: d.Reason, user: d.By, checked: true}
+}
+
+// apiDecide accepts, rejects or escalates a job. With dry_run=1 the
+// decision is only validated and reported.
+func apiDecide(rw http.ResponseWriter, r *http.Request, id int) {
+	report, status, failure := checkDecision(r, id, r.FormValue("decision"), decisionReason(r))
+	if failure != nil {
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		report.DryRun = true
+		writeJSON(rw, http.StatusOK, report)
+		return
+	}
+
+	if !enqueueUpdate(report.update()) {
+		updateQueueFull(rw, r)
+		return
+	}
+	writeJSON(rw, http.StatusAccepted, report)
+}
+
+func revisionName(id int, rev int) string {
+	return fmt.Sprintf("%d@%d", id, rev)
+}
+
+// apiResubmit replaces the body of a job still under review, keeping the
+// previous body as a revision.
+func apiResubmit(rw http.ResponseWriter, r *http.Request, m jobMeta) {
+	body, err := submissionBody(r)
+	if err == nil {
+		err = checkJobSize(int64(len(body)))
+	}
+	if writeTooLarge(rw, r, err) {
+		return
+	}
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) == 0 {
+		writeError(rw, r, http.StatusBadRequest, codeEmptyBody, "empty job body")
+		return
+	}
+	if err := checkSchema(queueOf(m.ID), body); err != nil {
+		writeSubmitError(rw, r, err)
+		return
+	}
+	if err := scanChange(m.ID, currentUser(r).Name, body, nil); err != nil {
+		writeSubmitError(rw, r, err)
+		return
+	}
+
+	title := m.Title
+	if r.MultipartForm != nil && r.FormValue("title") != "" {
+		title = r.FormValue("title")
+	}
+
+	// Fields are only replaced when the resubmission carries any
+	var fields map[string]string
+	if hasFieldValues(r) {
+		fields, err = parseFields(queueOf(m.ID).fields, r)
+		if err != nil {
+			writeError(rw, r, http.StatusBadRequest, codeInvalidField, err.Error())
+			return
+		}
+	}
+
+	m, err = saveRevision(m.ID, m.Revision, title, body, fields)
+	if err != nil {
+		warnf("Resubmit failed: ID: %d [%v]\n", m.ID, err)
+		if err == errConflict {
+			writeError(rw, r, http.StatusConflict, codeStaleRevision, err.Error())
+			return
+		}
+		httpError(rw, r, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(rw, http.StatusOK, m)
+}
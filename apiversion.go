@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const apiPrefix = "/api/"
+
+// apiVersionConfig retires an API version: from deprecated on, responses
+// carry a Deprecation header, and a Sunset header if sunset is set. Past
+// the sunset the version answers 410 Gone. successor is the URL of the
+// version that replaces it, sent as a Link header.
+type apiVersionConfig struct {
+	Deprecated *time.Time `json:"deprecated"`
+	Sunset     *time.Time `json:"sunset"`
+	Successor  string     `json:"successor"`
+}
+
+// apiVersions registers the routes of each served API version. A new
+// version gets its own function, reusing the handlers that didn't change,
+// and both are served side by side until the old one is sunset.
+var apiVersions = []struct {
+	name     string
+	register func(version string)
+}{
+	{"v1", registerAPIv1},
+}
+
+type apiVersionKey struct{}
+
+func registerAPI() {
+	for _, v := range apiVersions {
+		v.register(v.name)
+	}
+}
+
+func registerAPIv1(version string) {
+	handleAPI(version, apiStatsPath, apiStatsHandler)
+	handleAPI(version, apiLeaderboardPath, apiLeaderboardHandler)
+	handleAPI(version, apiJobsPath, apiJobsHandler)
+	handleAPI(version, apiJobsPath+"/", apiJobHandler)
+	handleAPI(version, apiBatchGetPath, apiBatchGetHandler)
+	handleAPI(version, apiObjectsPath, apiObjectHandler)
+	handleAPI(version, apiErasurePath, apiErasureHandler)
+	handleAPI(version, apiHoldsPath, apiHoldsHandler)
+	handleAPI(version, apiSubscriptionsPath, apiSubscriptionsHandler)
+	handleAPI(version, apiQueuesPath, apiQueueCountHandler)
+}
+
+func validateAPIVersions(c map[string]apiVersionConfig) error {
+	for name, vc := range c {
+		known := false
+		for _, v := range apiVersions {
+			known = known || v.name == name
+		}
+		if !known {
+			return fmt.Errorf("api_versions: unknown version %q", name)
+		}
+		if vc.Sunset != nil && vc.Deprecated != nil && vc.Sunset.Before(*vc.Deprecated) {
+			return fmt.Errorf("api_versions: %s is sunset before it is deprecated", name)
+		}
+	}
+	return nil
+}
+
+// apiRoute is the path of rel in version, e.g. /api/v1/jobs.
+func apiRoute(version string, rel string) string {
+	return apiPrefix + version + rel
+}
+
+func handleAPI(version string, rel string, h http.HandlerFunc) {
+	http.Handle(apiRoute(version, rel), apiVersionHandler(version, h))
+}
+
+// apiVersionOf returns the API version a request was routed to.
+func apiVersionOf(r *http.Request) string {
+	version, _ := r.Context().Value(apiVersionKey{}).(string)
+	return version
+}
+
+// apiURL links to rel in the API version of the request.
+func apiURL(r *http.Request, rel ...string) string {
+	return urlFor(apiRoute(apiVersionOf(r), strings.Join(rel, "")))
+}
+
+func apiVersionHandler(version string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version))
+
+		vc := cfg.APIVersions[version]
+		now := time.Now()
+		if vc.Deprecated != nil && !now.Before(*vc.Deprecated) {
+			// RFC 9745 wants the Unix time
+			rw.Header().Set("Deprecation", fmt.Sprintf("@%d", vc.Deprecated.Unix()))
+		}
+		if vc.Sunset != nil {
+			rw.Header().Set("Sunset", vc.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if vc.Successor != "" {
+			rw.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, vc.Successor))
+		}
+		if vc.Sunset != nil && now.After(*vc.Sunset) {
+			writeError(rw, r, http.StatusGone, codeAPIVersionGone, fmt.Sprintf("API %s was retired on %s", version, vc.Sunset.UTC().Format(time.RFC3339)))
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	appealPath      = "/appeal/"
+	appealsPath     = "/appeals"
+	appealsTemplate = "appeals.html"
+)
+
+// appeal records a submitter contesting a rejection. The rejection it
+// contests is kept alongside, since deciding the appeal replaces it.
+type appeal struct {
+	By       string    `json:"by"`
+	Grounds  string    `json:"grounds,omitempty"`
+	At       time.Time `json:"at"`
+	Original decision  `json:"original"`
+}
+
+type appealedJob struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title,omitempty"`
+	Submitter string `json:"submitter,omitempty"`
+	appeal
+}
+
+type appealsPage struct {
+	Jobs []appealedJob `json:"jobs"`
+}
+
+// isSenior reports whether the user may decide appeals.
+func isSenior(r *http.Request) bool {
+	return isAdmin(r) || currentUser(r).Senior
+}
+
+// canAppeal checks that the request's user may appeal job m, that is the
+// submitter or an admin acting for them, and that it is a rejection not
+// appealed before. Every rejection can be appealed once.
+func canAppeal(r *http.Request, m jobMeta) (int, *apiError) {
+	if m.Submitter != currentUser(r).Name && !isAdmin(r) {
+		return http.StatusForbidden, &apiError{Code: codeForbidden, Message: "only the submitter may appeal"}
+	}
+	if m.Appeal != nil {
+		return http.StatusConflict, &apiError{
+			Code:    codeAlreadyAppealed,
+			Message: fmt.Sprintf("job %d was already appealed", m.ID),
+			Details: map[string]interface{}{"appealed_at": m.Appeal.At},
+		}
+	}
+	if state := findState(m.ID); !canMove(state, "appeal") {
+		return http.StatusConflict, &apiError{
+			Code:    codeInvalidTransition,
+			Message: fmt.Sprintf("job %d can't move from %s to appeal", m.ID, state),
+			Details: map[string]string{"from": state, "to": "appeal"},
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func recordAppeal(m msg) error {
+	meta, err := metadata.update(m.id, func(meta *jobMeta) error {
+		meta.Appeal = &appeal{
+			By:      m.user,
+			Grounds: m.reason,
+			At:      time.Now().UTC(),
+		}
+		if meta.Decision != nil {
+			meta.Appeal.Original = *meta.Decision
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	publishEvent(eventAppealed, m.id, jobEvent{User: m.user, State: m.dest, Reason: m.reason, At: meta.Appeal.At})
+	return nil
+}
+
+// checkAppeal validates appealing job id on behalf of the request's user,
+// with the form field "grounds" saying why.
+func checkAppeal(r *http.Request, id int) (decisionReport, int, *apiError) {
+	report := decisionReport{ID: id, To: "appeal", Reason: strings.TrimSpace(r.FormValue("grounds")), By: currentUser(r).Name}
+	m, ok := metadata.get(id)
+	if !ok {
+		return report, http.StatusNotFound, &apiError{Code: codeNotFound, Message: fmt.Sprintf("entry not present: %d", id)}
+	}
+	report.From = findState(id)
+	if status, failure := canAppeal(r, m); failure != nil {
+		return report, status, failure
+	}
+	return report, http.StatusOK, nil
+}
+
+// appealHandler files an appeal from the submitter portal.
+func appealHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Appeal failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Appeal failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+
+	report, status, failure := checkAppeal(r, id)
+	if failure != nil {
+		warnf("Appeal failed: ID: %d [%s]\n", id, failure.Message)
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+	if !enqueueUpdate(msg{id: id, dest: report.To, reason: report.Reason, user: report.By}) {
+		updateQueueFull(rw, r)
+		return
+	}
+	http.Redirect(rw, r, urlFor(portalPath), http.StatusSeeOther)
+}
+
+// apiAppeal files an appeal through the API.
+func apiAppeal(rw http.ResponseWriter, r *http.Request, id int) {
+	report, status, failure := checkAppeal(r, id)
+	if failure != nil {
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+	if !enqueueUpdate(msg{id: id, dest: report.To, reason: report.Reason, user: report.By}) {
+		updateQueueFull(rw, r)
+		return
+	}
+	writeJSON(rw, http.StatusAccepted, report)
+}
+
+// appealsHandler lists the jobs waiting for a senior reviewer, oldest
+// appeal first.
+func appealsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !isSenior(r) {
+		httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+		return
+	}
+
+	page := appealsPage{Jobs: []appealedJob{}}
+	for _, q := range queues {
+		for _, id := range q.ids("appeal") {
+			job := appealedJob{ID: id}
+			if m, ok := metadata.get(id); ok {
+				job.Title = m.Title
+				job.Submitter = m.Submitter
+				if m.Appeal != nil {
+					job.appeal = *m.Appeal
+				}
+			}
+			page.Jobs = append(page.Jobs, job)
+		}
+	}
+	sort.Slice(page.Jobs, func(i, j int) bool {
+		return page.Jobs[i].At.Before(page.Jobs[j].At)
+	})
+
+	rw.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		writeJSON(rw, http.StatusOK, page)
+		return
+	}
+	renderTemplate(rw, r, appealsTemplate, page)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	assignmentsPath     = "/admin/assignments"
+	assignmentsTemplate = "assignments.html"
+)
+
+type assignmentRow struct {
+	ID    int
+	Title string
+	claim
+}
+
+type assignmentsPage struct {
+	Claims []assignmentRow
+	Users  []string
+}
+
+// reassign moves the claim on id to userName and records the handoff.
+func reassign(id int, userName string, actor string, note string) error {
+	if findState(id) != "review" {
+		return fmt.Errorf("entry not under review: %d", id)
+	}
+	previous := claims.assign(id, userName, time.Now().UTC())
+	publishEvent(eventClaimed, id, jobEvent{User: userName})
+	return audit(auditEntry{
+		Actor:  actor,
+		Action: "reassign",
+		ID:     id,
+		From:   previous,
+		To:     userName,
+		Note:   note,
+	})
+}
+
+func knownUser(name string) bool {
+	if !authEnabled() {
+		return name != ""
+	}
+	_, ok := lookupUser(name)
+	return ok
+}
+
+func assignmentsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		page := assignmentsPage{}
+		for id, c := range claims.active(time.Now()) {
+			row := assignmentRow{ID: id, claim: c}
+			if m, ok := metadata.get(id); ok {
+				row.Title = m.Title
+			}
+			page.Claims = append(page.Claims, row)
+		}
+		sort.Slice(page.Claims, func(i, j int) bool {
+			return page.Claims[i].ID < page.Claims[j].ID
+		})
+		for _, u := range cfg.Users {
+			page.Users = append(page.Users, u.Name)
+		}
+		renderTemplate(rw, r, assignmentsTemplate, page)
+		return
+	}
+
+	to := strings.TrimSpace(r.FormValue("to"))
+	if !knownUser(to) {
+		httpError(rw, r, "unknown user", http.StatusBadRequest)
+		return
+	}
+
+	// Either a single job by id, or everything held by a reviewer.
+	ids := []int{}
+	if from := strings.TrimSpace(r.FormValue("from")); from != "" {
+		ids = claims.heldBy(from, time.Now())
+	} else {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			httpError(rw, r, "invalid id", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	actor := currentUser(r).Name
+	note := strings.TrimSpace(r.FormValue("note"))
+	for _, id := range ids {
+		if err := reassign(id, to, actor, note); err != nil {
+			warnf("Reassign failed: ID: %d [%v]\n", id, err)
+			httpError(rw, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	http.Redirect(rw, r, urlFor(assignmentsPath), http.StatusSeeOther)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+const (
+	attachmentDir  = "attachments"
+	attachmentPath = "/attachments/"
+)
+
+type attachment struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+var validAttachmentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+var validAttachmentPath = regexp.MustCompile(`^/attachments/([0-9]+)/([^/]+)$`)
+
+func attachmentFile(id int, name string) string {
+	return path.Join(contentPath, attachmentDir, strconv.Itoa(id), name)
+}
+
+// checkAttachmentNames checks the names of the attachments of form before
+// any of them is saved.
+func checkAttachmentNames(form *multipart.Form) error {
+	if form == nil {
+		return nil
+	}
+	for _, header := range form.File["attachment"] {
+		if !validAttachmentName.MatchString(path.Base(header.Filename)) {
+			return fmt.Errorf("invalid attachment name: %q", header.Filename)
+		}
+	}
+	return nil
+}
+
+// saveAttachment copies an uploaded part into the job's attachment
+// directory. Existing attachments with the same name are replaced.
+func saveAttachment(id int, header *multipart.FileHeader) (attachment, error) {
+	name := path.Base(header.Filename)
+	if !validAttachmentName.MatchString(name) {
+		return attachment{}, fmt.Errorf("invalid attachment name: %q", header.Filename)
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return attachment{}, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(path.Dir(attachmentFile(id, name)), 0755); err != nil {
+		return attachment{}, err
+	}
+
+	file := attachmentFile(id, name)
+	dst, err := os.Create(file + ".tmp")
+	if err != nil {
+		return attachment{}, err
+	}
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(src, head)
+	size, err := io.Copy(dst, io.MultiReader(bytes.NewReader(head[:n]), src))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		os.Remove(file + ".tmp")
+		return attachment{}, err
+	}
+
+	return attachment{
+		Name:        name,
+		Size:        size,
+		ContentType: http.DetectContentType(head[:n]),
+	}, nil
+}
+
+// addAttachments stores all "attachment" parts of a multipart form and
+// records them in the job metadata.
+func addAttachments(id int, form *multipart.Form) ([]attachment, error) {
+	if form == nil || len(form.File["attachment"]) == 0 {
+		return nil, nil
+	}
+
+	if _, ok := metadata.get(id); !ok {
+		return nil, fmt.Errorf("entry not present: %d", id)
+	}
+	if err := checkAttachmentSizes(form); err != nil {
+		return nil, err
+	}
+	if err := checkAttachmentNames(form); err != nil {
+		return nil, err
+	}
+
+	added := []attachment{}
+	for _, header := range form.File["attachment"] {
+		a, err := saveAttachment(id, header)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, a)
+	}
+
+	_, err := metadata.update(id, func(m *jobMeta) error {
+		for _, a := range added {
+			m.Attachments = replaceAttachment(m.Attachments, a)
+		}
+		return nil
+	})
+	return added, err
+}
+
+// replaceAttachment returns list with a in place of the attachment of the
+// same name, or added. list itself is left as it was.
+func replaceAttachment(list []attachment, a attachment) []attachment {
+	replaced := []attachment{}
+	found := false
+	for _, old := range list {
+		if old.Name == a.Name {
+			old, found = a, true
+		}
+		replaced = append(replaced, old)
+	}
+	if !found {
+		replaced = append(replaced, a)
+	}
+	return replaced
+}
+
+func findAttachment(id int, name string) (attachment, error) {
+	m, ok := metadata.get(id)
+	if !ok {
+		return attachment{}, fmt.Errorf("entry not present: %d", id)
+	}
+	for _, a := range m.Attachments {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+	return attachment{}, errors.New("attachment not present")
+}
+
+func attachmentHandler(rw http.ResponseWriter, r *http.Request) {
+	m := validAttachmentPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		notFound(rw, r)
+		return
+	}
+
+	a, err := findAttachment(id, m[2])
+	if err != nil {
+		warnf("Attachment failed: ID: %d %s [%v]\n", id, m[2], err)
+		notFound(rw, r)
+		return
+	}
+
+	file, err := os.Open(attachmentFile(id, a.Name))
+	if err != nil {
+		warnf("Attachment failed: ID: %d %s [%v]\n", id, a.Name, err)
+		notFound(rw, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", a.ContentType)
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": a.Name,
+	}))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Set("Content-Security-Policy", "sandbox")
+
+	http.ServeContent(rw, r, a.Name, info.ModTime(), file)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+const auditFile = "audit.log"
+
+// auditEntry is one line of the append-only audit log.
+type auditEntry struct {
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	ID     int       `json:"id,omitempty"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Note   string    `json:"note,omitempty"`
+}
+
+var auditLog = newRotatingFile(path.Join(contentPath, auditFile))
+
+func audit(e auditEntry) error {
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err := auditLog.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit write: %v", err)
+	}
+	shipLog("audit", e.At, e)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	roleReviewer  = "reviewer"
+	roleAdmin     = "admin"
+	roleSubmitter = "submitter"
+)
+
+const anonymousUser = "anonymous"
+
+type user struct {
+	Name           string   `json:"name"`
+	PasswordSHA256 string   `json:"password_sha256"`
+	Role           string   `json:"role"`
+	Unmask         bool     `json:"unmask"`
+	Senior         bool     `json:"senior"`
+	Notify         delivery `json:"notify"`
+}
+
+type userKey struct{}
+
+func authEnabled() bool {
+	return len(cfg.Users) > 0
+}
+
+func lookupUser(name string) (*user, bool) {
+	for i := range cfg.Users {
+		if cfg.Users[i].Name == name {
+			return &cfg.Users[i], true
+		}
+	}
+	return nil, false
+}
+
+func checkPassword(u *user, password string) bool {
+	sum := sha256.Sum256([]byte(password))
+	want, err := hex.DecodeString(u.PasswordSHA256)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(sum[:], want) == 1
+}
+
+func validateUsers(users []user) error {
+	seen := map[string]bool{}
+	for _, u := range users {
+		if u.Name == "" || u.Name == anonymousUser {
+			return fmt.Errorf("invalid user name: %q", u.Name)
+		}
+		if seen[u.Name] {
+			return fmt.Errorf("duplicate user: %s", u.Name)
+		}
+		seen[u.Name] = true
+		if _, err := hex.DecodeString(u.PasswordSHA256); err != nil || len(u.PasswordSHA256) != 2*sha256.Size {
+			return fmt.Errorf("user %s: password_sha256 must be a hex SHA-256 digest", u.Name)
+		}
+		switch u.Role {
+		case roleReviewer, roleAdmin, roleSubmitter:
+		default:
+			return fmt.Errorf("user %s: unknown role %q", u.Name, u.Role)
+		}
+	}
+	return nil
+}
+
+// currentUser returns the authenticated user. With no users configured the
+// server is open and everyone acts as an anonymous admin.
+func currentUser(r *http.Request) *user {
+	if u, ok := r.Context().Value(userKey{}).(*user); ok {
+		return u
+	}
+	return &user{Name: anonymousUser, Role: roleAdmin}
+}
+
+func isAdmin(r *http.Request) bool {
+	return currentUser(r).Role == roleAdmin
+}
+
+func isSubmitter(r *http.Request) bool {
+	return currentUser(r).Role == roleSubmitter
+}
+
+func authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Stylesheets, scripts and fonts are the same for everyone
+		if !authEnabled() || strings.HasPrefix(r.URL.Path, staticPath) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		name, password, ok := r.BasicAuth()
+		u, found := lookupUser(name)
+		if !ok || !found || !checkPassword(u, password) {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="jobServer", charset="UTF-8"`)
+			httpError(rw, r, tr(r, "unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+func requireAdmin(rw http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(r) {
+		return true
+	}
+	httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+	return false
+}
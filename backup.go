@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	backupPath = "/admin/backup"
+	backupDir  = "backup"
+)
+
+// A backup is a tar.gz of the data directory, taken while the server runs.
+// To make it consistent, the update workers are paused between updates
+// and the state log is held while every file is hard linked into a
+// snapshot under data/backup; that takes as long as listing the files,
+// not copying them. Files are replaced by renaming, so the links keep the
+// content as it was; for files that are appended to, only what was there
+// at the snapshot is archived. The archive is then streamed from the
+// snapshot, which is removed afterwards.
+//
+// Restoring is unpacking the archive in place of the data directory.
+// A write_timeout, if set, must be long enough to stream the archive.
+
+// backupFile is one file of a snapshot.
+type backupFile struct {
+	name string
+	size int64
+	info os.FileInfo
+}
+
+// backupSkipped tells whether a path under the data directory, given
+// relative to it, is left out of backups: the snapshots themselves,
+// uploads still being spooled and half written files.
+func backupSkipped(rel string, dir bool) bool {
+	if dir {
+		return rel == backupDir || rel == spoolDir
+	}
+	return strings.HasSuffix(rel, ".tmp")
+}
+
+// snapshotData links every file of the data directory into a new snapshot
+// directory and returns it with the files taken.
+func snapshotData() (string, []backupFile, error) {
+	root := path.Join(contentPath, backupDir, strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", nil, err
+	}
+
+	updatesPaused.Lock()
+	defer updatesPaused.Unlock()
+	stateLog.Lock()
+	defer stateLog.Unlock()
+
+	files := []backupFile{}
+	err := filepath.WalkDir(contentPath, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contentPath, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if backupSkipped(rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		link := path.Join(root, rel)
+		if err := os.MkdirAll(path.Dir(link), 0700); err != nil {
+			return err
+		}
+		if err := os.Link(name, link); os.IsNotExist(err) {
+			// Renamed or removed since it was listed
+			return nil
+		} else if err != nil {
+			return err
+		}
+		info, err := os.Stat(link)
+		if err != nil {
+			return err
+		}
+		files = append(files, backupFile{name: rel, size: info.Size(), info: info})
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(root)
+		return "", nil, err
+	}
+	return root, files, nil
+}
+
+// writeBackup writes the files of a snapshot to w as a tar.gz under
+// "data/". Files sharing storage through object links are archived once
+// and linked to from the others.
+func writeBackup(w io.Writer, root string, files []backupFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	type fileKey struct {
+		size    int64
+		modTime time.Time
+	}
+	written := map[fileKey][]backupFile{}
+	for _, f := range files {
+		header, err := tar.FileInfoHeader(f.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(contentPath, f.name)
+		header.Size = f.size
+
+		key := fileKey{f.size, f.info.ModTime()}
+		for _, seen := range written[key] {
+			if os.SameFile(seen.info, f.info) {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = path.Join(contentPath, seen.name)
+				header.Size = 0
+				break
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeLink {
+			continue
+		}
+		written[key] = append(written[key], f)
+
+		src, err := os.Open(path.Join(root, f.name))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, src, f.size)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// clearBackups removes snapshots left behind by a crash mid-backup. They
+// would otherwise keep unused objects alive.
+func clearBackups() {
+	if err := os.RemoveAll(path.Join(contentPath, backupDir)); err != nil {
+		warnf("Backup cleanup failed: %v\n", err)
+	}
+}
+
+// backupHandler streams a backup of the data directory.
+func backupHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start := time.Now()
+	root, files, err := snapshotData()
+	if err != nil {
+		warnf("Backup snapshot failed: %v\n", err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(root)
+	debugf("Backup snapshot of %d files taken in %v\n", len(files), time.Since(start))
+
+	user := currentUser(r).Name
+	if err := audit(auditEntry{Actor: user, Action: "backup"}); err != nil {
+		warnf("Backup audit failed: %v\n", err)
+	}
+
+	name := "jobserver-backup-" + start.UTC().Format("20060102T150405Z") + ".tar.gz"
+	rw.Header().Set("Content-Type", "application/gzip")
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := writeBackup(rw, root, files); err != nil {
+		// The status is out already; the client is left with a broken archive
+		warnf("Backup failed: %v\n", err)
+		return
+	}
+	infof("Backup of %d files streamed to %s in %v\n", len(files), user, time.Since(start))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	apiBatchGetPath = apiJobsPath + ":batchGet"
+	maxBatchGet     = 100
+)
+
+// batchJob is one job of a batchGet: its metadata and state, and its body
+// when asked for. Bodies that aren't UTF-8 text come base64 encoded.
+type batchJob struct {
+	ID           int     `json:"id"`
+	State        string  `json:"state"`
+	ETag         string  `json:"etag"`
+	Meta         jobMeta `json:"meta"`
+	Body         *string `json:"body,omitempty"`
+	BodyEncoding string  `json:"body_encoding,omitempty"`
+}
+
+type batchResult struct {
+	Jobs    []batchJob `json:"jobs"`
+	Missing []int      `json:"missing,omitempty"`
+}
+
+// batchIDs reads ids, given repeated or comma separated, in order and
+// without repeats.
+func batchIDs(r *http.Request) ([]int, error) {
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, value := range r.Form["ids"] {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			id, err := strconv.Atoi(field)
+			if err != nil || id < 1 {
+				return nil, fmt.Errorf("invalid id: %q", field)
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("ids is required")
+	}
+	if len(ids) > maxBatchGet {
+		return nil, fmt.Errorf("at most %d ids per request", maxBatchGet)
+	}
+	return ids, nil
+}
+
+// apiBatchGetHandler returns several jobs in one round trip. Jobs that
+// don't exist or that the user can't see are listed under missing, alike,
+// rather than failing the batch.
+func apiBatchGetHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := batchIDs(r)
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	withBody, _ := strconv.ParseBool(r.FormValue("include_body"))
+
+	result := batchResult{Jobs: []batchJob{}}
+	for _, id := range ids {
+		m, ok := metadata.get(id)
+		state := findState(id)
+		if !ok || state == "" || !canAccessJob(r, id) || !canSeeJob(r, id) {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+		job := batchJob{ID: id, State: state, ETag: jobETag(m, state), Meta: m}
+		if withBody {
+			body, err := readBody(jobFile(id, state))
+			if err != nil {
+				warnf("Batch read failed: ID: %d [%v]\n", id, err)
+				writeError(rw, r, http.StatusInternalServerError, codeInternal, "failed to read job body")
+				return
+			}
+			text := string(body)
+			if !utf8.Valid(body) {
+				text = base64.StdEncoding.EncodeToString(body)
+				job.BodyEncoding = "base64"
+			}
+			job.Body = &text
+		}
+		result.Jobs = append(result.Jobs, job)
+	}
+	writeJSON(rw, http.StatusOK, result)
+}
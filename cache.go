@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Views and raw bodies carry validators so browsers revalidate instead of
+// downloading unchanged jobs again. Responses depend on the user, through
+// claims and PII masking, so they may only be cached privately.
+const cacheControl = "private, no-cache"
+
+// contentETag is a strong validator for a body with the given SHA-256.
+func contentETag(sum string) string {
+	if len(sum) > 32 {
+		sum = sum[:32]
+	}
+	return `"` + sum + `"`
+}
+
+func bytesETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return contentETag(hex.EncodeToString(sum[:]))
+}
+
+// etagMatches reports whether an If-None-Match or If-Match header lists
+// etag. The comparison is weak for both, since compression weakens the
+// tags clients were sent.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchField carries If-Match in decision forms, which browsers submit
+// without custom headers.
+const ifMatchField = "if_match"
+
+// jobETag identifies the version of a job a reviewer decides on. It
+// changes with every revision and every change of state.
+func jobETag(m jobMeta, state string) string {
+	return fmt.Sprintf(`"%d-%d-%s"`, m.ID, m.Revision, state)
+}
+
+// checkIfMatch makes a decision conditional on the job being unchanged
+// since the reviewer loaded it. A changed job fails with its current
+// state, so the client can reload instead of racing another reviewer.
+func checkIfMatch(r *http.Request, id int) (int, *apiError) {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		match = r.FormValue(ifMatchField)
+	}
+	if match == "" {
+		return http.StatusPreconditionRequired, &apiError{Code: codePreconditionRequired, Message: tr(r, "if_match_required")}
+	}
+
+	m, ok := metadata.get(id)
+	state := findState(id)
+	if !ok || state == "" {
+		return http.StatusNotFound, &apiError{Code: codeNotFound, Message: tr(r, "not_found")}
+	}
+	etag := jobETag(m, state)
+	if !etagMatches(match, etag) {
+		return http.StatusConflict, &apiError{
+			Code:    codeStaleJob,
+			Message: tr(r, "job_changed", tr(r, state+"_state")),
+			Details: map[string]interface{}{"state": state, "revision": m.Revision, "etag": etag},
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// writeValidated sends a rendered page with an ETag derived from its
+// bytes, or 304 Not Modified if the client already has it. The page is
+// still rendered, but its body isn't sent again.
+func writeValidated(rw http.ResponseWriter, r *http.Request, body []byte) {
+	etag := bytesETag(body)
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Cache-Control", cacheControl)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write(body)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	calendarPath = "/calendar.ics"
+	icsTime      = "20060102T150405Z"
+	icsLineLimit = 75
+)
+
+// deadline is one dated event in the calendar feed: when a job breaches
+// the SLA, or when its queue's auto-reject policy will reject it.
+type deadline struct {
+	ID    int
+	Title string
+	Kind  string
+	At    time.Time
+	URL   string
+}
+
+const (
+	deadlineSLA    = "sla"
+	deadlineExpiry = "expiry"
+)
+
+// deadlines lists the upcoming due dates of the jobs in review the request's
+// user could take, soonest first.
+func deadlines(r *http.Request) []deadline {
+	list := []deadline{}
+	userName := currentUser(r).Name
+	base := r.URL.Scheme + "://" + r.Host
+
+	for _, q := range accessibleQueues(r) {
+		for _, id := range q.ids("review") {
+			m, ok := metadata.get(id)
+			if !ok || m.Deferred || (!isAdmin(r) && !inQueue(m.Team, userName)) {
+				continue
+			}
+			d := deadline{ID: id, Title: m.Title, URL: base + q.viewURL(id)}
+			if cfg.SLA.Duration > 0 {
+				d.Kind, d.At = deadlineSLA, m.SubmittedAt.Add(cfg.SLA.Duration)
+				list = append(list, d)
+			}
+			if p := q.autoReject; p != nil {
+				// Rejected by the first policy run once the job is old enough
+				due := m.SubmittedAt.Add(p.After.Duration)
+				d.Kind, d.At = deadlineExpiry, p.Schedule.next(due.Add(-time.Nanosecond).Local()).UTC()
+				list = append(list, d)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].At.Equal(list[j].At) {
+			return list[i].At.Before(list[j].At)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// icsEscape escapes a TEXT value as RFC 5545 requires.
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsLine writes a content line folded at 75 octets, without splitting
+// UTF-8 sequences.
+func icsLine(b *strings.Builder, line string) {
+	for len(line) > icsLineLimit {
+		cut := icsLineLimit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n")
+		line = " " + line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func formatCalendar(r *http.Request, list []deadline, now time.Time) string {
+	var b strings.Builder
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//jobServer//Deadlines//EN")
+	icsLine(&b, "CALSCALE:GREGORIAN")
+	icsLine(&b, "X-WR-CALNAME:"+icsEscape(cfg.Site.Title))
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, d := range list {
+		name := "Job " + strconv.Itoa(d.ID)
+		if d.Title != "" {
+			name += ": " + d.Title
+		}
+		summary := tr(r, "calendar_sla", name)
+		if d.Kind == deadlineExpiry {
+			summary = tr(r, "calendar_expiry", name)
+		}
+
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, fmt.Sprintf("UID:job-%d-%s@%s", d.ID, d.Kind, host))
+		icsLine(&b, "DTSTAMP:"+now.UTC().Format(icsTime))
+		icsLine(&b, "DTSTART:"+d.At.UTC().Format(icsTime))
+		icsLine(&b, "SUMMARY:"+icsEscape(summary))
+		icsLine(&b, "URL:"+d.URL)
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// calendarHandler serves an iCalendar feed of the SLA deadlines and
+// scheduled expiries of the jobs the user could review, for subscribing
+// from a calendar application.
+func calendarHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	rw.Header().Set("Content-Disposition", `inline; filename="deadlines.ics"`)
+	fmt.Fprint(rw, formatCalendar(r, deadlines(r), time.Now()))
+}
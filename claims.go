@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type claim struct {
+	User     string    `json:"user"`
+	At       time.Time `json:"at"`
+	Expires  time.Time `json:"expires"`
+	Assigned bool      `json:"assigned,omitempty"`
+}
+
+// claimStorage tracks which reviewer currently has a job open. Claims are
+// renewed on every view and lapse after cfg.ClaimTTL without activity.
+// Claims made by an admin assignment don't lapse until the job is decided
+// or reassigned.
+type claimStorage interface {
+	// acquire claims id for userName unless someone else holds an active
+	// claim. It returns the claim in force and whether userName holds it.
+	acquire(id int, userName string, now time.Time) (claim, bool, bool)
+	get(id int, now time.Time) (claim, bool)
+	// assign hands id to userName regardless of who holds it and returns
+	// the previous holder, if any.
+	assign(id int, userName string, now time.Time) string
+	// heldBy lists the jobs userName holds an active claim on.
+	heldBy(userName string, now time.Time) []int
+	assignedTo(userName string) []int
+	active(now time.Time) map[int]claim
+	release(id int) (claim, bool)
+}
+
+// claimStore keeps claims in memory for a single server.
+type claimStore struct {
+	sync.Mutex
+	byID map[int]claim
+}
+
+var claims claimStorage = &claimStore{byID: map[int]claim{}}
+
+func (c claim) active(now time.Time) bool {
+	return c.Assigned || now.Before(c.Expires)
+}
+
+func (s *claimStore) acquire(id int, userName string, now time.Time) (claim, bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.byID[id]
+	if ok && c.active(now) && c.User != userName {
+		return c, false, false
+	}
+
+	isNew := !ok || !c.active(now) || c.User != userName
+	if isNew {
+		c = claim{User: userName, At: now}
+	}
+	c.Expires = now.Add(cfg.ClaimTTL.Duration)
+	s.byID[id] = c
+	return c, true, isNew
+}
+
+func (s *claimStore) get(id int, now time.Time) (claim, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.byID[id]
+	if !ok || !c.active(now) {
+		return claim{}, false
+	}
+	return c, true
+}
+
+func (s *claimStore) assign(id int, userName string, now time.Time) string {
+	s.Lock()
+	defer s.Unlock()
+
+	previous := ""
+	if c, ok := s.byID[id]; ok && c.active(now) {
+		previous = c.User
+	}
+	s.byID[id] = claim{User: userName, At: now, Expires: now.Add(cfg.ClaimTTL.Duration), Assigned: true}
+	return previous
+}
+
+func (s *claimStore) heldBy(userName string, now time.Time) []int {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := []int{}
+	for id, c := range s.byID {
+		if c.User == userName && c.active(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *claimStore) assignedTo(userName string) []int {
+	s.Lock()
+	defer s.Unlock()
+
+	ids := []int{}
+	for id, c := range s.byID {
+		if c.User == userName && c.Assigned {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *claimStore) active(now time.Time) map[int]claim {
+	s.Lock()
+	defer s.Unlock()
+
+	held := map[int]claim{}
+	for id, c := range s.byID {
+		if c.active(now) {
+			held[id] = c
+		}
+	}
+	return held
+}
+
+func (s *claimStore) release(id int) (claim, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.byID[id]
+	delete(s.byID, id)
+	return c, ok
+}
+
+func heldByOther(id int, userName string, now time.Time) bool {
+	c, ok := claims.get(id, now)
+	return ok && c.User != userName
+}
+
+// claimForView claims a job shown to a reviewer and counts new review
+// sessions in the job metadata.
+func claimForView(id int, userName string) (claim, bool) {
+	c, held, isNew := claims.acquire(id, userName, time.Now().UTC())
+	if held && isNew {
+		_, err := metadata.update(id, func(m *jobMeta) error {
+			m.Sessions++
+			return nil
+		})
+		if err != nil {
+			warnf("Session count failed: ID: %d [%v]\n", id, err)
+		}
+		publishEvent(eventClaimed, id, jobEvent{User: userName, At: c.At})
+	}
+	return c, held
+}
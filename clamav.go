@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	quarantineDir       = "quarantine"
+	defaultClamdTimeout = 30 * time.Second
+	clamdChunk          = 64 << 10
+)
+
+// Scan results, as recorded in a job's metadata.
+const (
+	scanClean    = "clean"
+	scanInfected = "infected"
+	scanFailed   = "failed"
+)
+
+// clamavConfig passes new submissions, body and attachments, through
+// clamd before they enter review. Addr is host:port or the path of clamd's
+// unix socket. Infected submissions are turned away and kept under
+// data/quarantine. Attachments added later and replaced bodies are scanned
+// too, and refused when infected. When clamd can't be reached submissions
+// are refused, unless FailOpen lets them in with a failed verdict.
+type clamavConfig struct {
+	Addr     string   `json:"addr"`
+	Timeout  duration `json:"timeout"`
+	FailOpen bool     `json:"fail_open"`
+}
+
+type scanVerdict struct {
+	Result    string    `json:"result"`
+	Signature string    `json:"signature,omitempty"`
+	File      string    `json:"file,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// quarantineRecord is kept beside the files of a quarantined submission.
+type quarantineRecord struct {
+	Submitter string      `json:"submitter,omitempty"`
+	Queue     string      `json:"queue,omitempty"`
+	Source    string      `json:"source,omitempty"`
+	Scan      scanVerdict `json:"scan"`
+	Files     []string    `json:"files"`
+}
+
+func (c clamavConfig) enabled() bool {
+	return c.Addr != ""
+}
+
+func (c *clamavConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if !strings.HasPrefix(c.Addr, "/") {
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			return fmt.Errorf("invalid clamav.addr: %v", err)
+		}
+	}
+	if c.Timeout.Duration < 0 {
+		return errors.New("clamav.timeout must not be negative")
+	}
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultClamdTimeout
+	}
+	return nil
+}
+
+// clamdScan streams src to clamd with INSTREAM and returns the signature
+// found, or "" if src is clean.
+func clamdScan(src io.Reader) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(cfg.ClamAV.Addr, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, cfg.ClamAV.Addr, cfg.ClamAV.Timeout.Duration)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cfg.ClamAV.Timeout.Duration))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, err := io.ReadFull(src, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// scanSubmission scans the body and attachments of a submission, stopping
+// at the first infected file. It returns nil when scanning is off, and an
+// error only when clamd failed and fail_open is not set.
+func scanSubmission(body *spooledBody, form *multipart.Form) (*scanVerdict, error) {
+	return scanParts(func() (io.ReadCloser, error) {
+		return os.Open(body.name)
+	}, form)
+}
+
+// scanChange scans what user's change to job id brings in before it is
+// stored: a new body, if body is not nil, and the attachments of form.
+// Nothing is quarantined, as nothing was stored; an infected change is
+// refused with a *submitRefusal.
+func scanChange(id int, user string, body []byte, form *multipart.Form) error {
+	var open func() (io.ReadCloser, error)
+	if body != nil {
+		open = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	scan, err := scanParts(open, form)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errScanUnavailable, err)
+	}
+	if scan == nil || scan.Result != scanInfected {
+		return nil
+	}
+
+	note := scan.Signature
+	details := map[string]string{"signature": scan.Signature}
+	if scan.File != "" {
+		note += " in " + scan.File
+		details["file"] = scan.File
+	}
+	if err := audit(auditEntry{Actor: user, Action: "infected", ID: id, Note: note}); err != nil {
+		warnf("Infected audit failed: %v\n", err)
+	}
+	warnf("Change refused: ID: %d [%s]\n", id, note)
+	return &submitRefusal{
+		status: http.StatusUnprocessableEntity,
+		apiError: apiError{
+			Code:    codeInfected,
+			Message: "rejected by virus scan: " + note,
+			Details: details,
+		},
+	}
+}
+
+// scanParts scans the body that open opens, if there is one, and the
+// attachments of form, as scanSubmission does.
+func scanParts(open func() (io.ReadCloser, error), form *multipart.Form) (*scanVerdict, error) {
+	if !cfg.ClamAV.enabled() {
+		return nil, nil
+	}
+	verdict := &scanVerdict{Result: scanClean, At: time.Now().UTC()}
+	err := func() error {
+		if open != nil {
+			file, err := open()
+			if err != nil {
+				return err
+			}
+			signature, err := clamdScan(file)
+			file.Close()
+			if err != nil || signature != "" {
+				verdict.Signature = signature
+				return err
+			}
+		}
+		if form == nil {
+			return nil
+		}
+		for _, header := range form.File["attachment"] {
+			file, err := header.Open()
+			if err != nil {
+				return err
+			}
+			signature, err := clamdScan(file)
+			file.Close()
+			if err != nil || signature != "" {
+				verdict.Signature = signature
+				verdict.File = path.Base(header.Filename)
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		if !cfg.ClamAV.FailOpen {
+			return nil, err
+		}
+		warnf("Virus scan failed, letting the submission in: [%v]\n", err)
+		verdict.Result = scanFailed
+		verdict.File = ""
+		return verdict, nil
+	}
+	if verdict.Signature != "" {
+		verdict.Result = scanInfected
+	}
+	return verdict, nil
+}
+
+// quarantine keeps the files of an infected submission out of the queues,
+// under data/quarantine/<name>, and returns that name.
+func quarantine(body *spooledBody, form *multipart.Form, rec quarantineRecord) (string, error) {
+	name := strconv.FormatInt(time.Now().UnixNano(), 10)
+	dir := path.Join(contentPath, quarantineDir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	// Quarantined bodies are sealed like stored ones
+	if bodyKey == nil {
+		if err := os.Rename(body.name, path.Join(dir, "body")); err != nil {
+			return "", err
+		}
+	} else {
+		data, err := body.bytes()
+		if err == nil {
+			data, err = encodeBody(data)
+		}
+		if err == nil {
+			err = os.WriteFile(path.Join(dir, "body"), data, 0600)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	rec.Files = []string{"body"}
+
+	if form != nil {
+		for _, header := range form.File["attachment"] {
+			file := "attachment-" + path.Base(header.Filename)
+			if err := copyAttachment(header, path.Join(dir, file)); err != nil {
+				return "", err
+			}
+			rec.Files = append(rec.Files, file)
+		}
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return name, os.WriteFile(path.Join(dir, "scan"+metaSuffix), data, 0600)
+}
+
+// eraseQuarantined removes the quarantined submissions of submitter and
+// returns how many there were.
+func eraseQuarantined(submitter string) (int, error) {
+	root := path.Join(contentPath, quarantineDir)
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		data, err := os.ReadFile(path.Join(root, entry.Name(), "scan"+metaSuffix))
+		if err != nil {
+			continue
+		}
+		var rec quarantineRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.Submitter != submitter {
+			continue
+		}
+		if err := os.RemoveAll(path.Join(root, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func copyAttachment(header *multipart.FileHeader, name string) error {
+	src, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// refuseInfected quarantines a submission clamd flagged and returns the
+// refusal to turn it away with.
+func refuseInfected(body *spooledBody, form *multipart.Form, q *queue, identity jobMeta, scan scanVerdict) error {
+	name, err := quarantine(body, form, quarantineRecord{
+		Submitter: identity.Submitter,
+		Queue:     q.name,
+		Source:    identity.Source,
+		Scan:      scan,
+	})
+	if err != nil {
+		return fmt.Errorf("quarantine: %v", err)
+	}
+	note := scan.Signature
+	if scan.File != "" {
+		note += " in " + scan.File
+	}
+	if err := audit(auditEntry{Actor: identity.Submitter, Action: "quarantine", Note: name + ": " + note}); err != nil {
+		warnf("Quarantine audit failed: %v\n", err)
+	}
+	warnf("Submission quarantined: %s [%s]\n", name, note)
+
+	details := map[string]string{"signature": scan.Signature, "quarantine": name}
+	if scan.File != "" {
+		details["file"] = scan.File
+	}
+	return &submitRefusal{
+		status: http.StatusUnprocessableEntity,
+		apiError: apiError{
+			Code:    codeInfected,
+			Message: "rejected by virus scan: " + note,
+			Details: details,
+		},
+	}
+}
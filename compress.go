@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// compressionConfig gzips responses of the listed media types once they
+// reach min_size bytes. A type ending in /* matches the whole family, and
+// an empty list turns compression off. Brotli would need a third-party
+// encoder, so gzip is the only encoding offered.
+type compressionConfig struct {
+	MinSize int      `json:"min_size"`
+	Level   int      `json:"level"`
+	Types   []string `json:"types"`
+}
+
+func defaultCompression() compressionConfig {
+	return compressionConfig{
+		MinSize: 1024,
+		Level:   6,
+		Types:   []string{"text/html", "application/json"},
+	}
+}
+
+func (c compressionConfig) validate() error {
+	if c.MinSize < 0 {
+		return fmt.Errorf("compression.min_size must not be negative")
+	}
+	if c.Level < gzip.BestSpeed || c.Level > gzip.BestCompression {
+		return fmt.Errorf("compression.level must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+	}
+	return nil
+}
+
+func (c compressionConfig) compresses(contentType string) bool {
+	mediaType := strings.ToLower(baseMediaType(contentType))
+	for _, t := range c.Types {
+		t = strings.ToLower(t)
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(coding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		return len(parts) < 2 || strings.ReplaceAll(strings.TrimSpace(parts[1]), " ", "") != "q=0"
+	}
+	return false
+}
+
+func compressHandler(next http.Handler) http.Handler {
+	c := cfg.Compression
+	if len(c.Types) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: rw, config: c}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipWriter holds back the start of a response until it knows whether
+// the body is big enough to compress.
+type gzipWriter struct {
+	http.ResponseWriter
+	config  compressionConfig
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+// bodiless reports statuses whose responses have no body to compress or
+// carry only part of one.
+func bodiless(status int) bool {
+	return (status != 0 && status < http.StatusOK) || status == http.StatusNoContent ||
+		status == http.StatusPartialContent || status == http.StatusNotModified
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	if bodiless(status) {
+		w.decide()
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.config.MinSize {
+			if err := w.decide(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide sends the header, compressing if the response qualifies, and
+// writes out what was held back.
+func (w *gzipWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if !bodiless(w.status) &&
+		len(w.buf) >= w.config.MinSize &&
+		h.Get("Content-Encoding") == "" &&
+		w.config.compresses(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		// The compressed bytes differ, so a strong validator must not be
+		// reused for them
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, w.config.Level)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *gzipWriter) close() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type config struct {
+	Addr           string                      `json:"addr"`
+	PathPrefix     string                      `json:"path_prefix"`
+	TrustedProxies []string                    `json:"trusted_proxies"`
+	TemplateDir    string                      `json:"template_dir"`
+	StaticDir      string                      `json:"static_dir"`
+	Dev            bool                        `json:"dev"`
+	Site           site                        `json:"site"`
+	ContentFormat  string                      `json:"content_format"`
+	Users          []user                      `json:"users"`
+	SLA            duration                    `json:"sla"`
+	ClaimTTL       duration                    `json:"claim_ttl"`
+	SMTP           smtpConfig                  `json:"smtp"`
+	Reports        []reportConfig              `json:"reports"`
+	Escalation     delivery                    `json:"escalation"`
+	Queues         []queueConfig               `json:"queues"`
+	Rules          []rule                      `json:"rules"`
+	Scoring        scoringConfig               `json:"scoring"`
+	Duplicates     string                      `json:"duplicates"`
+	PII            piiConfig                   `json:"pii"`
+	Encryption     encryptionConfig            `json:"encryption"`
+	LogSink        logSinkConfig               `json:"log_sink"`
+	LogFile        string                      `json:"log_file"`
+	LogLevel       string                      `json:"log_level"`
+	LogRotation    rotationConfig              `json:"log_rotation"`
+	Redis          redisConfig                 `json:"redis"`
+	Events         eventsConfig                `json:"events"`
+	Compression    compressionConfig           `json:"compression"`
+	APIVersions    map[string]apiVersionConfig `json:"api_versions"`
+	Selection      selectionConfig             `json:"selection"`
+	Quota          quotaConfig                 `json:"quota"`
+	AutoReject     *autoRejectPolicy           `json:"auto_reject"`
+	Reminders      *reminderPolicy             `json:"reminders"`
+	Jira           jiraConfig                  `json:"jira"`
+	GitHub         githubConfig                `json:"github"`
+	Flags          map[string]featureFlag      `json:"flags"`
+	Hooks          hooksConfig                 `json:"hooks"`
+	ClamAV         clamavConfig                `json:"clamav"`
+	Titles         titlesConfig                `json:"titles"`
+	Inbox          inboxConfig                 `json:"inbox"`
+
+	// Tenants is recognised only so it can be rejected; README.md says
+	// why under "Declined features".
+	Tenants json.RawMessage `json:"tenants,omitempty"`
+	// Database is likewise rejected
+	Database json.RawMessage `json:"database,omitempty"`
+	// Plugins is likewise rejected
+	Plugins json.RawMessage `json:"plugins,omitempty"`
+
+	ReadTimeout        duration `json:"read_timeout"`
+	ReadHeaderTimeout  duration `json:"read_header_timeout"`
+	WriteTimeout       duration `json:"write_timeout"`
+	IdleTimeout        duration `json:"idle_timeout"`
+	MaxHeaderBytes     int      `json:"max_header_bytes"`
+	MaxBodyBytes       int64    `json:"max_body_bytes"`
+	InlineBodyBytes    int64    `json:"inline_body_bytes"`
+	MaxJobBytes        int64    `json:"max_job_bytes"`
+	MaxAttachmentBytes int64    `json:"max_attachment_bytes"`
+	UpdateQueue        int      `json:"update_queue"`
+	SnapshotInterval   duration `json:"snapshot_interval"`
+	Shards             int      `json:"shards"`
+	IdempotencyWindow  duration `json:"idempotency_window"`
+}
+
+// duration accepts either a Go duration string ("30s") or seconds in JSON.
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	default:
+		return fmt.Errorf("invalid duration: %s", b)
+	}
+
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+type site struct {
+	Title  string `json:"title"`
+	Logo   string `json:"logo"`
+	Colors colors `json:"colors"`
+}
+
+type colors struct {
+	Primary    string `json:"primary"`
+	Background string `json:"background"`
+	Text       string `json:"text"`
+}
+
+var validColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+var cfg = defaultConfig()
+
+func defaultConfig() *config {
+	return &config{
+		Addr:          ":8080",
+		ContentFormat: formatAuto,
+		SLA:           duration{72 * time.Hour},
+		ClaimTTL:      duration{30 * time.Minute},
+		Scoring:       scoringConfig{Timeout: duration{5 * time.Second}},
+		Duplicates:    duplicatesFlag,
+		Compression:   defaultCompression(),
+		Quota:         quotaConfig{Action: quotaReject},
+		Selection: selectionConfig{
+			Strategy: selectRandom,
+			MaxWait:  duration{24 * time.Hour},
+			Weights:  selectionWeights{Base: 1, Priority: 10, Age: 0.5},
+		},
+		Site: site{
+			Title: "Job Server",
+			Colors: colors{
+				Primary:    "#2c5282",
+				Background: "#ffffff",
+				Text:       "#1a202c",
+			},
+		},
+		Titles: titlesConfig{
+			MaxLength:     defaultTitleLength,
+			SummaryLength: defaultSummaryLength,
+		},
+
+		ReadTimeout:       duration{30 * time.Second},
+		ReadHeaderTimeout: duration{10 * time.Second},
+		// No write timeout: it bounds the whole response, and backups,
+		// exports and reports stream for longer
+		IdleTimeout:       duration{120 * time.Second},
+		MaxHeaderBytes:    1 << 20,
+		MaxBodyBytes:      10 << 20,
+		InlineBodyBytes:   1 << 20,
+		UpdateQueue:       100,
+		SnapshotInterval:  duration{10 * time.Minute},
+		Shards:            1,
+		IdempotencyWindow: duration{24 * time.Hour},
+	}
+}
+
+func loadConfig(file string) (*config, error) {
+	c := defaultConfig()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", file, err)
+	}
+
+	return c, c.validate()
+}
+
+func (c *config) validate() error {
+	if c.PathPrefix != "" {
+		c.PathPrefix = "/" + strings.Trim(c.PathPrefix, "/")
+	}
+
+	switch c.ContentFormat {
+	case formatAuto, formatMarkdown, formatText:
+	default:
+		return fmt.Errorf("invalid content_format: %q", c.ContentFormat)
+	}
+
+	if len(c.Tenants) > 0 {
+		return errors.New(`tenants are not supported: run one instance per tenant, see "Declined features" in README.md`)
+	}
+	if len(c.Database) > 0 {
+		return errors.New(`database is not supported: share the data directory and configure redis, see "Declined features" in README.md`)
+	}
+	if len(c.Plugins) > 0 {
+		return errors.New(`plugins are not supported: use hooks, see "Declined features" in README.md`)
+	}
+
+	switch c.Duplicates {
+	case duplicatesOff, duplicatesFlag, duplicatesReject:
+	default:
+		return fmt.Errorf("invalid duplicates mode: %q", c.Duplicates)
+	}
+
+	if c.MaxHeaderBytes < 0 || c.MaxBodyBytes < 0 || c.InlineBodyBytes < 0 || c.MaxJobBytes < 0 || c.MaxAttachmentBytes < 0 {
+		return errors.New("size limits must not be negative")
+	}
+	if c.UpdateQueue < 1 {
+		return errors.New("update_queue must be at least 1")
+	}
+	if c.Shards < 1 || c.Shards > maxShards {
+		return fmt.Errorf("shards must be between 1 and %d", maxShards)
+	}
+	if err := validateAPIVersions(c.APIVersions); err != nil {
+		return err
+	}
+	if err := validateFlags(c.Flags); err != nil {
+		return err
+	}
+	if err := c.Hooks.validate(); err != nil {
+		return err
+	}
+	if err := c.ClamAV.validate(); err != nil {
+		return err
+	}
+	if err := c.Titles.validate(); err != nil {
+		return err
+	}
+	if err := c.Quota.validate(); err != nil {
+		return err
+	}
+	if err := c.Selection.validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.validate(); err != nil {
+		return err
+	}
+	if c.IdempotencyWindow.Duration < 0 {
+		return errors.New("idempotency_window must not be negative")
+	}
+	if c.SnapshotInterval.Duration < 0 {
+		return errors.New("snapshot_interval must not be negative")
+	}
+
+	for _, color := range []string{c.Site.Colors.Primary, c.Site.Colors.Background, c.Site.Colors.Text} {
+		if !validColor.MatchString(color) {
+			return fmt.Errorf("invalid site color: %q", color)
+		}
+	}
+
+	if err := validateUsers(c.Users); err != nil {
+		return err
+	}
+	if c.AutoReject != nil {
+		if err := c.AutoReject.compile(""); err != nil {
+			return err
+		}
+	}
+	if c.Reminders != nil {
+		if err := c.Reminders.validate(""); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{}
+	for _, qc := range c.Queues {
+		if err := qc.validate(); err != nil {
+			return err
+		}
+		if seen[qc.Name] {
+			return fmt.Errorf("duplicate queue: %s", qc.Name)
+		}
+		seen[qc.Name] = true
+	}
+	if err := c.Inbox.validate(seen); err != nil {
+		return err
+	}
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	for _, rc := range c.Reports {
+		if err := rc.validate(); err != nil {
+			return err
+		}
+	}
+
+	detectors, err := compilePII(c.PII)
+	if err != nil {
+		return err
+	}
+	piiDetectors = detectors
+
+	if c.LogLevel != "" {
+		if _, err := parseLogLevel(c.LogLevel); err != nil {
+			return err
+		}
+	}
+	if err := c.LogRotation.validate(); err != nil {
+		return err
+	}
+	if err := c.Jira.validate(); err != nil {
+		return err
+	}
+	if err := c.GitHub.validate(); err != nil {
+		return err
+	}
+	if err := c.Events.validate(); err != nil {
+		return err
+	}
+	if err := c.Redis.validate(); err != nil {
+		return err
+	}
+	if err := c.LogSink.validate(); err != nil {
+		return err
+	}
+
+	key, err := loadBodyKey(c.Encryption)
+	if err != nil {
+		return err
+	}
+	bodyKey = key
+
+	proxies, err := parseProxies(c.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = proxies
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatImage  = "image"
+	formatPDF    = "pdf"
+	formatBinary = "binary"
+)
+
+var inlineImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+const markdownContentType = "text/markdown; charset=utf-8"
+
+// sniffContentType looks at the first bytes of a job body. Plain text is
+// further told apart from Markdown.
+func sniffContentType(body []byte) string {
+	contentType := http.DetectContentType(body)
+	if baseMediaType(contentType) == "text/plain" && isMarkdown(body) {
+		return markdownContentType
+	}
+	return contentType
+}
+
+func baseMediaType(contentType string) string {
+	return strings.TrimSpace(strings.Split(contentType, ";")[0])
+}
+
+// classifyContent picks the rendering path for a job body.
+func classifyContent(contentType string) string {
+	mediaType := baseMediaType(contentType)
+
+	switch {
+	case inlineImageTypes[mediaType]:
+		return formatImage
+	case mediaType == "application/pdf":
+		return formatPDF
+	case mediaType == "text/markdown":
+		if cfg.ContentFormat == formatText {
+			return formatText
+		}
+		return formatMarkdown
+	case strings.HasPrefix(mediaType, "text/"):
+		if cfg.ContentFormat == formatMarkdown {
+			return formatMarkdown
+		}
+		return formatText
+	}
+	return formatBinary
+}
+
+func preparePage(id int, p *Page, r *http.Request) {
+	q := queueOf(id)
+	if m, ok := metadata.get(id); ok {
+		p.Fields = jobFields(q.fields, m.Fields)
+		p.Attachments = m.Attachments
+		p.Tags = m.Tags
+		if earlier, ok := metadata.get(m.DuplicateOf); ok && m.DuplicateOf != 0 {
+			p.Duplicate = &earlier
+		}
+		if m.Score != nil {
+			p.Score = fmt.Sprintf("%.2f", *m.Score)
+		}
+		p.Labels = m.Labels
+		p.Submitter = m.Submitter
+		p.Source = m.Source
+		p.Revision = m.Revision
+		p.ETag = jobETag(m, findState(id))
+		if m.Title != "" {
+			p.Title = m.Title
+		}
+		p.Rereview = m.Rereview
+		switch findState(id) {
+		case "escalate":
+			p.Escalation = m.Escalation
+		case "appeal":
+			p.Appeal = m.Appeal
+		}
+	}
+
+	if q.name != "" {
+		p.Queue = q.name
+		if q.title != "" {
+			p.Queue = q.title
+		}
+	}
+
+	if d, ok := drafts.get(currentUser(r).Name, id); ok {
+		p.Draft = &d
+	}
+	if findState(id) == "review" {
+		c, held := claimForView(id, currentUser(r).Name)
+		p.Claim = &c
+		p.ClaimHeld = held
+	}
+
+	p.ContentType = jobContentType(id, p.Body)
+	p.Format = classifyContent(p.ContentType)
+	if p.Format == formatText || p.Format == formatMarkdown {
+		p.FrontMatter, p.Head, p.Body = splitFrontMatter(p.Body)
+	}
+	if fm := p.FrontMatter; fm != nil {
+		if p.Title == defaultPageTitle && fm.Title != "" {
+			p.Title = fm.Title
+		}
+		if p.Submitter == "" {
+			p.Submitter = fm.Submitter
+		}
+		p.Tags = mergeTags(p.Tags, fm.Tags)
+	}
+	p.Raw = r.URL.Query().Get("raw") == "1"
+	p.Body, p.Redacted = displayBody(r, id, p.Body)
+	p.CanUnmask = canUnmask(r)
+
+	switch p.Format {
+	case formatMarkdown:
+		if !p.Raw {
+			p.HTML = renderMarkdown(p.Body)
+		}
+	}
+}
+
+var preferredExtensions = map[string]string{
+	"text/plain": contentSuffix,
+	"text/html":  ".html",
+	"image/jpeg": ".jpg",
+}
+
+func downloadName(name string, contentType string) string {
+	if ext, ok := preferredExtensions[baseMediaType(contentType)]; ok {
+		return name + ext
+	}
+	exts, err := mime.ExtensionsByType(baseMediaType(contentType))
+	if err == nil && len(exts) > 0 {
+		sort.Strings(exts)
+		name += exts[0]
+	}
+	return name
+}
+
+func rawHandler(rw http.ResponseWriter, r *http.Request) {
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Raw failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Raw failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+
+	state := findState(id)
+	if state == "" {
+		warnf("Raw failed: ID: %d [entry not present]\n", id)
+		notFound(rw, r)
+		return
+	}
+	if !canViewJob(rw, r, id, state) {
+		return
+	}
+
+	file, err := openBody(jobFile(id, state))
+	if err != nil {
+		warnf("Raw failed: ID: %d [%v]\n", id, err)
+		notFound(rw, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := ""
+	if m, ok := metadata.get(id); ok {
+		contentType = m.ContentType
+	}
+	if contentType == "" {
+		head := make([]byte, sniffLen)
+		n, _ := io.ReadFull(file, head)
+		contentType = sniffContentType(head[:n])
+	}
+	format := classifyContent(contentType)
+
+	var content io.ReadSeeker = file
+	etag := ""
+	if m, ok := metadata.get(id); ok && m.SHA256 != "" {
+		etag = contentETag(m.SHA256)
+	}
+	if cfg.PII.Enabled && (format == formatText || format == formatMarkdown) {
+		file.Seek(0, io.SeekStart)
+		body, err := io.ReadAll(file)
+		if err != nil {
+			httpError(rw, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, _ = displayBody(r, id, body)
+		content = bytes.NewReader(body)
+		// Masked and unmasked bodies must not share a validator
+		etag = bytesETag(body)
+	}
+	if etag != "" {
+		rw.Header().Set("ETag", etag)
+	}
+	rw.Header().Set("Cache-Control", cacheControl)
+
+	setRawHeaders(rw, r, contentType, strconv.Itoa(id))
+	http.ServeContent(rw, r, "", info.ModTime(), content)
+}
+
+// setRawHeaders sets the headers for serving an uploaded body of
+// contentType as it was submitted, downloaded as name plus an extension.
+func setRawHeaders(rw http.ResponseWriter, r *http.Request, contentType string, name string) {
+	format := classifyContent(contentType)
+
+	// Never let uploaded markup execute in our origin
+	servedType := contentType
+	if strings.HasPrefix(baseMediaType(contentType), "text/") {
+		servedType = "text/plain; charset=utf-8"
+	}
+
+	disposition := "attachment"
+	if format != formatBinary && r.URL.Query().Get("download") != "1" {
+		disposition = "inline"
+	}
+
+	rw.Header().Set("Content-Type", servedType)
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": downloadName(name, contentType),
+	}))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	if format != formatPDF {
+		// Browser PDF viewers refuse to run in a sandboxed document
+		rw.Header().Set("Content-Security-Policy", "sandbox")
+	}
+}
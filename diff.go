@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext  = 3
+	maxDiffCells = 25000000
+)
+
+type diffLine struct {
+	Op   byte // ' ', '-' or '+'
+	Text string
+}
+
+type diffHunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []diffLine
+}
+
+func (h diffHunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\n")
+	}
+	return lines
+}
+
+// diffLines computes a line based edit script from the longest common
+// subsequence, found with Hirschberg's divide and conquer so that only two
+// rows of the LCS table are kept at a time. Inputs whose table would be
+// very large degrade to a full replacement, to bound the time taken.
+func diffLines(a, b []string) []diffLine {
+	script := make([]diffLine, 0, len(a)+len(b))
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			script = append(script, diffLine{'-', line})
+		}
+		for _, line := range b {
+			script = append(script, diffLine{'+', line})
+		}
+		return script
+	}
+	return appendDiff(script, a, b)
+}
+
+// appendDiff appends the edit script from a to b to script.
+func appendDiff(script []diffLine, a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		script = append(script, diffLine{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0 || len(b) == 0:
+		for _, line := range a {
+			script = append(script, diffLine{'-', line})
+		}
+		for _, line := range b {
+			script = append(script, diffLine{'+', line})
+		}
+
+	case len(a) == 1:
+		at := -1
+		for j, line := range b {
+			if line == a[0] {
+				at = j
+				break
+			}
+		}
+		if at < 0 {
+			script = append(script, diffLine{'-', a[0]})
+		}
+		for j, line := range b {
+			if j == at {
+				script = append(script, diffLine{' ', line})
+			} else {
+				script = append(script, diffLine{'+', line})
+			}
+		}
+
+	default:
+		// Split b where the halves of a have the longest common
+		// subsequences with it between them
+		mid := len(a) / 2
+		head := lcsLengths(a[:mid], b)
+		tail := lcsLengthsFromEnd(a[mid:], b)
+		split := 0
+		for j := range head {
+			if head[j]+tail[j] > head[split]+tail[split] {
+				split = j
+			}
+		}
+		script = appendDiff(script, a[:mid], b[:split])
+		script = appendDiff(script, a[mid:], b[split:])
+	}
+
+	for _, line := range common {
+		script = append(script, diffLine{' ', line})
+	}
+	return script
+}
+
+// lcsLengths returns, for each j, the length of the longest common
+// subsequence of a and b[:j].
+func lcsLengths(a, b []string) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i] == b[j-1]:
+				cur[j] = prev[j-1] + 1
+			case prev[j] >= cur[j-1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j-1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// lcsLengthsFromEnd returns, for each j, the length of the longest common
+// subsequence of a and b[j:].
+func lcsLengthsFromEnd(a, b []string) []int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				cur[j] = prev[j+1] + 1
+			case prev[j] >= cur[j+1]:
+				cur[j] = prev[j]
+			default:
+				cur[j] = cur[j+1]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+// unifiedHunks groups an edit script into hunks with diffContext lines of
+// surrounding context, like diff -u.
+func unifiedHunks(a, b string) []diffHunk {
+	script := diffLines(splitLines(a), splitLines(b))
+
+	// Line numbers in the old and new text before script[k]
+	oldAt := make([]int, len(script)+1)
+	newAt := make([]int, len(script)+1)
+	for k, line := range script {
+		oldAt[k+1], newAt[k+1] = oldAt[k], newAt[k]
+		if line.Op != '+' {
+			oldAt[k+1]++
+		}
+		if line.Op != '-' {
+			newAt[k+1]++
+		}
+	}
+
+	hunks := []diffHunk{}
+	for k := 0; k < len(script); k++ {
+		if script[k].Op == ' ' {
+			continue
+		}
+
+		end := k
+		for next := k + 1; next < len(script) && next-end <= 2*diffContext; next++ {
+			if script[next].Op != ' ' {
+				end = next
+			}
+		}
+
+		from, to := k-diffContext, end+diffContext+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(script) {
+			to = len(script)
+		}
+
+		h := diffHunk{
+			OldStart: oldAt[from] + 1,
+			OldLines: oldAt[to] - oldAt[from],
+			NewStart: newAt[from] + 1,
+			NewLines: newAt[to] - newAt[from],
+			Lines:    script[from:to],
+		}
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+		k = end
+	}
+
+	return hunks
+}
+
+func formatUnified(oldName, newName string, hunks []diffHunk) string {
+	var out strings.Builder
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		out.WriteString(h.Header() + "\n")
+		for _, line := range h.Lines {
+			out.WriteByte(line.Op)
+			out.WriteString(line.Text + "\n")
+		}
+	}
+	return out.String()
+}
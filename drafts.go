@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	draftPath      = "/draft/"
+	draftsPath     = "/drafts"
+	draftsDir      = "drafts"
+	draftsTemplate = "drafts.html"
+)
+
+type draft struct {
+	ID       int       `json:"id"`
+	Decision string    `json:"decision"`
+	Reason   string    `json:"reason,omitempty"`
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+// draftStore keeps provisional decisions per reviewer. Each reviewer's
+// drafts live in their own file so they are never shown to anyone else.
+type draftStore struct {
+	sync.Mutex
+	byUser map[string]map[int]draft
+}
+
+var drafts = draftStore{byUser: map[string]map[int]draft{}}
+
+// draftsEnabled tells whether reviewers can keep drafts. Without users
+// everyone is the same anonymous admin, so drafts would not be private.
+func draftsEnabled() bool {
+	return authEnabled()
+}
+
+type draftsPage struct {
+	User   string
+	Drafts []draft
+}
+
+func draftFile(userName string) string {
+	return path.Join(contentPath, draftsDir, hex.EncodeToString([]byte(userName))+metaSuffix)
+}
+
+func (s *draftStore) load() error {
+	dir := path.Join(contentPath, draftsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, entry := range entries {
+		name, err := hex.DecodeString(strings.TrimSuffix(entry.Name(), metaSuffix))
+		if err != nil || !strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			warnf("Draft read failed: %s [%v]\n", entry.Name(), err)
+			continue
+		}
+		list := []draft{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			warnf("Draft parse failed: %s [%v]\n", entry.Name(), err)
+			continue
+		}
+		userDrafts := map[int]draft{}
+		for _, d := range list {
+			userDrafts[d.ID] = d
+		}
+		s.byUser[string(name)] = userDrafts
+	}
+
+	return nil
+}
+
+// persist must be called with the lock held.
+func (s *draftStore) persist(userName string) error {
+	data, err := json.MarshalIndent(sortedDrafts(s.byUser[userName]), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(draftFile(userName), data)
+}
+
+func sortedDrafts(userDrafts map[int]draft) []draft {
+	list := []draft{}
+	for _, d := range userDrafts {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func (s *draftStore) get(userName string, id int) (draft, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	d, ok := s.byUser[userName][id]
+	return d, ok
+}
+
+func (s *draftStore) list(userName string) []draft {
+	s.Lock()
+	defer s.Unlock()
+
+	return sortedDrafts(s.byUser[userName])
+}
+
+func (s *draftStore) save(userName string, d draft) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.byUser[userName] == nil {
+		s.byUser[userName] = map[int]draft{}
+	}
+	s.byUser[userName][d.ID] = d
+	return s.persist(userName)
+}
+
+func (s *draftStore) remove(userName string, id int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.byUser[userName][id]; !ok {
+		return nil
+	}
+	delete(s.byUser[userName], id)
+	return s.persist(userName)
+}
+
+// removeJob drops every reviewer's draft for a job.
+func (s *draftStore) removeJob(id int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for userName, userDrafts := range s.byUser {
+		if _, ok := userDrafts[id]; !ok {
+			continue
+		}
+		delete(userDrafts, id)
+		if err := s.persist(userName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func draftHandler(rw http.ResponseWriter, r *http.Request) {
+	if !draftsEnabled() {
+		notFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Draft failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil || findState(id) != "review" {
+		warnf("Draft failed: ID: %s [entry not present]\n", title)
+		notFound(rw, r)
+		return
+	}
+
+	userName := currentUser(r).Name
+	viewURL := urlFor(viewPath, title)
+
+	switch r.FormValue("action") {
+	case "save":
+		decisionState := r.FormValue("decision")
+		if decisionState != "accept" && decisionState != "reject" {
+			httpError(rw, r, "invalid decision", http.StatusBadRequest)
+			return
+		}
+		err = drafts.save(userName, draft{
+			ID:       id,
+			Decision: decisionState,
+			Reason:   decisionReason(r),
+			SavedAt:  time.Now().UTC(),
+		})
+
+	case "commit":
+		d, ok := drafts.get(userName, id)
+		if !ok {
+			notFound(rw, r)
+			return
+		}
+		report, status, failure := checkDecision(r, id, d.Decision, d.Reason)
+		if failure != nil {
+			writeAPIError(rw, r, status, *failure)
+			return
+		}
+		if !enqueueUpdate(report.update()) {
+			updateQueueFull(rw, r)
+			return
+		}
+		err = drafts.remove(userName, id)
+		viewURL = afterDecision(r, id, "review")
+
+	case "discard":
+		err = drafts.remove(userName, id)
+
+	default:
+		httpError(rw, r, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		warnf("Draft failed: ID: %d [%v]\n", id, err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(rw, r, viewURL, http.StatusSeeOther)
+}
+
+func draftsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !draftsEnabled() {
+		notFound(rw, r)
+		return
+	}
+	userName := currentUser(r).Name
+	renderTemplate(rw, r, draftsTemplate, draftsPage{
+		User:   userName,
+		Drafts: visibleDrafts(r, drafts.list(userName)),
+	})
+}
+
+// visibleDrafts drops drafts on jobs in queues the user no longer has
+// access to.
+func visibleDrafts(r *http.Request, list []draft) []draft {
+	visible := []draft{}
+	for _, d := range list {
+		if canAccessJob(r, d.ID) {
+			visible = append(visible, d)
+		}
+	}
+	return visible
+}
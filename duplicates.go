@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+const (
+	duplicatesOff    = "off"
+	duplicatesFlag   = "flag"
+	duplicatesReject = "reject"
+)
+
+// duplicatesUser is recorded as the decider for rejected duplicates.
+const duplicatesUser = "duplicates"
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func backfillHash(q *queue, m jobMeta, state string) {
+	body, err := readBody(q.file(m.ID, state))
+	if err != nil {
+		warnf("Hash failed: ID: %d [%v]\n", m.ID, err)
+		return
+	}
+	_, err = metadata.update(m.ID, func(m *jobMeta) error {
+		m.SHA256 = contentHash(body)
+		return nil
+	})
+	if err != nil {
+		warnf("Hash failed: ID: %d [%v]\n", m.ID, err)
+	}
+}
+
+// findDuplicate returns the earliest decided job other than id with the
+// given content hash.
+func findDuplicate(id int, hash string) (jobMeta, bool) {
+	metadata.RLock()
+	defer metadata.RUnlock()
+
+	found := jobMeta{}
+	for _, m := range metadata.entries {
+		if m.ID == id || m.SHA256 != hash || m.Decision == nil {
+			continue
+		}
+		if found.ID == 0 || m.ID < found.ID {
+			found = *m
+		}
+	}
+	return found, found.ID != 0
+}
+
+// checkDuplicate flags a new job that repeats an earlier decided one, or
+// rejects it outright when configured to.
+func checkDuplicate(m jobMeta) {
+	if cfg.Duplicates == duplicatesOff || m.SHA256 == "" {
+		return
+	}
+	earlier, ok := findDuplicate(m.ID, m.SHA256)
+	if !ok {
+		return
+	}
+
+	_, err := metadata.update(m.ID, func(m *jobMeta) error {
+		m.DuplicateOf = earlier.ID
+		return nil
+	})
+	if err != nil {
+		warnf("Duplicate check failed: ID: %d [%v]\n", m.ID, err)
+		return
+	}
+	if err := audit(auditEntry{
+		Actor:  duplicatesUser,
+		Action: "duplicate",
+		ID:     m.ID,
+		Note:   "duplicate of " + strconv.Itoa(earlier.ID),
+	}); err != nil {
+		warnf("Audit failed: ID: %d [%v]\n", m.ID, err)
+	}
+
+	if cfg.Duplicates == duplicatesReject {
+		queueUpdate(msg{
+			id:     m.ID,
+			dest:   "reject",
+			reason: fmt.Sprintf("Duplicate of job %d", earlier.ID),
+			user:   duplicatesUser,
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	editPath     = "/edit/"
+	revisionsDir = "revisions"
+)
+
+var errConflict = errors.New("job was modified concurrently")
+
+// editLock serialises edits so two saves can't both claim the same
+// revision number. moveJob holds it shared, so a job can't leave review
+// while an edit rewrites its body.
+var editLock sync.RWMutex
+
+func revisionFile(id int, rev int) string {
+	return path.Join(contentPath, revisionsDir, strconv.Itoa(id), strconv.Itoa(rev))
+}
+
+// saveRevision keeps the current body as revision m.Revision and replaces
+// it with body. expected must match the revision the editor started from.
+// A nil fields map leaves the job's field values unchanged.
+func saveRevision(id int, expected int, title string, body []byte, fields map[string]string) (jobMeta, error) {
+	editLock.Lock()
+	defer editLock.Unlock()
+
+	m, ok := metadata.get(id)
+	if !ok || findState(id) != "review" {
+		return jobMeta{}, fmt.Errorf("entry not present: %d", id)
+	}
+	if m.Revision != expected {
+		return m, errConflict
+	}
+
+	file := jobFile(id, "review")
+	old, err := readBody(file)
+	if err != nil {
+		return m, err
+	}
+
+	if err := os.MkdirAll(path.Dir(revisionFile(id, m.Revision)), 0755); err != nil {
+		return m, err
+	}
+	if _, err := storeBody(revisionFile(id, m.Revision), old); err != nil {
+		return m, err
+	}
+	hash, err := storeBody(file, body)
+	if err != nil {
+		return m, err
+	}
+
+	return metadata.update(id, func(m *jobMeta) error {
+		// A derived title follows the body unless the editor changed it
+		derived := title == "" || m.DerivedTitle && title == m.Title
+		m.Revisions = append([]revisionInfo{}, jobRevisions(*m)...)
+		m.Revision++
+		m.Title, m.DerivedTitle = title, false
+		if derived {
+			m.Title = ""
+		}
+		if fields != nil {
+			m.Fields = fields
+		}
+		m.Size = int64(len(body))
+		m.ContentType = sniffContentType(body)
+		m.SHA256 = hash
+		m.Summary = ""
+		deriveTitle(m, body)
+		m.Revisions = append(m.Revisions, revisionInfo{
+			Number:  m.Revision,
+			Title:   m.Title,
+			Size:    m.Size,
+			SavedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+}
+
+func editHandler(rw http.ResponseWriter, r *http.Request) {
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Edit failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Edit failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		saveHandler(rw, r, id)
+		return
+	}
+
+	p, err := loadPage(id, "review")
+	if err != nil {
+		warnf("Edit failed: ID: %d [%v]\n", id, err)
+		notFound(rw, r)
+		return
+	}
+
+	preparePage(id, p, r)
+	renderTemplate(rw, r, editTemplate, p)
+}
+
+func saveHandler(rw http.ResponseWriter, r *http.Request, id int) {
+	expected, err := strconv.Atoi(r.FormValue("revision"))
+	if err != nil {
+		httpError(rw, r, "missing revision", http.StatusBadRequest)
+		return
+	}
+
+	m, ok := metadata.get(id)
+	if !ok {
+		notFound(rw, r)
+		return
+	}
+	body := []byte(r.FormValue("body"))
+	if _, sent := r.PostForm["body"]; !sent || !isTextContent(m.ContentType) {
+		// Binary and masked bodies aren't offered for editing, keep them
+		body, err = readBody(jobFile(id, "review"))
+		if err != nil {
+			notFound(rw, r)
+			return
+		}
+	} else if err := scanChange(id, currentUser(r).Name, body, nil); err != nil {
+		writeSubmitError(rw, r, err)
+		return
+	}
+
+	fields, err := parseFields(queueOf(id).fields, r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = saveRevision(id, expected, r.FormValue("title"), body, fields)
+	if errors.Is(err, errConflict) {
+		httpError(rw, r, tr(r, "edit_conflict"), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		warnf("Save failed: ID: %d [%v]\n", id, err)
+		notFound(rw, r)
+		return
+	}
+
+	http.Redirect(rw, r, urlFor(viewPath, strconv.Itoa(id)), http.StatusSeeOther)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type encryptionConfig struct {
+	// KeyFile holds the 256-bit server key as 64 hex characters.
+	KeyFile string `json:"key_file"`
+}
+
+// Sealed bodies are an envelope: a fresh data key encrypts the body with
+// AES-GCM and is itself encrypted with the server key. Bodies written
+// before encryption was enabled stay readable and are sealed at startup.
+//
+//	magic | key nonce | sealed data key | body nonce | sealed body
+var sealedMagic = []byte("jobServer sealed v1\n")
+
+const dataKeySize = 32
+
+// bodyKey is the server key, nil when bodies are stored in plain.
+var bodyKey cipher.AEAD
+
+var errNoBodyKey = errors.New("body is encrypted but no encryption key is configured")
+
+func loadBodyKey(c encryptionConfig) (cipher.AEAD, error) {
+	if c.KeyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != dataKeySize {
+		return nil, fmt.Errorf("encryption key: %s must hold %d hex encoded bytes", c.KeyFile, dataKeySize)
+	}
+	return newGCM(key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+func sealBody(body []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNonce := make([]byte, bodyKey.NonceSize())
+	bodyNonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(keyNonce); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(bodyNonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, sealedMagic...)
+	out = append(out, keyNonce...)
+	out = bodyKey.Seal(out, keyNonce, dataKey, sealedMagic)
+	out = append(out, bodyNonce...)
+	return aead.Seal(out, bodyNonce, body, sealedMagic), nil
+}
+
+func openSealed(data []byte) ([]byte, error) {
+	if bodyKey == nil {
+		return nil, errNoBodyKey
+	}
+	data = data[len(sealedMagic):]
+
+	keyNonceSize := bodyKey.NonceSize()
+	sealedKeySize := dataKeySize + bodyKey.Overhead()
+	if len(data) < keyNonceSize+sealedKeySize {
+		return nil, errors.New("encrypted body is truncated")
+	}
+	dataKey, err := bodyKey.Open(nil, data[:keyNonceSize], data[keyNonceSize:keyNonceSize+sealedKeySize], sealedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted body: %v", err)
+	}
+	data = data[keyNonceSize+sealedKeySize:]
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted body is truncated")
+	}
+	body, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], sealedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("encrypted body: %v", err)
+	}
+	return body, nil
+}
+
+// encodeBody is what gets written to disk for body.
+func encodeBody(body []byte) ([]byte, error) {
+	if bodyKey == nil {
+		return body, nil
+	}
+	return sealBody(body)
+}
+
+// readBody returns the plain content of a stored job body.
+func readBody(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil || !isSealed(data) {
+		return data, err
+	}
+	return openSealed(data)
+}
+
+// bodyFile reads a stored job body. Plain bodies are streamed from disk,
+// encrypted ones are decrypted into memory.
+type bodyFile struct {
+	io.ReadSeeker
+	file *os.File
+}
+
+func openBody(name string) (*bodyFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, len(sealedMagic))
+	n, _ := io.ReadFull(file, head)
+	if !isSealed(head[:n]) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &bodyFile{ReadSeeker: file, file: file}, nil
+	}
+
+	data, err := io.ReadAll(io.MultiReader(bytes.NewReader(head), file))
+	if err == nil {
+		data, err = openSealed(data)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &bodyFile{ReadSeeker: bytes.NewReader(data), file: file}, nil
+}
+
+func (b *bodyFile) Stat() (os.FileInfo, error) {
+	return b.file.Stat()
+}
+
+func (b *bodyFile) Close() error {
+	return b.file.Close()
+}
+
+// fileSealed reports whether a stored body is encrypted.
+func fileSealed(name string) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, len(sealedMagic))
+	n, _ := io.ReadFull(file, head)
+	return isSealed(head[:n]), nil
+}
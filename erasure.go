@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiErasurePath = "/erasure"
+
+// erasureCertificate is returned to the caller and written to the audit
+// log. The submitter is only recorded as a hash so the certificate does
+// not itself keep the erased identifier.
+type erasureCertificate struct {
+	SubmitterSHA256 string `json:"submitter_sha256"`
+	Jobs            []int  `json:"jobs"`
+	Held            []int  `json:"held,omitempty"`
+	ObjectsRemoved  int    `json:"objects_removed"`
+	// QuarantineRemoved counts the submitter's infected submissions erased
+	QuarantineRemoved int       `json:"quarantine_removed"`
+	ErasedBy          string    `json:"erased_by"`
+	ErasedAt          time.Time `json:"erased_at"`
+}
+
+// submittedBy splits a submitter's jobs into those that may be erased and
+// those under legal hold.
+func submittedBy(submitter string) ([]int, []int) {
+	metadata.RLock()
+	defer metadata.RUnlock()
+
+	ids := []int{}
+	var held []int
+	for _, m := range metadata.entries {
+		if m.Submitter != submitter {
+			continue
+		}
+		if onHold(*m) {
+			held = append(held, m.ID)
+		} else {
+			ids = append(ids, m.ID)
+		}
+	}
+	sort.Ints(ids)
+	sort.Ints(held)
+	return ids, held
+}
+
+// eraseJob removes a job's body in every state, its revisions,
+// attachments, drafts, claim and metadata.
+func eraseJob(id int) error {
+	q := queueOf(id)
+	for _, dir := range dirs {
+		sm := q.states(dir, id)
+		sm.Lock()
+		delete(sm.idMap, id)
+		sm.Unlock()
+
+		if err := os.Remove(q.file(id, dir)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	name := strconv.Itoa(id)
+	if err := os.RemoveAll(path.Join(contentPath, revisionsDir, name)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(contentPath, attachmentDir, name)); err != nil {
+		return err
+	}
+	if err := drafts.removeJob(id); err != nil {
+		return err
+	}
+	if err := skips.removeJob(id); err != nil {
+		return err
+	}
+	claims.release(id)
+	recordState(stateEvent{Type: stateRemove, ID: id, Queue: q.name})
+	return metadata.remove(id)
+}
+
+// apiErasureHandler irreversibly erases everything submitted by one
+// submitter, quarantined submissions and decisions still pending
+// included. Bodies shared with other submitters' jobs stay in object
+// storage for those jobs, and jobs under legal hold are kept and listed.
+// The audit log is append-only and shipped elsewhere, so entries naming
+// the submitter, such as quarantines, are kept, as are responses held for
+// Idempotency-Key retries until idempotency_window runs out.
+func apiErasureHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	submitter := strings.TrimSpace(r.FormValue("submitter"))
+	if submitter == "" {
+		httpError(rw, r, "missing submitter", http.StatusBadRequest)
+		return
+	}
+	if holds.has(holdSubmitter, submitter) {
+		writeAPIError(rw, r, http.StatusConflict, apiError{
+			Code:    codeLegalHold,
+			Message: "submitter is under legal hold",
+			Details: map[string]string{"submitter": submitter},
+		})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(submitter))
+	cert := erasureCertificate{
+		SubmitterSHA256: hex.EncodeToString(sum[:]),
+		ErasedBy:        currentUser(r).Name,
+		ErasedAt:        time.Now().UTC(),
+	}
+	cert.Jobs, cert.Held = submittedBy(submitter)
+
+	erased := map[int]bool{}
+	hashes := map[string]bool{}
+	for _, id := range cert.Jobs {
+		if m, ok := metadata.get(id); ok && m.SHA256 != "" {
+			hashes[m.SHA256] = true
+		}
+		if err := eraseJob(id); err != nil {
+			warnf("Erase failed: ID: %d [%v]\n", id, err)
+			httpError(rw, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		erased[id] = true
+	}
+	if err := erasePending(erased); err != nil {
+		warnf("Pending erase failed: %v\n", err)
+	}
+	if err := eraseSpooled(hashes); err != nil {
+		warnf("Spool erase failed: %v\n", err)
+	}
+	quarantined, err := eraseQuarantined(submitter)
+	if err != nil {
+		warnf("Erase failed: quarantine [%v]\n", err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cert.QuarantineRemoved = quarantined
+
+	// Contact details go with the jobs; nothing is left to notify about
+	if _, err := subscriptions.remove(submitter); err != nil {
+		warnf("Subscription removal failed: %v\n", err)
+	}
+
+	removed, err := collectObjects()
+	if err != nil {
+		warnf("Object collection failed: %v\n", err)
+	}
+	cert.ObjectsRemoved = removed
+
+	ids := make([]string, len(cert.Jobs))
+	for i, id := range cert.Jobs {
+		ids[i] = strconv.Itoa(id)
+	}
+	note := fmt.Sprintf("submitter sha256:%s jobs:[%s] objects_removed:%d quarantine_removed:%d", cert.SubmitterSHA256, strings.Join(ids, ","), removed, quarantined)
+	if len(cert.Held) > 0 {
+		note += fmt.Sprintf(" held:%d", len(cert.Held))
+	}
+	if err := audit(auditEntry{At: cert.ErasedAt, Actor: cert.ErasedBy, Action: "erase", Note: note}); err != nil {
+		warnf("Erase audit failed: %v\n", err)
+	}
+
+	writeJSON(rw, http.StatusOK, cert)
+}
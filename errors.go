@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	errorTemplate   = "error.html"
+	requestIDHeader = "X-Request-Id"
+)
+
+// Request IDs passed in by a proxy are kept if they look like one.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// API errors carry one of these codes, which clients can branch on; the
+// message is for humans and may change.
+const (
+	codeBadRequest        = "bad_request"
+	codeUnauthorized      = "unauthorized"
+	codeForbidden         = "forbidden"
+	codeNotFound          = "not_found"
+	codeMethodNotAllowed  = "method_not_allowed"
+	codeConflict          = "conflict"
+	codeBodyTooLarge      = "body_too_large"
+	codeUnprocessable     = "unprocessable"
+	codeUnavailable       = "unavailable"
+	codeInternal          = "internal"
+	codeEmptyBody         = "empty_body"
+	codeInvalidField      = "invalid_field"
+	codeUnknownTeam       = "unknown_team"
+	codeUnknownQueue      = "unknown_queue"
+	codeInvalidAttachment = "invalid_attachment"
+	codeInvalidDecision   = "invalid_decision"
+	codeInvalidTransition = "invalid_transition"
+	codeClaimed           = "claimed"
+	codeStaleRevision     = "stale_revision"
+	codeBinaryDiff        = "binary_diff"
+	codeLegalHold         = "legal_hold"
+	codeQueueFull         = "queue_full"
+	codeMaintenance       = "maintenance"
+	codeAPIVersionGone    = "api_version_gone"
+
+	codeInvalidIdempotencyKey = "invalid_idempotency_key"
+	codeIdempotencyKeyReused  = "idempotency_key_reused"
+	codeIdempotencyInProgress = "idempotency_in_progress"
+	codePreconditionRequired  = "precondition_required"
+	codeStaleJob              = "stale_job"
+	codeQuotaExceeded         = "quota_exceeded"
+	codeInvalidSubmitter      = "invalid_submitter"
+	codeAlreadyAppealed       = "already_appealed"
+	codeRereviewPending       = "rereview_pending"
+	codeHookRefused           = "hook_refused"
+	codeInfected              = "infected"
+	codeSchemaViolation       = "schema_violation"
+	codeInvalidParameter      = "invalid_parameter"
+	codeInvalidCursor         = "invalid_cursor"
+)
+
+// apiError is the body of every error returned to API clients.
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+type errorPage struct {
+	Title     string
+	Message   string
+	RequestID string
+	Back      string
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDHandler tags every request with an ID, echoed in the response
+// header, in error pages and in the access log, so a user's report can be
+// matched to the server's logs.
+func requestIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		rw.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// wantsJSON reports whether the client is a script rather than a browser.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, apiPrefix) {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// backURL leads from an error to the queue the request was about.
+func backURL(r *http.Request) string {
+	if m := validQueuePath.FindStringSubmatch(r.URL.Path); m != nil {
+		if q, ok := lookupQueue(m[1]); ok && q.name != "" {
+			return urlFor(queuePath, q.name)
+		}
+	}
+	return urlFor(rootPath)
+}
+
+// codeForStatus is the code of errors that have no more specific one.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeBadRequest
+	case http.StatusUnauthorized:
+		return codeUnauthorized
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusConflict:
+		return codeConflict
+	case http.StatusRequestEntityTooLarge:
+		return codeBodyTooLarge
+	case http.StatusUnprocessableEntity:
+		return codeUnprocessable
+	case http.StatusPreconditionRequired:
+		return codePreconditionRequired
+	case http.StatusServiceUnavailable:
+		return codeUnavailable
+	}
+	return codeInternal
+}
+
+// httpError replaces http.Error: browsers get the error page, API clients
+// a JSON error, both with the request ID.
+func httpError(rw http.ResponseWriter, r *http.Request, message string, status int) {
+	writeError(rw, r, status, codeForStatus(status), message)
+}
+
+// writeError is httpError with a specific code for API clients.
+func writeError(rw http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeAPIError(rw, r, status, apiError{Code: code, Message: message})
+}
+
+// writeAPIError sends e to API clients and the error page to browsers,
+// which don't see the code or details.
+func writeAPIError(rw http.ResponseWriter, r *http.Request, status int, e apiError) {
+	message := e.Message
+	h := rw.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "no-store")
+
+	if wantsJSON(r) {
+		e.RequestID = requestID(r)
+		writeJSON(rw, status, e)
+		return
+	}
+
+	page := errorPage{Title: fmt.Sprintf("%d %s", status, http.StatusText(status)), Message: message, RequestID: requestID(r), Back: backURL(r)}
+	buf, ok := executeTemplate(rw, r, errorTemplate, page)
+	if !ok {
+		return
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	buf.WriteTo(rw)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	escalatePath        = "/escalate/"
+	escalationsPath     = "/escalations"
+	escalationsTemplate = "escalations.html"
+)
+
+type escalation struct {
+	By   string    `json:"by"`
+	Note string    `json:"note,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+type escalatedJob struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title,omitempty"`
+	Submitter string `json:"submitter,omitempty"`
+	escalation
+}
+
+type escalationsPage struct {
+	Team      string         `json:"team,omitempty"`
+	Submitter string         `json:"submitter,omitempty"`
+	Jobs      []escalatedJob `json:"jobs"`
+}
+
+// canDecide reports whether the user may act on a job in state. Escalated
+// jobs are reserved for admins, appealed ones for senior reviewers.
+func canDecide(r *http.Request, id int, state string) bool {
+	switch state {
+	case "review":
+		return canSeeJob(r, id)
+	case "escalate":
+		return isAdmin(r)
+	case "appeal":
+		return isSenior(r)
+	}
+	return false
+}
+
+// afterDecision picks where to send the user once job id in state has been
+// handled: the next job in the same queue, or back to the escalation or
+// appeal list.
+func afterDecision(r *http.Request, id int, state string) string {
+	switch state {
+	case "escalate":
+		return urlFor(escalationsPath)
+	case "appeal":
+		return urlFor(appealsPath)
+	}
+	q := queueOf(id)
+	return q.reviewURL(getRandomId(q, currentUser(r).Name, id))
+}
+
+func recordEscalation(m msg) error {
+	claims.release(m.id)
+	meta, err := metadata.update(m.id, func(meta *jobMeta) error {
+		meta.Escalation = &escalation{
+			By:   m.user,
+			Note: m.reason,
+			At:   time.Now().UTC(),
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	publishEvent(eventDecided, m.id, jobEvent{User: m.user, State: m.dest, Reason: m.reason, At: meta.Escalation.At})
+
+	if !cfg.Escalation.empty() {
+		go notifyEscalation(meta)
+	}
+	return nil
+}
+
+func notifyEscalation(m jobMeta) {
+	e := m.Escalation
+	subject := fmt.Sprintf("%s escalated by %s", jobLabel(m), e.By)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s was escalated by %s at %s.\n", jobLabel(m), e.By, e.At.Format(time.RFC3339))
+	if e.Note != "" {
+		fmt.Fprintf(&text, "\n%s\n", e.Note)
+	}
+	fmt.Fprintf(&text, "\n%s\n", urlFor(viewPath, strconv.Itoa(m.ID)))
+
+	if err := deliver(cfg.Escalation, subject, text.String(), m); err != nil {
+		warnf("Escalation notify failed: ID: %d [%v]\n", m.ID, err)
+	}
+}
+
+func escalateHandler(rw http.ResponseWriter, r *http.Request) {
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Escalate failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Escalate failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+	if status, failure := checkIfMatch(r, id); failure != nil {
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+	if findState(id) != "review" {
+		warnf("Escalate failed: ID: %s [entry not present]\n", title)
+		notFound(rw, r)
+		return
+	}
+	if !canSeeJob(r, id) {
+		httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+		return
+	}
+
+	userName := currentUser(r).Name
+	note := strings.TrimSpace(r.FormValue("reason"))
+	if err := checkDecisionHook(decisionReport{ID: id, From: "review", To: "escalate", Reason: note, By: userName}); err != nil {
+		writeError(rw, r, http.StatusUnprocessableEntity, codeHookRefused, err.Error())
+		return
+	}
+	if !enqueueUpdate(msg{id: id, dest: "escalate", reason: note, user: userName, checked: true}) {
+		updateQueueFull(rw, r)
+		return
+	}
+
+	http.Redirect(rw, r, afterDecision(r, id, "review"), http.StatusFound)
+}
+
+func escalationsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	team, ok := requestTeam(rw, r)
+	if !ok {
+		return
+	}
+
+	ids := []int{}
+	for _, q := range queues {
+		ids = append(ids, q.ids("escalate")...)
+	}
+
+	page := escalationsPage{Team: team, Submitter: r.FormValue("submitter"), Jobs: []escalatedJob{}}
+	for _, id := range ids {
+		job := escalatedJob{ID: id}
+		if m, ok := metadata.get(id); ok {
+			if (team != "" && m.Team != team) || (page.Submitter != "" && m.Submitter != page.Submitter) {
+				continue
+			}
+			job.Title = m.Title
+			job.Submitter = m.Submitter
+			if m.Escalation != nil {
+				job.escalation = *m.Escalation
+			}
+		}
+		page.Jobs = append(page.Jobs, job)
+	}
+	sort.Slice(page.Jobs, func(i, j int) bool {
+		return page.Jobs[i].At.Before(page.Jobs[j].At)
+	})
+
+	rw.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		writeJSON(rw, http.StatusOK, page)
+		return
+	}
+	renderTemplate(rw, r, escalationsTemplate, page)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventsConfig publishes job lifecycle events to NATS, to Kafka through a
+// Kafka REST proxy, or both. NATS subjects get the event type appended
+// (jobserver.events.created); Kafka records are keyed by job ID.
+type eventsConfig struct {
+	NATS      string `json:"nats"`
+	Subject   string `json:"subject"`
+	KafkaREST string `json:"kafka_rest"`
+	Topic     string `json:"topic"`
+}
+
+const (
+	eventCreated  = "created"
+	eventClaimed  = "claimed"
+	eventDecided  = "decided"
+	eventExpired  = "expired"
+	eventAppealed = "appealed"
+
+	eventBuffer     = 1000
+	claimSweepEvery = 30 * time.Second
+)
+
+// jobEvent is the published JSON schema, one object per event:
+//
+//	type    created, claimed, decided, expired or appealed
+//	id      job ID
+//	queue   queue name, empty for the default queue
+//	team    team the job is routed to, if any
+//	at      RFC 3339 time of the event
+//	user    submitter (created), reviewer (claimed, expired), decider
+//	        or appellant
+//	state   decided: accept, reject or escalate; appealed: appeal
+//	reason  the decision reason or grounds of appeal, if given
+type jobEvent struct {
+	Type   string    `json:"type"`
+	ID     int       `json:"id"`
+	Queue  string    `json:"queue,omitempty"`
+	Team   string    `json:"team,omitempty"`
+	At     time.Time `json:"at"`
+	User   string    `json:"user,omitempty"`
+	State  string    `json:"state,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+var jobEvents chan jobEvent
+
+func (c eventsConfig) enabled() bool {
+	return c.NATS != "" || c.KafkaREST != ""
+}
+
+func (c *eventsConfig) validate() error {
+	if c.NATS != "" {
+		u, err := url.Parse(c.NATS)
+		if err != nil || u.Scheme != "nats" || u.Host == "" {
+			return fmt.Errorf("invalid events.nats: %q", c.NATS)
+		}
+	}
+	if c.KafkaREST != "" {
+		u, err := url.Parse(c.KafkaREST)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid events.kafka_rest: %q", c.KafkaREST)
+		}
+		if c.Topic == "" {
+			return fmt.Errorf("events.topic is required with events.kafka_rest")
+		}
+	}
+	if c.Subject == "" {
+		c.Subject = "jobserver.events"
+	}
+	return nil
+}
+
+// publishEvent queues an event about job id and tells its submitter, if
+// they asked to hear about it. Events are dropped rather than delaying
+// requests when the broker falls behind.
+func publishEvent(kind string, id int, e jobEvent) {
+	e.Type = kind
+	e.ID = id
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	if m, ok := metadata.get(id); ok {
+		e.Queue = m.Queue
+		e.Team = m.Team
+		go notifySubmitter(m.Submitter, e)
+		go runDecidedHook(e)
+	}
+	queueTrackers(e)
+	if jobEvents == nil {
+		return
+	}
+
+	select {
+	case jobEvents <- e:
+	default:
+		warnf("Event buffer full, dropped %s event: ID: %d\n", kind, id)
+	}
+}
+
+func startEvents() {
+	if !cfg.Events.enabled() {
+		return
+	}
+	jobEvents = make(chan jobEvent, eventBuffer)
+	go runEvents(cfg.Events)
+	go sweepClaims()
+}
+
+func runEvents(c eventsConfig) {
+	nats := &natsPublisher{config: c}
+	for e := range jobEvents {
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if c.NATS != "" {
+			if err := nats.publish(c.Subject+"."+e.Type, data); err != nil {
+				warnf("NATS publish failed: ID: %d [%v]\n", e.ID, err)
+			}
+		}
+		if c.KafkaREST != "" {
+			if err := postKafka(c, e, data); err != nil {
+				warnf("Kafka publish failed: ID: %d [%v]\n", e.ID, err)
+			}
+		}
+	}
+}
+
+// sweepClaims reports claims that lapsed without a decision. Every replica
+// tracks claims, but only the leader reports them.
+func sweepClaims() {
+	seen := map[int]claim{}
+	for range time.Tick(claimSweepEvery) {
+		now := time.Now()
+		current := claims.active(now)
+		for id, c := range seen {
+			if held, ok := current[id]; ok && held.User == c.User {
+				continue
+			}
+			if isLeader() && findState(id) == "review" {
+				publishEvent(eventExpired, id, jobEvent{User: c.User, At: c.Expires.UTC()})
+			}
+		}
+		seen = current
+	}
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func postKafka(c eventsConfig, e jobEvent, data []byte) error {
+	body, err := json.Marshal(map[string][]kafkaRecord{
+		"records": {{Key: fmt.Sprint(e.ID), Value: data}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(c.KafkaREST, "/") + "/topics/" + url.PathEscape(c.Topic)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// natsPublisher speaks the NATS text protocol over one connection,
+// answering the server's keepalive pings and reconnecting after errors.
+type natsPublisher struct {
+	sync.Mutex
+	config eventsConfig
+	conn   net.Conn
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+func (p *natsPublisher) connect() error {
+	u, err := url.Parse(p.config.NATS)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	rd := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	info, err := rd.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting: %q", info)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	hello := natsConnect{Name: "jobServer"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			hello.User, hello.Pass = u.User.Username(), pass
+		} else {
+			hello.Token = u.User.Username()
+		}
+	}
+	data, _ := json.Marshal(hello)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", data); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn = conn
+	go p.serve(conn, rd)
+	return nil
+}
+
+// serve answers PING and reports errors until the connection closes.
+func (p *natsPublisher) serve(conn net.Conn, rd *bufio.Reader) {
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.Lock()
+			conn.Write([]byte("PONG\r\n"))
+			p.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			infof("NATS: %s", line)
+		}
+	}
+}
+
+func (p *natsPublisher) publish(subject string, data []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\n", subject, len(data), data)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	exportPath     = "/admin/export"
+	exportManifest = "manifest.json"
+)
+
+// An export packs the accepted jobs, optionally only those accepted in a
+// date range, into a ZIP for the teams that act on them: each job's body
+// and attachments under jobs/<id>/, and a manifest.json describing them.
+// It is served from /admin/export and run as "jobServer export".
+
+type exportManifestFile struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	From        *time.Time    `json:"from,omitempty"`
+	Before      *time.Time    `json:"before,omitempty"`
+	Jobs        []exportedJob `json:"jobs"`
+}
+
+type exportedJob struct {
+	ID          int               `json:"id"`
+	Queue       string            `json:"queue,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Summary     string            `json:"summary,omitempty"`
+	Submitter   string            `json:"submitter,omitempty"`
+	Team        string            `json:"team,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	SubmittedAt time.Time         `json:"submitted_at"`
+	AcceptedAt  time.Time         `json:"accepted_at"`
+	AcceptedBy  string            `json:"accepted_by"`
+	Reason      string            `json:"reason,omitempty"`
+	ContentType string            `json:"content_type"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Body        exportedFile      `json:"body"`
+	Attachments []exportedFile    `json:"attachments,omitempty"`
+}
+
+type exportedFile struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// acceptedIn returns the jobs accepted in the range, oldest decision first.
+func acceptedIn(from, to time.Time) []jobMeta {
+	accepted := []jobMeta{}
+	metadata.RLock()
+	for _, m := range metadata.entries {
+		if d := m.Decision; d != nil && d.State == "accept" && inRange(d.At, from, to) {
+			accepted = append(accepted, *m)
+		}
+	}
+	metadata.RUnlock()
+
+	sort.Slice(accepted, func(i, j int) bool {
+		a, b := accepted[i].Decision.At, accepted[j].Decision.At
+		return a.Before(b) || a.Equal(b) && accepted[i].ID < accepted[j].ID
+	})
+	return accepted
+}
+
+// bodyExtension picks the file extension a body is exported with.
+func bodyExtension(contentType string) string {
+	base, _, _ := mime.ParseMediaType(contentType)
+	switch base {
+	case "text/plain":
+		return ".txt"
+	case "text/markdown":
+		return ".md"
+	case "", "application/octet-stream":
+		return ""
+	}
+	if exts, err := mime.ExtensionsByType(base); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// writeExport writes the ZIP of the jobs to w. Jobs no longer in the
+// accept directory, decided again since, are left out. It returns how many
+// jobs went in.
+func writeExport(w io.Writer, jobs []jobMeta, from, to time.Time) (int, error) {
+	zw := zip.NewWriter(w)
+	manifest := exportManifestFile{GeneratedAt: time.Now().UTC(), Jobs: []exportedJob{}}
+	if !from.IsZero() {
+		manifest.From = &from
+	}
+	if !to.IsZero() {
+		manifest.Before = &to
+	}
+
+	for _, m := range jobs {
+		q, ok := lookupQueue(m.Queue)
+		if !ok {
+			continue
+		}
+		body, err := openBody(q.file(m.ID, "accept"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return len(manifest.Jobs), err
+		}
+
+		dir := path.Join("jobs", strconv.Itoa(m.ID))
+		job := exportedJob{
+			ID:          m.ID,
+			Queue:       m.Queue,
+			Title:       m.Title,
+			Summary:     m.Summary,
+			Submitter:   m.Submitter,
+			Team:        m.Team,
+			Tags:        m.Tags,
+			Fields:      m.Fields,
+			SubmittedAt: m.SubmittedAt,
+			AcceptedAt:  m.Decision.At,
+			AcceptedBy:  m.Decision.By,
+			Reason:      m.Decision.Reason,
+			ContentType: m.ContentType,
+			SHA256:      m.SHA256,
+			Body:        exportedFile{Path: path.Join(dir, "body"+bodyExtension(m.ContentType))},
+		}
+		job.Body.Size, err = exportFile(zw, job.Body.Path, m.SubmittedAt, body)
+		body.Close()
+		if err != nil {
+			return len(manifest.Jobs), err
+		}
+
+		for _, a := range m.Attachments {
+			file, err := os.Open(attachmentFile(m.ID, a.Name))
+			if err != nil {
+				return len(manifest.Jobs), err
+			}
+			exported := exportedFile{Name: a.Name, Path: path.Join(dir, "attachments", a.Name)}
+			exported.Size, err = exportFile(zw, exported.Path, m.SubmittedAt, file)
+			file.Close()
+			if err != nil {
+				return len(manifest.Jobs), err
+			}
+			job.Attachments = append(job.Attachments, exported)
+		}
+		manifest.Jobs = append(manifest.Jobs, job)
+	}
+
+	// The manifest goes last, so it lists exactly what was packed
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return len(manifest.Jobs), err
+	}
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: exportManifest, Method: zip.Deflate, Modified: manifest.GeneratedAt})
+	if err != nil {
+		return len(manifest.Jobs), err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return len(manifest.Jobs), err
+	}
+	return len(manifest.Jobs), zw.Close()
+}
+
+func exportFile(zw *zip.Writer, name string, modified time.Time, src io.Reader) (int64, error) {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, src)
+}
+
+// exportName is the file name of an export of the range.
+func exportName(from, to time.Time) string {
+	name := "accepted"
+	if !from.IsZero() {
+		name += "-from-" + from.Format(dayFormat)
+	}
+	if !to.IsZero() {
+		name += "-to-" + to.AddDate(0, 0, -1).Format(dayFormat)
+	}
+	return name + ".zip"
+}
+
+// runExport is the export command. It exits the process.
+func runExport(args []string) {
+	set := flag.NewFlagSet("export", flag.ExitOnError)
+	fromValue := set.String("from", "", "only jobs accepted on or after this date or RFC 3339 time")
+	toValue := set.String("to", "", "only jobs accepted on or before this date, or before this RFC 3339 time")
+	out := set.String("o", "", "file to write the ZIP to (default: named after the range)")
+	set.Parse(args)
+
+	from, to, err := dateRange(*fromValue, *toValue)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	if *out == "" {
+		*out = exportName(from, to)
+	}
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+	n, err := writeExport(file, acceptedIn(from, to), from, to)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		log.Fatalf("Export failed: %v", err)
+	}
+	fmt.Printf("Exported %d accepted jobs to %s\n", n, *out)
+	os.Exit(0)
+}
+
+// exportHandler serves the ZIP of the jobs accepted in the from/to range.
+func exportHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r).Name
+	if err := audit(auditEntry{Actor: user, Action: "export", Note: r.URL.RawQuery}); err != nil {
+		warnf("Export audit failed: %v\n", err)
+	}
+
+	rw.Header().Set("Content-Type", "application/zip")
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": exportName(from, to)}))
+	rw.Header().Set("Cache-Control", "no-store")
+
+	// Headers are sent by now, so a failure can only cut the download short
+	n, err := writeExport(rw, acceptedIn(from, to), from, to)
+	if err != nil {
+		warnf("Export failed: %v\n", err)
+		return
+	}
+	infof("Exported %d accepted jobs to %s\n", n, user)
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a small expression language for routing and priority
+// rules, in the spirit of expr or a spreadsheet formula:
+//
+//	len(body) > 10000 ? 1 : 5
+//	fields.region == "eu" && !contains(labels, "spam")
+//
+// Values are numbers, strings, booleans, null, lists and maps. Operators,
+// loosest binding first: ?:, ||, &&, == !=, < <= > >=, + -, * / %, and
+// unary ! and -. + joins strings when either side is one. Maps are read
+// with a.b or a["b"], lists with a[0]. Functions are listed in
+// exprFuncs, besides matches(s, "regexp"), whose pattern must be a string
+// literal. Expressions only read the variables they are given and always
+// terminate.
+type expression struct {
+	source string
+	root   exprNode
+}
+
+type exprNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type exprLiteral struct{ value interface{} }
+
+type exprVar struct{ name string }
+
+type exprIndex struct{ target, key exprNode }
+
+type exprUnary struct {
+	op      string
+	operand exprNode
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+type exprTernary struct{ cond, then, otherwise exprNode }
+
+type exprCall struct {
+	name string
+	args []exprNode
+}
+
+// exprMatch is a call of matches. Its pattern is compiled along with the
+// expression, so that no pattern taken from a submission is ever compiled.
+type exprMatch struct {
+	subject exprNode
+	re      *regexp.Regexp
+}
+
+type exprToken struct {
+	kind  string // "num", "str", "ident", "op" or "end"
+	text  string
+	value interface{}
+	pos   int
+}
+
+type exprFunc struct {
+	args int
+	call func(args []interface{}) (interface{}, error)
+}
+
+var exprFuncs = map[string]exprFunc{
+	"len": {1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return nil, fmt.Errorf("len of %s", exprType(args[0]))
+	}},
+	"contains": {2, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return strings.Contains(v, exprString(args[1])), nil
+		case []interface{}:
+			for _, item := range v {
+				if exprEqual(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("contains on %s", exprType(args[0]))
+	}},
+	"lower": {1, func(args []interface{}) (interface{}, error) {
+		return strings.ToLower(exprString(args[0])), nil
+	}},
+	"upper": {1, func(args []interface{}) (interface{}, error) {
+		return strings.ToUpper(exprString(args[0])), nil
+	}},
+	"number": {1, func(args []interface{}) (interface{}, error) {
+		if n, ok := args[0].(float64); ok {
+			return n, nil
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(exprString(args[0])), 64)
+		if err != nil {
+			return nil, nil
+		}
+		return n, nil
+	}},
+}
+
+// compileExpression parses source, allowing only the variables in vars.
+func compileExpression(source string, vars []string) (*expression, error) {
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, vars: vars}
+	root, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "end" {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return &expression{source: source, root: root}, nil
+}
+
+func (e *expression) eval(env map[string]interface{}) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// evalBool evaluates the expression as a condition.
+func (e *expression) evalBool(env map[string]interface{}) (bool, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return exprTruthy(v), nil
+}
+
+// evalNumber evaluates the expression, which must come out a number.
+func (e *expression) evalNumber(env map[string]interface{}) (float64, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a number", exprType(v))
+	}
+	return n, nil
+}
+
+func lexExpression(src string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", src[i:j], i)
+			}
+			tokens = append(tokens, exprToken{kind: "num", text: src[i:j], value: n, pos: i})
+			i = j
+
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: src[i:j], pos: i})
+			i = j
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, exprToken{kind: "str", text: src[i : j+1], value: b.String(), pos: i})
+			i = j + 1
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", "(", ")", "[", "]", ".", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", string(c), i)
+			}
+			tokens = append(tokens, exprToken{kind: "op", text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: "end", text: "end of expression", pos: len(src)}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	next   int
+	vars   []string
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) ternary() (exprNode, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return exprTernary{cond, then, otherwise}, nil
+}
+
+// exprLevels are the binary operators by precedence, loosest first.
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(exprLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op, left, right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if op, ok := p.accept("!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op, operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (exprNode, error) {
+	node, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.peek()
+			if t.kind != "ident" {
+				return nil, fmt.Errorf("expected a name at %d, found %q", t.pos, t.text)
+			}
+			p.next++
+			node = exprIndex{node, exprLiteral{t.text}}
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			key, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = exprIndex{node, key}
+			continue
+		}
+		return node, nil
+	}
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	t := p.peek()
+	switch t.kind {
+	case "num", "str":
+		p.next++
+		return exprLiteral{t.value}, nil
+
+	case "ident":
+		p.next++
+		switch t.text {
+		case "true":
+			return exprLiteral{true}, nil
+		case "false":
+			return exprLiteral{false}, nil
+		case "null":
+			return exprLiteral{nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.call(t)
+		}
+		for _, v := range p.vars {
+			if v == t.text {
+				return exprVar{t.text}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown name %q at %d", t.text, t.pos)
+
+	case "op":
+		if t.text == "(" {
+			p.next++
+			node, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *exprParser) call(name exprToken) (exprNode, error) {
+	fn, ok := exprFuncs[name.text]
+	if !ok && name.text != "matches" {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	args := []exprNode{}
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if name.text == "matches" {
+		return matchCall(name, args)
+	}
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", name.text, fn.args, len(args))
+	}
+	return exprCall{name.text, args}, nil
+}
+
+func matchCall(name exprToken, args []exprNode) (exprNode, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("matches takes 2 arguments, not %d", len(args))
+	}
+	lit, _ := args[1].(exprLiteral)
+	pattern, ok := lit.value.(string)
+	if !ok {
+		return nil, fmt.Errorf("matches at %d needs a string literal pattern", name.pos)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("matches at %d: %v", name.pos, err)
+	}
+	return exprMatch{args[0], re}, nil
+}
+
+func (n exprLiteral) eval(env map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n exprVar) eval(env map[string]interface{}) (interface{}, error) {
+	return env[n.name], nil
+}
+
+func (n exprIndex) eval(env map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case map[string]interface{}:
+		return t[exprString(key)], nil
+	case []interface{}:
+		i, ok := key.(float64)
+		if !ok || i < 0 || int(i) >= len(t) {
+			return nil, nil
+		}
+		return t[int(i)], nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("can't index %s", exprType(target))
+}
+
+func (n exprUnary) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !exprTruthy(v), nil
+	}
+	x, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("can't negate %s", exprType(v))
+	}
+	return -x, nil
+}
+
+func (n exprTernary) eval(env map[string]interface{}) (interface{}, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if exprTruthy(cond) {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+func (n exprCall) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := exprFuncs[n.name].call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return v, nil
+}
+
+func (n exprMatch) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.subject.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return n.re.MatchString(exprString(v)), nil
+}
+
+func (n exprBinary) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// && and || only look at the right side when they need to
+	switch n.op {
+	case "&&":
+		if !exprTruthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		return exprTruthy(right), err
+	case "||":
+		if exprTruthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		return exprTruthy(right), err
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(left, right), nil
+	case "!=":
+		return !exprEqual(left, right), nil
+	}
+
+	if n.op == "+" {
+		_, ls := left.(string)
+		_, rs := right.(string)
+		if ls || rs {
+			return exprString(left) + exprString(right), nil
+		}
+	}
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			switch n.op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("can't apply %s to %s and %s", n.op, exprType(left), exprType(right))
+	}
+	switch n.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if n.op == "/" {
+			return l / r, nil
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func exprTruthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return false
+}
+
+func exprEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	default:
+		return fmt.Sprint(x) == fmt.Sprint(b)
+	}
+}
+
+func exprString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func exprType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
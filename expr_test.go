@@ -0,0 +1,207 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLexExpression(t *testing.T) {
+	tests := []struct {
+		src   string
+		kinds []string
+		texts []string
+		err   string
+	}{
+		{src: "", kinds: []string{"end"}, texts: []string{"end of expression"}},
+		{src: "1.5 + x", kinds: []string{"num", "op", "ident", "end"}, texts: []string{"1.5", "+", "x", "end of expression"}},
+		{src: "a<=b", kinds: []string{"ident", "op", "ident", "end"}, texts: []string{"a", "<=", "b", "end of expression"}},
+		{src: `"a\"b"`, kinds: []string{"str", "end"}, texts: []string{`"a\"b"`, "end of expression"}},
+		{src: "'x' != .5", kinds: []string{"str", "op", "num", "end"}, texts: []string{"'x'", "!=", ".5", "end of expression"}},
+		{src: "f(a, b[0])", kinds: []string{"ident", "op", "ident", "op", "ident", "op", "num", "op", "op", "end"}},
+		{src: `"open`, err: "unterminated string at 0"},
+		{src: "1.2.3", err: `invalid number "1.2.3" at 0`},
+		{src: "a # b", err: `unexpected "#" at 2`},
+	}
+	for _, test := range tests {
+		tokens, err := lexExpression(test.src)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("lex %q: error %v, want %q", test.src, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lex %q: %v", test.src, err)
+			continue
+		}
+		kinds, texts := []string{}, []string{}
+		for _, token := range tokens {
+			kinds = append(kinds, token.kind)
+			texts = append(texts, token.text)
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) {
+			t.Errorf("lex %q: kinds %v, want %v", test.src, kinds, test.kinds)
+		}
+		if test.texts != nil && !reflect.DeepEqual(texts, test.texts) {
+			t.Errorf("lex %q: texts %v, want %v", test.src, texts, test.texts)
+		}
+	}
+}
+
+func TestLexExpressionStringValue(t *testing.T) {
+	tokens, err := lexExpression(`"a\tb\n\"c"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\tb\n\"c"; tokens[0].value != want {
+		t.Errorf("value %q, want %q", tokens[0].value, want)
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"", `unexpected "end of expression" at 0`},
+		{"1 +", `unexpected "end of expression" at 3`},
+		{"1 2", `unexpected "2" at 2`},
+		{"(1", `expected ")" at 2, found "end of expression"`},
+		{"a ? 1", `expected ":" at 5, found "end of expression"`},
+		{"nope", `unknown name "nope" at 0`},
+		{"nope(1)", `unknown function "nope" at 0`},
+		{"len(1, 2)", "len takes 1 arguments, not 2"},
+		{"a.'b'", `expected a name at 2, found "'b'"`},
+		{"matches(s)", "matches takes 2 arguments, not 1"},
+		{"matches(s, s)", "matches at 0 needs a string literal pattern"},
+		{`matches(s, "a" + "b")`, "matches at 0 needs a string literal pattern"},
+		{`matches(s, "(")`, "matches at 0: error parsing regexp: missing closing ): `(`"},
+	}
+	for _, test := range tests {
+		_, err := compileExpression(test.src, []string{"a", "s"})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("compile %q: error %v, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	env := map[string]interface{}{
+		"n":      float64(7),
+		"s":      "Hello",
+		"labels": []interface{}{"spam", "eu"},
+		"fields": map[string]interface{}{"region": "eu", "count": "12"},
+		"none":   nil,
+	}
+	vars := []string{"n", "s", "labels", "fields", "none"}
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", float64(7)},
+		{"(1 + 2) * 3", float64(9)},
+		{"10 - 4 - 3", float64(3)},
+		{"7 / 2", 3.5},
+		{"7 % 3", float64(1)},
+		{"7.5 % 2", 1.5},
+		{"5 % 0.5", float64(0)},
+		{"-7 % 3", float64(-1)},
+		{"-n", float64(-7)},
+		{"!true", false},
+		{"n > 5 && n < 10", true},
+		{"n > 10 || s == 'Hello'", true},
+		{"false && n / 0 == 1", false},
+		{"true || n / 0 == 1", true},
+		{"n == 7 ? 'seven' : 'other'", "seven"},
+		{"n != 7 ? 1 : n > 3 ? 2 : 3", float64(2)},
+		{"'a' < 'b'", true},
+		{"s + 1", "Hello1"},
+		{"1 + 'x'", "1x"},
+		{"null == none", true},
+		{"len(s)", float64(5)},
+		{"len(labels)", float64(2)},
+		{"len(fields)", float64(2)},
+		{"len(none)", float64(0)},
+		{"contains(labels, 'spam')", true},
+		{"contains(s, 'ell')", true},
+		{"contains(none, 'x')", false},
+		{"lower(s) + upper(s)", "helloHELLO"},
+		{"number(fields.count) + 1", float64(13)},
+		{"number('abc')", nil},
+		{"fields.region", "eu"},
+		{`fields["region"]`, "eu"},
+		{"fields.missing", nil},
+		{"labels[1]", "eu"},
+		{"labels[5]", nil},
+		{"none.x", nil},
+		{`matches(s, "^H.*o$")`, true},
+		{`matches(fields.region, "^(us|ca)$")`, false},
+	}
+	for _, test := range tests {
+		e, err := compileExpression(test.src, vars)
+		if err != nil {
+			t.Errorf("compile %q: %v", test.src, err)
+			continue
+		}
+		got, err := e.eval(env)
+		if err != nil {
+			t.Errorf("eval %q: %v", test.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("eval %q = %#v, want %#v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestExpressionEvalErrors(t *testing.T) {
+	env := map[string]interface{}{"n": float64(7), "s": "x", "m": map[string]interface{}{}}
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"n / 0", "division by zero"},
+		{"n % 0", "division by zero"},
+		{"n - s", "can't apply - to number and string"},
+		{"-s", "can't negate string"},
+		{"n[0]", "can't index number"},
+		{"len(n)", "len: len of number"},
+		{"contains(m, 1)", "contains: contains on map"},
+	}
+	for _, test := range tests {
+		e, err := compileExpression(test.src, []string{"n", "s", "m"})
+		if err != nil {
+			t.Errorf("compile %q: %v", test.src, err)
+			continue
+		}
+		_, err = e.eval(env)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("eval %q: error %v, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestExpressionEvalTyped(t *testing.T) {
+	e, err := compileExpression("n > 1 ? n : 0", []string{"n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := e.evalNumber(map[string]interface{}{"n": float64(3)}); err != nil || n != 3 {
+		t.Errorf("evalNumber = %v, %v, want 3", n, err)
+	}
+	if ok, err := e.evalBool(map[string]interface{}{"n": float64(0)}); err != nil || ok {
+		t.Errorf("evalBool = %v, %v, want false", ok, err)
+	}
+	if _, err := e.evalNumber(map[string]interface{}{"n": float64(0)}); err != nil {
+		t.Errorf("evalNumber: %v", err)
+	}
+
+	e, err = compileExpression("'x'", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.evalNumber(nil); err == nil || err.Error() != "string is not a number" {
+		t.Errorf("evalNumber error %v, want %q", err, "string is not a number")
+	}
+}
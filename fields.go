@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+	fieldEnum   = "enum"
+)
+
+// fieldPrefix namespaces field form values so they can't clash with body,
+// title and the other submission parameters.
+const fieldPrefix = "field."
+
+var validFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+type fieldDef struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+}
+
+// fieldValue pairs a definition with the value stored on a job.
+type fieldValue struct {
+	fieldDef
+	Value string
+}
+
+func (f fieldDef) validate() error {
+	if !validFieldName.MatchString(f.Name) {
+		return fmt.Errorf("invalid field name: %q", f.Name)
+	}
+	switch f.Type {
+	case fieldString, fieldNumber:
+	case fieldEnum:
+		if len(f.Options) == 0 {
+			return fmt.Errorf("field %s: enum needs options", f.Name)
+		}
+	default:
+		return fmt.Errorf("field %s: invalid type %q", f.Name, f.Type)
+	}
+	return nil
+}
+
+func (f fieldDef) FormName() string {
+	return fieldPrefix + f.Name
+}
+
+func (f fieldDef) check(v string) error {
+	if v == "" {
+		if f.Required {
+			return fmt.Errorf("field %s is required", f.Name)
+		}
+		return nil
+	}
+	switch f.Type {
+	case fieldNumber:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("field %s must be a number", f.Name)
+		}
+	case fieldEnum:
+		for _, option := range f.Options {
+			if v == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("field %s must be one of %s", f.Name, strings.Join(f.Options, ", "))
+	}
+	return nil
+}
+
+func validateFields(defs []fieldDef) error {
+	seen := map[string]bool{}
+	for _, f := range defs {
+		if err := f.validate(); err != nil {
+			return err
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("duplicate field: %s", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// parseFields reads and checks the values for defs from a submitted form.
+// Values for fields not in defs are ignored.
+func parseFields(defs []fieldDef, r *http.Request) (map[string]string, error) {
+	values := map[string]string{}
+	for _, f := range defs {
+		v := strings.TrimSpace(r.FormValue(f.FormName()))
+		if err := f.check(v); err != nil {
+			return nil, err
+		}
+		if v != "" {
+			values[f.Name] = v
+		}
+	}
+	return values, nil
+}
+
+func hasFieldValues(r *http.Request) bool {
+	r.ParseForm()
+	for key := range r.Form {
+		if strings.HasPrefix(key, fieldPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func jobFields(defs []fieldDef, values map[string]string) []fieldValue {
+	fields := []fieldValue{}
+	for _, f := range defs {
+		fields = append(fields, fieldValue{fieldDef: f, Value: values[f.Name]})
+	}
+	return fields
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const flagsPath = "/admin/flags"
+
+// Feature flags gate behaviour that is rolled out gradually. Rules turns
+// the rules engine on for submissions, keyed by submitter; selection
+// turns the configured selection strategy on for reviewers, keyed by user
+// name, with everyone else drawing at random as before.
+const (
+	flagRules     = "rules"
+	flagSelection = "selection"
+)
+
+var knownFlags = map[string]string{
+	flagRules:     "Run the rules engine on submissions",
+	flagSelection: "Use selection.strategy to pick jobs for reviewers",
+}
+
+// featureFlag is on for the names in Users and, when Enabled, for Percent
+// of all others, picked by a hash of the name so each one stays on the
+// same side. Percent is 100 if left out. Flags not configured are on.
+type featureFlag struct {
+	Enabled bool     `json:"enabled"`
+	Percent int      `json:"percent"`
+	Users   []string `json:"users,omitempty"`
+}
+
+type flagState struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	featureFlag
+}
+
+type flagStore struct {
+	sync.RWMutex
+	flags map[string]featureFlag
+}
+
+var flags = flagStore{flags: map[string]featureFlag{}}
+
+func validateFlags(c map[string]featureFlag) error {
+	for name, f := range c {
+		if _, ok := knownFlags[name]; !ok {
+			return fmt.Errorf("flags: unknown flag %q", name)
+		}
+		if f.Percent < 0 || f.Percent > 100 {
+			return fmt.Errorf("flags.%s.percent must be between 0 and 100", name)
+		}
+		if f.Enabled && f.Percent == 0 {
+			f.Percent = 100
+			c[name] = f
+		}
+	}
+	return nil
+}
+
+func (s *flagStore) load(c map[string]featureFlag) {
+	s.Lock()
+	defer s.Unlock()
+
+	for name := range knownFlags {
+		f, ok := c[name]
+		if !ok {
+			f = featureFlag{Enabled: true, Percent: 100}
+		}
+		s.flags[name] = f
+	}
+}
+
+func (s *flagStore) set(name string, f featureFlag) {
+	s.Lock()
+	defer s.Unlock()
+	s.flags[name] = f
+}
+
+func (s *flagStore) list() []flagState {
+	s.RLock()
+	defer s.RUnlock()
+
+	list := []flagState{}
+	for name, f := range s.flags {
+		list = append(list, flagState{Name: name, Description: knownFlags[name], featureFlag: f})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// on reports whether flag name applies to subject.
+func (s *flagStore) on(name string, subject string) bool {
+	s.RLock()
+	f, ok := s.flags[name]
+	s.RUnlock()
+	if !ok {
+		return true
+	}
+
+	for _, u := range f.Users {
+		if u == subject {
+			return true
+		}
+	}
+	if !f.Enabled {
+		return false
+	}
+	if f.Percent >= 100 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + subject))
+	return int(h.Sum32()%100) < f.Percent
+}
+
+// flagsHandler lists the flags (GET) or changes one (POST name, enabled,
+// percent, users) until the next restart, which goes back to the config.
+func flagsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		name := r.FormValue("name")
+		if _, ok := knownFlags[name]; !ok {
+			httpError(rw, r, "unknown flag", http.StatusBadRequest)
+			return
+		}
+		enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			httpError(rw, r, "invalid enabled", http.StatusBadRequest)
+			return
+		}
+		f := featureFlag{Enabled: enabled, Percent: 100, Users: splitMembers(r.FormValue("users"))}
+		if v := r.FormValue("percent"); v != "" {
+			f.Percent, err = strconv.Atoi(v)
+			if err != nil || f.Percent < 0 || f.Percent > 100 {
+				httpError(rw, r, "invalid percent", http.StatusBadRequest)
+				return
+			}
+		}
+		flags.set(name, f)
+
+		note := fmt.Sprintf("%s enabled=%t percent=%d", name, f.Enabled, f.Percent)
+		if len(f.Users) > 0 {
+			note += " users=" + strings.Join(f.Users, ",")
+		}
+		if err := audit(auditEntry{Actor: currentUser(r).Name, Action: "flag", Note: note}); err != nil {
+			warnf("Flag audit failed: %v\n", err)
+		}
+		infof("Flag set: %s\n", note)
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(rw, http.StatusOK, flags.list())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// frontMatterLimit is how far into a body the closing delimiter of the
+// front matter is looked for.
+const frontMatterLimit = 16 << 10
+
+// frontMatter is the YAML header a job file may begin with, between two
+// "---" lines. Title, submitter and tags carry over to the job; other
+// keys are shown with it as they are. Only the plain part of YAML is
+// understood: scalars, quoted or not, and lists written inline as [a, b]
+// or as "- item" lines.
+type frontMatter struct {
+	Title     string
+	Submitter string
+	Tags      []string
+	Extra     []frontMatterField
+}
+
+type frontMatterField struct {
+	Name  string
+	Value string
+}
+
+// splitFrontMatter separates the front matter of body from the rest. head
+// is the front matter with its delimiters, as stored; body without front
+// matter, or with front matter that doesn't parse, comes back whole.
+func splitFrontMatter(body []byte) (*frontMatter, []byte, []byte) {
+	if !bytes.HasPrefix(body, []byte("---\n")) && !bytes.HasPrefix(body, []byte("---\r\n")) {
+		return nil, nil, body
+	}
+	window := body
+	if len(window) > frontMatterLimit {
+		window = window[:frontMatterLimit]
+	}
+
+	lines := []string{}
+	offset := bytes.IndexByte(window, '\n') + 1
+	for offset < len(window) {
+		end := bytes.IndexByte(window[offset:], '\n')
+		if end < 0 {
+			// The closing delimiter may end the file
+			end = len(window) - offset
+		}
+		line := strings.TrimRight(string(window[offset:offset+end]), "\r")
+		offset += end + 1
+		if line == "---" || line == "..." {
+			if offset > len(body) {
+				offset = len(body)
+			}
+			fm, err := parseFrontMatter(lines)
+			if err != nil {
+				debugf("Front matter ignored: %v\n", err)
+				return nil, nil, body
+			}
+			return fm, body[:offset], body[offset:]
+		}
+		lines = append(lines, line)
+	}
+	return nil, nil, body
+}
+
+func parseFrontMatter(lines []string) (*frontMatter, error) {
+	fm := &frontMatter{}
+	values := map[string][]string{}
+	order := []string{}
+
+	key := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if key == "" || line == trimmed {
+				return nil, fmt.Errorf("line %d: list item outside a list", i+2)
+			}
+			item, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+			values[key] = append(values[key], item)
+			continue
+		}
+		if line != trimmed {
+			return nil, fmt.Errorf("line %d: nested values are not supported", i+2)
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 1 {
+			return nil, fmt.Errorf("line %d: expected key: value", i+2)
+		}
+		key = strings.TrimSpace(line[:colon])
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("line %d: %s given twice", i+2, key)
+		}
+		order = append(order, key)
+		values[key] = []string{}
+
+		raw := strings.TrimSpace(line[colon+1:])
+		switch {
+		case raw == "":
+		case strings.HasPrefix(raw, "["):
+			if !strings.HasSuffix(raw, "]") {
+				return nil, fmt.Errorf("line %d: unterminated list", i+2)
+			}
+			for _, part := range strings.Split(raw[1:len(raw)-1], ",") {
+				if part = strings.TrimSpace(part); part == "" {
+					continue
+				}
+				item, err := yamlScalar(part)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", i+2, err)
+				}
+				values[key] = append(values[key], item)
+			}
+		default:
+			value, err := yamlScalar(raw)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+			values[key] = []string{value}
+		}
+	}
+
+	for _, key := range order {
+		list := values[key]
+		switch strings.ToLower(key) {
+		case "title":
+			fm.Title = strings.Join(list, " ")
+		case "submitter":
+			fm.Submitter = strings.Join(list, " ")
+		case "tags":
+			for _, tag := range list {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					fm.Tags = append(fm.Tags, tag)
+				}
+			}
+		default:
+			fm.Extra = append(fm.Extra, frontMatterField{Name: key, Value: strings.Join(list, ", ")})
+		}
+	}
+	return fm, nil
+}
+
+// yamlScalar reads a single plain, single-quoted or double-quoted value.
+func yamlScalar(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := strings.LastIndex(raw, `"`)
+		if end == 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strconv.Unquote(raw[:end+1])
+	case strings.HasPrefix(raw, "'"):
+		end := strings.LastIndex(raw, "'")
+		if end == 0 {
+			return "", errors.New("unterminated string")
+		}
+		return strings.ReplaceAll(raw[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// applyFrontMatter fills in what the job's metadata doesn't say yet from
+// the front matter of its body.
+func applyFrontMatter(m *jobMeta, fm *frontMatter) {
+	if m.Title == "" {
+		m.Title = fm.Title
+	}
+	if m.Submitter == "" {
+		m.Submitter = fm.Submitter
+	}
+	m.Tags = mergeTags(m.Tags, fm.Tags)
+}
+
+// mergeTags adds the tags of more not already in tags.
+func mergeTags(tags []string, more []string) []string {
+	for _, tag := range more {
+		found := false
+		for _, t := range tags {
+			found = found || t == tag
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultGitHubAPI = "https://api.github.com"
+
+// githubConfig mirrors every job as an issue in a GitHub repository. The
+// issue is opened with the job, commented on as it moves, closed when it
+// is decided and reopened on appeal. Labels are added to every issue, with
+// the job's queue as one more. Token needs write access to the issues of
+// Repo ("owner/name"); APIURL points at GitHub Enterprise instead.
+type githubConfig struct {
+	Repo   string   `json:"repo"`
+	Token  string   `json:"token"`
+	APIURL string   `json:"api_url"`
+	Link   string   `json:"link"`
+	Labels []string `json:"labels"`
+}
+
+// githubTracker is the tracker for GitHub Issues.
+type githubTracker struct{}
+
+var githubClient = &http.Client{Timeout: 30 * time.Second}
+
+func (c githubConfig) enabled() bool {
+	return c.Repo != ""
+}
+
+func (c *githubConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if parts := strings.Split(c.Repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid github.repo: %q", c.Repo)
+	}
+	if c.APIURL == "" {
+		c.APIURL = defaultGitHubAPI
+	}
+	u, err := url.Parse(c.APIURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid github.api_url: %q", c.APIURL)
+	}
+	c.APIURL = strings.TrimSuffix(c.APIURL, "/")
+	return nil
+}
+
+func githubRequest(method string, path string, body interface{}, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, cfg.GitHub.APIURL+"/repos/"+cfg.GitHub.Repo+path, payload)
+	if err != nil {
+		return err
+	}
+	if cfg.GitHub.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.GitHub.Token)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// issueBody describes a job without its submitter, as the repository may
+// be read by more people than the queue.
+func issueBody(q *queue, m jobMeta) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(cfg.GitHub.Link, "/") + q.viewURL(m.ID) + "\n")
+	if m.Queue != "" {
+		fmt.Fprintf(&b, "\nQueue: %s", m.Queue)
+	}
+	if m.Team != "" {
+		fmt.Fprintf(&b, "\nTeam: %s", m.Team)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Fprintf(&b, "\nTags: %s", strings.Join(m.Tags, ", "))
+	}
+	return b.String()
+}
+
+func (githubTracker) name() string {
+	return "github"
+}
+
+// sync opens the issue of the job e is about if there is none yet, and on
+// a decision comments on it and closes it, or reopens it on appeal.
+func (githubTracker) sync(e jobEvent, m jobMeta, number string) (string, error) {
+	if number == "" {
+		q := queueOf(e.ID)
+		title := m.Title
+		if title == "" {
+			title = "Job " + strconv.Itoa(m.ID)
+		}
+		labels := append([]string{}, cfg.GitHub.Labels...)
+		if m.Queue != "" {
+			labels = append(labels, m.Queue)
+		}
+		issue := map[string]interface{}{"title": title, "body": issueBody(q, m), "labels": labels}
+		var created struct {
+			Number int `json:"number"`
+		}
+		if err := githubRequest(http.MethodPost, "/issues", issue, &created); err != nil {
+			return number, err
+		}
+		number = strconv.Itoa(created.Number)
+	}
+
+	if e.Type != eventDecided && e.Type != eventAppealed {
+		return number, nil
+	}
+	if err := githubRequest(http.MethodPost, "/issues/"+number+"/comments", map[string]string{"body": trackerComment(e)}, nil); err != nil {
+		return number, err
+	}
+
+	var update map[string]string
+	switch e.State {
+	case "accept":
+		update = map[string]string{"state": "closed", "state_reason": "completed"}
+	case "reject":
+		update = map[string]string{"state": "closed", "state_reason": "not_planned"}
+	case "appeal":
+		update = map[string]string{"state": "open"}
+	default:
+		return number, nil
+	}
+	return number, githubRequest(http.MethodPatch, "/issues/"+number, update, nil)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	holdsFile     = "holds.json"
+	apiHoldsPath  = "/holds"
+	holdJob       = "job"
+	holdSubmitter = "submitter"
+)
+
+// hold keeps a job, or everything from a submitter, from being deleted.
+type hold struct {
+	Kind   string    `json:"kind"`
+	Target string    `json:"target"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+type holdStore struct {
+	sync.RWMutex
+	holds []hold
+}
+
+var holds = holdStore{}
+
+func (s *holdStore) load() error {
+	data, err := os.ReadFile(path.Join(contentPath, holdsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := json.Unmarshal(data, &s.holds); err != nil {
+		return fmt.Errorf("parse %s: %v", holdsFile, err)
+	}
+	return nil
+}
+
+// persist must be called with the lock held.
+func (s *holdStore) persist() error {
+	data, err := json.MarshalIndent(s.holds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(contentPath, holdsFile), data)
+}
+
+func (s *holdStore) list() []hold {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]hold{}, s.holds...)
+}
+
+func (s *holdStore) has(kind string, target string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, h := range s.holds {
+		if h.Kind == kind && h.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *holdStore) place(h hold) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.holds {
+		if s.holds[i].Kind == h.Kind && s.holds[i].Target == h.Target {
+			s.holds[i] = h
+			return s.persist()
+		}
+	}
+	s.holds = append(s.holds, h)
+	sort.Slice(s.holds, func(i, j int) bool {
+		if s.holds[i].Kind != s.holds[j].Kind {
+			return s.holds[i].Kind < s.holds[j].Kind
+		}
+		return s.holds[i].Target < s.holds[j].Target
+	})
+	return s.persist()
+}
+
+func (s *holdStore) lift(kind string, target string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.holds {
+		if s.holds[i].Kind == kind && s.holds[i].Target == target {
+			s.holds = append(s.holds[:i], s.holds[i+1:]...)
+			return s.persist()
+		}
+	}
+	return fmt.Errorf("hold not present: %s %s", kind, target)
+}
+
+// onHold reports whether a job must be kept. Anything that deletes job
+// content has to check it first.
+func onHold(m jobMeta) bool {
+	return holds.has(holdJob, strconv.Itoa(m.ID)) ||
+		(m.Submitter != "" && holds.has(holdSubmitter, m.Submitter))
+}
+
+// holdTarget reads the job= or submitter= parameter naming a hold.
+func holdTarget(r *http.Request) (string, string, error) {
+	job := strings.TrimSpace(r.FormValue("job"))
+	submitter := strings.TrimSpace(r.FormValue("submitter"))
+
+	switch {
+	case job != "" && submitter != "":
+		return "", "", errors.New("give either job or submitter")
+	case job != "":
+		id, err := strconv.Atoi(job)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid job: %q", job)
+		}
+		if _, ok := metadata.get(id); !ok {
+			return "", "", fmt.Errorf("job not present: %d", id)
+		}
+		return holdJob, strconv.Itoa(id), nil
+	case submitter != "":
+		return holdSubmitter, submitter, nil
+	}
+	return "", "", errors.New("missing job or submitter")
+}
+
+// apiHoldsHandler lists (GET), places (POST) and lifts (DELETE) legal
+// holds. Only admins may manage them.
+func apiHoldsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(rw, http.StatusOK, holds.list())
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		rw.Header().Set("Allow", "GET, POST, DELETE")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, target, err := holdTarget(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userName := currentUser(r).Name
+	entry := auditEntry{Actor: userName, Note: kind + " " + target}
+	if kind == holdJob {
+		entry.ID, _ = strconv.Atoi(target)
+		entry.Note = ""
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := holds.lift(kind, target); err != nil {
+			notFound(rw, r)
+			return
+		}
+		entry.Action = "hold_lifted"
+		if err := audit(entry); err != nil {
+			warnf("Hold audit failed: %v\n", err)
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h := hold{
+		Kind:   kind,
+		Target: target,
+		Reason: strings.TrimSpace(r.FormValue("reason")),
+		By:     userName,
+		At:     time.Now().UTC(),
+	}
+	if err := holds.place(h); err != nil {
+		warnf("Hold failed: %v\n", err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entry.Action = "hold"
+	if h.Reason != "" {
+		entry.Note = strings.TrimSpace(entry.Note + " " + h.Reason)
+	}
+	if err := audit(entry); err != nil {
+		warnf("Hold audit failed: %v\n", err)
+	}
+	writeJSON(rw, http.StatusCreated, h)
+}
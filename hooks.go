@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHookTimeout = 10 * time.Second
+	hookStderrLimit    = 4096
+)
+
+// Hook names, as passed to the hook in its input.
+const (
+	hookOnIngest     = "on_ingest"
+	hookPreDecision  = "pre_decision"
+	hookPostDecision = "post_decision"
+)
+
+// hooksConfig runs external executables at points of a job's life, each
+// given as the command and its arguments. The hook reads a hookInput as
+// JSON on stdin. On-ingest and pre-decision hooks can refuse: a non-zero
+// exit turns the submission or decision away, with the first line the
+// hook wrote to stderr as the reason. A hook that can't be run or
+// outlasts Timeout refuses as well. Post-decision hooks run after the
+// fact and can't undo anything.
+type hooksConfig struct {
+	OnIngest     []string `json:"on_ingest"`
+	PreDecision  []string `json:"pre_decision"`
+	PostDecision []string `json:"post_decision"`
+	Timeout      duration `json:"timeout"`
+}
+
+// hookInput is what a hook reads on stdin. Job is an ingestedJob for
+// on-ingest, the stored job otherwise.
+type hookInput struct {
+	Hook     string          `json:"hook"`
+	Job      interface{}     `json:"job"`
+	Decision *decisionReport `json:"decision,omitempty"`
+}
+
+// ingestedJob is a submission as an on-ingest hook sees it, before it is
+// stored and has an ID.
+type ingestedJob struct {
+	Queue       string            `json:"queue,omitempty"`
+	Team        string            `json:"team,omitempty"`
+	Submitter   string            `json:"submitter,omitempty"`
+	ContentType string            `json:"content_type"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Body        string            `json:"body"`
+}
+
+// errHookRefused is wrapped by the errors of hooks that turned something
+// away.
+var errHookRefused = errors.New("refused by hook")
+
+func (c *hooksConfig) validate() error {
+	if c.Timeout.Duration < 0 {
+		return errors.New("hooks.timeout must not be negative")
+	}
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = defaultHookTimeout
+	}
+	commands := map[string][]string{
+		hookOnIngest:     c.OnIngest,
+		hookPreDecision:  c.PreDecision,
+		hookPostDecision: c.PostDecision,
+	}
+	for name, argv := range commands {
+		if len(argv) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			return fmt.Errorf("hooks.%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// runHook runs argv with in on stdin. It returns an error wrapping
+// errHookRefused when the hook exits non-zero.
+func runHook(argv []string, in hookInput) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Hooks.Timeout.Duration)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: hookStderrLimit}
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %s", in.Hook, cfg.Hooks.Timeout.Duration)
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		reason := strings.TrimSpace(strings.SplitN(stderr.String(), "\n", 2)[0])
+		if reason == "" {
+			reason = exit.Error()
+		}
+		return fmt.Errorf("%w: %s", errHookRefused, reason)
+	}
+	return err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards
+// the rest, so a chatty hook can't fill memory.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// checkIngestHook runs the on-ingest hook, if any, on a submission about
+// to be stored.
+func checkIngestHook(s submission, team string, tags []string) error {
+	if len(cfg.Hooks.OnIngest) == 0 {
+		return nil
+	}
+	job := ingestedJob{
+		Queue:       s.queue,
+		Team:        team,
+		Submitter:   s.submitter,
+		ContentType: s.contentType,
+		Fields:      s.fields,
+		Tags:        tags,
+		Body:        string(s.body),
+	}
+	err := runHook(cfg.Hooks.OnIngest, hookInput{Hook: hookOnIngest, Job: job})
+	if err != nil {
+		warnf("Ingest hook failed: [%v]\n", err)
+	}
+	return err
+}
+
+// checkDecisionHook runs the pre-decision hook, if any, on a decision.
+// Decisions from requests are checked before they are queued, so the hook
+// can turn them away with an error; the update worker checks the others
+// before applying them.
+func checkDecisionHook(d decisionReport) error {
+	if len(cfg.Hooks.PreDecision) == 0 {
+		return nil
+	}
+	m, ok := metadata.get(d.ID)
+	if !ok {
+		return fmt.Errorf("metadata not present: %d", d.ID)
+	}
+	err := runHook(cfg.Hooks.PreDecision, hookInput{Hook: hookPreDecision, Job: m, Decision: &d})
+	if err != nil {
+		warnf("Decision hook failed: ID: %d [%v]\n", d.ID, err)
+	}
+	return err
+}
+
+// runDecidedHook runs the post-decision hook, if any, for a decided event.
+func runDecidedHook(e jobEvent) {
+	if len(cfg.Hooks.PostDecision) == 0 || e.Type != eventDecided {
+		return
+	}
+	m, ok := metadata.get(e.ID)
+	if !ok {
+		return
+	}
+	d := decisionReport{ID: e.ID, To: e.State, Reason: e.Reason, By: e.User}
+	if err := runHook(cfg.Hooks.PostDecision, hookInput{Hook: hookPostDecision, Job: m, Decision: &d}); err != nil {
+		warnf("Post-decision hook failed: ID: %d [%v]\n", e.ID, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+const (
+	defaultLocale = "en"
+	langParam     = "lang"
+	langCookie    = "lang"
+)
+
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	names, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	all := map[string]map[string]string{}
+	for _, entry := range names {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("locale %s: %v", entry.Name(), err))
+		}
+		all[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+
+	return all
+}
+
+// matchLocale maps a language tag such as "de-AT" onto a known catalog.
+func matchLocale(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if _, ok := catalogs[tag]; ok {
+		return tag
+	}
+	if i := strings.IndexAny(tag, "-_"); i > 0 {
+		if _, ok := catalogs[tag[:i]]; ok {
+			return tag[:i]
+		}
+	}
+	return ""
+}
+
+type langPref struct {
+	tag string
+	q   float64
+}
+
+func parseAcceptLanguage(header string) []langPref {
+	prefs := []langPref{}
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" {
+			continue
+		}
+		pref := langPref{tag: fields[0], q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					pref.q = q
+				}
+			}
+		}
+		prefs = append(prefs, pref)
+	}
+
+	sort.SliceStable(prefs, func(i, j int) bool {
+		return prefs[i].q > prefs[j].q
+	})
+	return prefs
+}
+
+func requestLocale(r *http.Request) string {
+	if locale := matchLocale(r.URL.Query().Get(langParam)); locale != "" {
+		return locale
+	}
+	if c, err := r.Cookie(langCookie); err == nil {
+		if locale := matchLocale(c.Value); locale != "" {
+			return locale
+		}
+	}
+	for _, pref := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if pref.q <= 0 {
+			continue
+		}
+		if locale := matchLocale(pref.tag); locale != "" {
+			return locale
+		}
+	}
+	return defaultLocale
+}
+
+func translate(locale string, key string, args ...interface{}) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		msg, ok = catalogs[defaultLocale][key]
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+func tr(r *http.Request, key string, args ...interface{}) string {
+	return translate(requestLocale(r), key, args...)
+}
+
+func notFound(rw http.ResponseWriter, r *http.Request) {
+	httpError(rw, r, tr(r, "not_found"), http.StatusNotFound)
+}
+
+// localeHandler remembers an explicit ?lang= choice for later requests.
+func localeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if locale := matchLocale(r.URL.Query().Get(langParam)); locale != "" {
+			http.SetCookie(rw, &http.Cookie{
+				Name:     langCookie,
+				Value:    locale,
+				Path:     urlFor("/"),
+				HttpOnly: true,
+			})
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	maxIdempotencyKey = 255
+	// Larger responses are not kept, their requests are simply not
+	// idempotent
+	maxIdempotentBody = 64 << 10
+)
+
+// idempotentResponse is what a decision request with an Idempotency-Key
+// answered, replayed to retries of the same request within
+// cfg.IdempotencyWindow. Until the first request completes it is only a
+// reservation, and retries are told to wait.
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	Expires     time.Time   `json:"expires"`
+}
+
+// idempotencyStorage keeps responses by key for the idempotency window.
+type idempotencyStorage interface {
+	// reserve stores res under key unless the key is taken, in which case
+	// it returns what the key holds and false.
+	reserve(key string, res idempotentResponse) (idempotentResponse, bool, error)
+	complete(key string, res idempotentResponse) error
+	forget(key string)
+}
+
+// idempotencyStore keeps responses in memory for a single server.
+type idempotencyStore struct {
+	sync.Mutex
+	byKey  map[string]idempotentResponse
+	pruned time.Time
+}
+
+var idempotency idempotencyStorage = &idempotencyStore{byKey: map[string]idempotentResponse{}}
+
+func (s *idempotencyStore) reserve(key string, res idempotentResponse) (idempotentResponse, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pruned) > time.Minute {
+		for k, r := range s.byKey {
+			if now.After(r.Expires) {
+				delete(s.byKey, k)
+			}
+		}
+		s.pruned = now
+	}
+
+	if prev, ok := s.byKey[key]; ok && now.Before(prev.Expires) {
+		return prev, false, nil
+	}
+	s.byKey[key] = res
+	return res, true, nil
+}
+
+func (s *idempotencyStore) complete(key string, res idempotentResponse) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.byKey[key] = res
+	return nil
+}
+
+func (s *idempotencyStore) forget(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.byKey, key)
+}
+
+// idempotencyKey scopes the client's key to its user, so keys chosen by
+// different clients never collide.
+func idempotencyKey(r *http.Request, key string) string {
+	sum := sha256.Sum256([]byte(currentUser(r).Name + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestFingerprint tells a retry from a different request reusing a key.
+func requestFingerprint(r *http.Request) string {
+	r.ParseForm()
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "?" + r.Form.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingWriter keeps a copy of the response it passes on.
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.body.Len()+len(b) > maxIdempotentBody {
+		w.overflow = true
+	} else {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent lets clients retry a decision safely: a request carrying an
+// Idempotency-Key is handled once, and retries with the same key get the
+// first response again instead of deciding twice or failing because the
+// job has already moved. Failures worth retrying (5xx) are not kept.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get(idempotencyHeader)
+		if clientKey == "" || cfg.IdempotencyWindow.Duration == 0 {
+			next(rw, r)
+			return
+		}
+		if len(clientKey) > maxIdempotencyKey {
+			writeError(rw, r, http.StatusBadRequest, codeInvalidIdempotencyKey, "idempotency key too long")
+			return
+		}
+
+		key := idempotencyKey(r, clientKey)
+		fingerprint := requestFingerprint(r)
+		prev, reserved, err := idempotency.reserve(key, idempotentResponse{
+			Fingerprint: fingerprint,
+			Expires:     time.Now().Add(cfg.IdempotencyWindow.Duration),
+		})
+		if err != nil {
+			httpError(rw, r, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		switch {
+		case reserved:
+		case prev.Fingerprint != fingerprint:
+			writeError(rw, r, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "idempotency key was used for a different request")
+			return
+		case !prev.Done:
+			rw.Header().Set("Retry-After", "1")
+			writeError(rw, r, http.StatusConflict, codeIdempotencyInProgress, "a request with this idempotency key is still in progress")
+			return
+		default:
+			h := rw.Header()
+			for name, values := range prev.Header {
+				h[name] = values
+			}
+			h.Set(replayedHeader, "true")
+			rw.WriteHeader(prev.Status)
+			rw.Write(prev.Body)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: rw}
+		next(rec, r)
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError || rec.overflow {
+			idempotency.forget(key)
+			return
+		}
+		// Per-request headers aren't part of the replay
+		rec.header.Del(requestIDHeader)
+		rec.header.Del("Retry-After")
+		err = idempotency.complete(key, idempotentResponse{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      rec.status,
+			Header:      rec.header,
+			Body:        rec.body.Bytes(),
+			Expires:     time.Now().Add(cfg.IdempotencyWindow.Duration),
+		})
+		if err != nil {
+			idempotency.forget(key)
+		}
+	}
+}
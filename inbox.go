@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	sourceInbox = "inbox"
+
+	defaultInboxInterval = 5 * time.Second
+	inboxRejectedDir     = "rejected"
+	inboxTakingDir       = "taking"
+	inboxErrorSuffix     = ".error"
+)
+
+// inboxConfig has the server take in any file dropped into Dir as a new
+// job in Queue, instead of a job file being written into the review
+// directory by hand under the next free number. Files go through the
+// checks API submissions do: size, virus scan, schema, rules and the
+// ingest hook. A file that fails them is moved to Dir/rejected, with the
+// reason beside it in <name>.error; infected ones are quarantined.
+//
+// Dir is looked at every Interval. A file is only taken once it has not
+// changed between two looks, so writers need not rename into place,
+// though hidden files are left alone for those that do.
+type inboxConfig struct {
+	Dir       string   `json:"dir"`
+	Queue     string   `json:"queue"`
+	Submitter string   `json:"submitter"`
+	Interval  duration `json:"interval"`
+}
+
+func (c inboxConfig) enabled() bool {
+	return c.Dir != ""
+}
+
+func (c *inboxConfig) validate(queueNames map[string]bool) error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.Queue != "" && !queueNames[c.Queue] {
+		return fmt.Errorf("inbox.queue: queue not configured: %s", c.Queue)
+	}
+	if c.Submitter != "" && !validSubmitter.MatchString(c.Submitter) {
+		return fmt.Errorf("invalid inbox.submitter: %q", c.Submitter)
+	}
+	if c.Interval.Duration < 0 {
+		return errors.New("inbox.interval must not be negative")
+	}
+	if c.Interval.Duration == 0 {
+		c.Interval.Duration = defaultInboxInterval
+	}
+	return nil
+}
+
+// inboxFile is what a file in the inbox looked like at the last look.
+type inboxFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func startInbox() {
+	if !cfg.Inbox.enabled() {
+		return
+	}
+	for _, dir := range []string{inboxRejectedDir, inboxTakingDir} {
+		if err := os.MkdirAll(path.Join(cfg.Inbox.Dir, dir), 0755); err != nil {
+			warnf("Inbox failed: %v\n", err)
+			return
+		}
+	}
+	if left, _ := os.ReadDir(path.Join(cfg.Inbox.Dir, inboxTakingDir)); len(left) > 0 {
+		// Whether they became jobs is not known, so they are not retried
+		warnf("Inbox has %d files in %s that were being taken when the server stopped\n", len(left), inboxTakingDir)
+	}
+	infof("Watching inbox %s\n", cfg.Inbox.Dir)
+	go func() {
+		ticker := time.NewTicker(cfg.Inbox.Interval.Duration)
+		defer ticker.Stop()
+
+		seen := map[string]inboxFile{}
+		for {
+			select {
+			case <-exit:
+				return
+			case <-ticker.C:
+			}
+			// One replica takes the files, and none while writes are off
+			if !isLeader() || inMaintenance() {
+				continue
+			}
+			seen = scanInbox(seen)
+		}
+	}()
+}
+
+// scanInbox takes in the files that are the same as at the last look and
+// returns what the others look like now.
+func scanInbox(last map[string]inboxFile) map[string]inboxFile {
+	entries, err := os.ReadDir(cfg.Inbox.Dir)
+	if err != nil {
+		warnf("Inbox read failed: %v\n", err)
+		return last
+	}
+	now := map[string]inboxFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		f := inboxFile{info.Size(), info.ModTime()}
+		if prev, ok := last[name]; !ok || prev != f {
+			now[name] = f
+			continue
+		}
+		if err := takeInboxFile(name); err != nil {
+			// Left in place to be tried again
+			warnf("Inbox failed: %s [%v]\n", name, err)
+			now[name] = f
+		}
+	}
+	return now
+}
+
+// takeInboxFile turns one inbox file into a job in review, or rejects it.
+// An error means the file could not be dealt with for now. The file is
+// moved to Dir/taking before the job is created, so that it is never
+// taken twice; one left there was being taken when the server stopped.
+// Unlike a request handler it has no server to recover from a panic, so
+// it does that itself.
+func takeInboxFile(name string) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	file := path.Join(cfg.Inbox.Dir, name)
+	q, ok := lookupQueue(cfg.Inbox.Queue)
+	if !ok {
+		return fmt.Errorf("queue not configured: %s", cfg.Inbox.Queue)
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	body, err := spoolBody(src)
+	src.Close()
+	var tooLarge *sizeLimitError
+	if errors.As(err, &tooLarge) {
+		return rejectInboxFile(file, name, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	defer body.close()
+	if body.size == 0 {
+		return rejectInboxFile(file, name, "empty job body")
+	}
+
+	taking := path.Join(cfg.Inbox.Dir, inboxTakingDir, name)
+	if err := os.Rename(file, taking); err != nil {
+		return err
+	}
+	m, err := submitJob(q, body, nil, jobMeta{
+		Submitter: cfg.Inbox.Submitter,
+		Source:    sourceInbox,
+	})
+	var refused *submitRefusal
+	switch {
+	case errors.As(err, &refused) && refused.Code == codeInfected:
+		warnf("Inbox file quarantined: %s [%s]\n", name, refused.Message)
+		return os.Remove(taking)
+	case errors.As(err, &refused):
+		return rejectInboxFile(taking, name, refused.Error())
+	case err != nil && m.ID == 0:
+		if err := os.Rename(taking, file); err != nil {
+			warnf("Inbox restore failed: %s [%v]\n", name, err)
+		}
+		return err
+	case err != nil:
+		warnf("Inbox file %s is job %d, incomplete [%v]\n", name, m.ID, err)
+	default:
+		infof("Inbox file %s is job %d\n", name, m.ID)
+	}
+	if err := os.Remove(taking); err != nil {
+		warnf("Inbox cleanup failed: %s [%v]\n", name, err)
+	}
+	return nil
+}
+
+// rejectInboxFile moves file, the inbox file name, to Dir/rejected as it
+// failed the checks, with the reason in a file beside it.
+func rejectInboxFile(file string, name string, reason string) error {
+	dest := path.Join(cfg.Inbox.Dir, inboxRejectedDir, name)
+	if err := os.Rename(file, dest); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dest+inboxErrorSuffix, []byte(reason+"\n"), 0644); err != nil {
+		warnf("Inbox reject note failed: %s [%v]\n", name, err)
+	}
+	if err := audit(auditEntry{Action: "inbox_reject", Note: name + ": " + reason}); err != nil {
+		warnf("Inbox audit failed: %v\n", err)
+	}
+	warnf("Inbox file rejected: %s [%s]\n", name, reason)
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// jiraConfig mirrors every job as a Jira issue: the issue is created with
+// the job, updated and commented on as the job moves, and transitioned
+// when it is decided. The connection is configured once; what goes into
+// the issue is a jiraMapping, which queues may override with their own.
+// Link is this server's public address, for linking issues to jobs.
+type jiraConfig struct {
+	URL   string `json:"url"`
+	User  string `json:"user"`
+	Token string `json:"token"`
+	Link  string `json:"link"`
+	jiraMapping
+}
+
+// jiraMapping says where a queue's issues go and what they contain. Fields
+// maps the IDs of Jira text fields (summary, description,
+// customfield_10010, ...) to templates rendered with jiraJob. Transitions name the Jira transition
+// to take per job state: accept, reject, escalate or appeal.
+type jiraMapping struct {
+	Project     string            `json:"project"`
+	IssueType   string            `json:"issue_type"`
+	Fields      map[string]string `json:"fields"`
+	Transitions map[string]string `json:"transitions"`
+
+	fields map[string]*template.Template
+}
+
+// jiraJob is what field templates can refer to.
+type jiraJob struct {
+	ID        int
+	Title     string
+	Queue     string
+	Team      string
+	Submitter string
+	Fields    map[string]string
+	Tags      []string
+	URL       string
+	State     string
+	Reason    string
+}
+
+// jiraTracker is the tracker for Jira.
+type jiraTracker struct{}
+
+var jiraClient = &http.Client{Timeout: 30 * time.Second}
+
+var defaultJiraFields = map[string]string{
+	"summary":     "{{if .Title}}{{.Title}}{{else}}Job {{.ID}}{{end}}",
+	"description": "{{.URL}}",
+}
+
+func (c jiraConfig) enabled() bool {
+	return c.URL != ""
+}
+
+func (c *jiraConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid jira.url: %q", c.URL)
+	}
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	return c.jiraMapping.compile("jira")
+}
+
+func (m *jiraMapping) compile(name string) error {
+	if m.Project == "" {
+		return errors.New(name + ".project is required")
+	}
+	if m.IssueType == "" {
+		m.IssueType = "Task"
+	}
+	for state := range m.Transitions {
+		switch state {
+		case "accept", "reject", "escalate", "appeal":
+		default:
+			return fmt.Errorf("%s.transitions: unknown state %q", name, state)
+		}
+	}
+
+	m.fields = map[string]*template.Template{}
+	for field, text := range defaultJiraFields {
+		if _, ok := m.Fields[field]; !ok {
+			m.fields[field] = template.Must(template.New(field).Parse(text))
+		}
+	}
+	for field, text := range m.Fields {
+		t, err := template.New(field).Parse(text)
+		if err != nil {
+			return fmt.Errorf("%s.fields.%s: %v", name, field, err)
+		}
+		m.fields[field] = t
+	}
+	return nil
+}
+
+// jiraMappingOf is the mapping used for the jobs of q.
+func jiraMappingOf(q *queue) *jiraMapping {
+	if q.jira != nil {
+		return q.jira
+	}
+	return &cfg.Jira.jiraMapping
+}
+
+func (m *jiraMapping) render(job jiraJob) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	for field, t := range m.fields {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, job); err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+		fields[field] = buf.String()
+	}
+	return fields, nil
+}
+
+func jiraRequest(method string, path string, body interface{}, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, cfg.Jira.URL+path, payload)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(cfg.Jira.User, cfg.Jira.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := jiraClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// transitionIssue takes the transition called name, if the issue's
+// workflow currently offers it.
+func transitionIssue(key string, name string) error {
+	var list struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := jiraRequest(http.MethodGet, "/rest/api/2/issue/"+key+"/transitions", nil, &list); err != nil {
+		return err
+	}
+	for _, t := range list.Transitions {
+		if strings.EqualFold(t.Name, name) {
+			body := map[string]interface{}{"transition": map[string]string{"id": t.ID}}
+			return jiraRequest(http.MethodPost, "/rest/api/2/issue/"+key+"/transitions", body, nil)
+		}
+	}
+	return fmt.Errorf("issue %s has no transition %q", key, name)
+}
+
+func (jiraTracker) name() string {
+	return "jira"
+}
+
+// sync brings the issue of the job e is about up to date: creating it if
+// there is none yet, otherwise updating its fields, and on a decision
+// adding a comment and taking the configured transition.
+func (jiraTracker) sync(e jobEvent, m jobMeta, key string) (string, error) {
+	q := queueOf(e.ID)
+	mapping := jiraMappingOf(q)
+
+	job := jiraJob{
+		ID:        m.ID,
+		Title:     m.Title,
+		Queue:     m.Queue,
+		Team:      m.Team,
+		Submitter: m.Submitter,
+		Fields:    m.Fields,
+		Tags:      m.Tags,
+		URL:       strings.TrimSuffix(cfg.Jira.Link, "/") + q.viewURL(m.ID),
+		State:     findState(m.ID),
+		Reason:    e.Reason,
+	}
+	fields, err := mapping.render(job)
+	if err != nil {
+		return key, err
+	}
+
+	if key == "" {
+		fields["project"] = map[string]string{"key": mapping.Project}
+		fields["issuetype"] = map[string]string{"name": mapping.IssueType}
+		var created struct {
+			Key string `json:"key"`
+		}
+		if err := jiraRequest(http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &created); err != nil {
+			return key, err
+		}
+		key = created.Key
+	} else if e.Type != eventCreated {
+		if err := jiraRequest(http.MethodPut, "/rest/api/2/issue/"+key, map[string]interface{}{"fields": fields}, nil); err != nil {
+			return key, err
+		}
+	}
+
+	if e.Type != eventDecided && e.Type != eventAppealed {
+		return key, nil
+	}
+	if err := jiraRequest(http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{"body": trackerComment(e)}, nil); err != nil {
+		return key, err
+	}
+	if name := mapping.Transitions[e.State]; name != "" {
+		return key, transitionIssue(key, name)
+	}
+	return key, nil
+}
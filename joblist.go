@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// jobSummary is a job as the list API shows it, without body or history.
+type jobSummary struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title,omitempty"`
+	State       string    `json:"state"`
+	Queue       string    `json:"queue,omitempty"`
+	Team        string    `json:"team,omitempty"`
+	Submitter   string    `json:"submitter,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	Score       *float64  `json:"score,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Decision    *decision `json:"decision,omitempty"`
+}
+
+type jobPage struct {
+	Jobs       []jobSummary `json:"jobs"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// listCursor marks where a page ended. Pages continue after the last job
+// returned rather than at an offset, so jobs coming in or moving between
+// states meanwhile neither repeat nor skip anything. Key is that job's
+// sort key; a cursor only continues the sort and order it came from.
+type listCursor struct {
+	Sort  string `json:"sort,omitempty"`
+	Order string `json:"order,omitempty"`
+	Key   uint64 `json:"key,omitempty"`
+	After int    `json:"after"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	c := listCursor{}
+	if s == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.After < 0 {
+		return c, errInvalidCursor
+	}
+	if c.Sort == "" {
+		// From before cursors carried their sort: by ID, ascending.
+		c = listCursor{Sort: sortByID, Order: "asc", Key: uint64(c.After), After: c.After}
+	}
+	return c, nil
+}
+
+// listOrder reads ?sort= and ?order=. Jobs are listed by ID, oldest first,
+// unless asked otherwise.
+func listOrder(r *http.Request) (string, string, error) {
+	by := r.FormValue("sort")
+	if by == "" {
+		by = sortByID
+	}
+	if _, ok := sortKeys[by]; !ok {
+		return "", "", errors.New("sort must be one of id, submitted_at, priority")
+	}
+	order := r.FormValue("order")
+	if order == "" {
+		order = "asc"
+	}
+	if order != "asc" && order != "desc" {
+		return "", "", errors.New("order must be asc or desc")
+	}
+	return by, order, nil
+}
+
+func pageSize(r *http.Request) (int, error) {
+	v := r.FormValue("limit")
+	if v == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPageSize {
+		return 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	return n, nil
+}
+
+// apiListJobs lists the jobs the user can see a page at a time, in the
+// order of sort and order. state and queue narrow the list down;
+// next_cursor, passed back as cursor, fetches the next page.
+func apiListJobs(rw http.ResponseWriter, r *http.Request) {
+	limit, err := pageSize(r)
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	by, order, err := listOrder(r)
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidParameter, err.Error())
+		return
+	}
+	cursor, err := decodeCursor(r.FormValue("cursor"))
+	if err == nil && r.FormValue("cursor") != "" && (cursor.Sort != by || cursor.Order != order) {
+		err = errors.New("cursor is for another sort order")
+	}
+	if err != nil {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidCursor, err.Error())
+		return
+	}
+
+	wanted := r.FormValue("state")
+	if wanted != "" && !validState(wanted) {
+		writeError(rw, r, http.StatusBadRequest, codeInvalidParameter, "unknown state")
+		return
+	}
+	visible := map[*queue]bool{}
+	for _, q := range accessibleQueues(r) {
+		visible[q] = true
+	}
+	if name := r.FormValue("queue"); name != "" {
+		q, ok := lookupQueue(name)
+		if !ok || !canAccessQueue(r, q) {
+			writeError(rw, r, http.StatusBadRequest, codeUnknownQueue, "unknown queue")
+			return
+		}
+		visible = map[*queue]bool{q: true}
+	}
+
+	var from *sortEntry
+	if r.FormValue("cursor") != "" {
+		from = &sortEntry{Key: cursor.Key, ID: cursor.After}
+	}
+	page := jobPage{Jobs: []jobSummary{}}
+	var last sortEntry
+	metadata.scan(by, from, order == "desc", func(e sortEntry) bool {
+		if len(page.Jobs) == limit {
+			page.NextCursor = listCursor{Sort: by, Order: order, Key: last.Key, After: last.ID}.encode()
+			return false
+		}
+		if !visible[queueOf(e.ID)] || !canSeeJob(r, e.ID) {
+			return true
+		}
+		state := findState(e.ID)
+		if state == "" || wanted != "" && state != wanted {
+			return true
+		}
+		m, ok := metadata.get(e.ID)
+		if !ok {
+			return true
+		}
+		page.Jobs = append(page.Jobs, jobSummary{
+			ID:          e.ID,
+			Title:       m.Title,
+			State:       state,
+			Queue:       m.Queue,
+			Team:        m.Team,
+			Submitter:   m.Submitter,
+			SubmittedAt: m.SubmittedAt,
+			Score:       m.Score,
+			Tags:        m.Tags,
+			Decision:    m.Decision,
+		})
+		last = e
+		return true
+	})
+	writeJSON(rw, http.StatusOK, page)
+}
+
+func validState(state string) bool {
+	for _, dir := range dirs {
+		if dir == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+var idAlloc struct {
+	sync.Mutex
+	last int
+}
+
+func initLastID() {
+	idAlloc.Lock()
+	defer idAlloc.Unlock()
+
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range q.ids(dir) {
+				if id > idAlloc.last {
+					idAlloc.last = id
+				}
+			}
+		}
+	}
+	metadata.RLock()
+	for id := range metadata.entries {
+		if id > idAlloc.last {
+			idAlloc.last = id
+		}
+	}
+	metadata.RUnlock()
+}
+
+func nextID() (int, error) {
+	if redisDB != nil {
+		return redisNextID()
+	}
+
+	idAlloc.Lock()
+	defer idAlloc.Unlock()
+
+	idAlloc.last++
+	return idAlloc.last, nil
+}
+
+// jobBody is the content of a new job, which stores itself at the path it
+// is given.
+type jobBody interface {
+	store(file string) error
+}
+
+// bodyBytes is a job body held in memory.
+type bodyBytes []byte
+
+func (b bodyBytes) store(file string) error {
+	_, err := storeBody(file, b)
+	return err
+}
+
+// createJob stores a new submission in the review directory of q and makes
+// it available to reviewers. sub carries what the submission supplied
+// beyond the body: team, fields, tags and who submitted it how.
+func createJob(q *queue, body jobBody, sub jobMeta) (jobMeta, error) {
+	id, err := nextID()
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	file := q.file(id, "review")
+	if err := body.store(file); err != nil {
+		return jobMeta{}, err
+	}
+
+	m, err := ingest(q, id, "review")
+	if err != nil {
+		os.Remove(file)
+		return jobMeta{}, err
+	}
+	m.Team = sub.Team
+	m.Fields = sub.Fields
+	m.Tags = mergeTags(sub.Tags, m.Tags)
+	if sub.Submitter != "" {
+		m.Submitter = sub.Submitter
+	}
+	m.Source = sub.Source
+	m.RelayedBy = sub.RelayedBy
+	m.Score = sub.Score
+	m.Labels = sub.Labels
+	m.Deferred = sub.Deferred
+	m.Scan = sub.Scan
+	if err := metadata.put(m); err != nil {
+		return jobMeta{}, err
+	}
+
+	sm := q.states("review", id)
+	sm.Lock()
+	sm.idMap[id] = true
+	sm.Unlock()
+	recordState(stateEvent{Type: stateAdd, ID: id, Queue: q.name, State: "review", At: m.SubmittedAt})
+
+	publishEvent(eventCreated, id, jobEvent{User: m.Submitter, At: m.SubmittedAt})
+	return m, nil
+}
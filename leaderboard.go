@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	leaderboardPath     = "/leaderboard"
+	apiLeaderboardPath  = "/leaderboard"
+	leaderboardTemplate = "leaderboard.html"
+)
+
+type window struct {
+	Name   string        `json:"name"`
+	Length time.Duration `json:"-"`
+}
+
+// Zero length means all time.
+var leaderboardWindows = []window{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"all", 0},
+}
+
+type leaderRow struct {
+	Rank      int            `json:"rank"`
+	Reviewer  string         `json:"reviewer"`
+	Decisions map[string]int `json:"decisions"`
+}
+
+type leaderboardPage struct {
+	Team    string      `json:"team,omitempty"`
+	Window  string      `json:"window"`
+	Windows []window    `json:"windows"`
+	Rows    []leaderRow `json:"rows"`
+}
+
+func leaderboardWindow(name string) (window, bool) {
+	for _, w := range leaderboardWindows {
+		if w.Name == name {
+			return w, true
+		}
+	}
+	return window{}, false
+}
+
+// computeLeaderboard counts decisions per reviewer for every window and
+// ranks reviewers by the selected one. A non-empty team limits it to jobs
+// routed to that team.
+func computeLeaderboard(selected window, now time.Time, team string) leaderboardPage {
+	rows := map[string]*leaderRow{}
+
+	metadata.RLock()
+	for _, m := range metadata.entries {
+		d := m.Decision
+		if d == nil || (team != "" && m.Team != team) {
+			continue
+		}
+		row := rows[d.By]
+		if row == nil {
+			row = &leaderRow{Reviewer: d.By, Decisions: map[string]int{}}
+			rows[d.By] = row
+		}
+		for _, w := range leaderboardWindows {
+			if w.Length == 0 || now.Sub(d.At) <= w.Length {
+				row.Decisions[w.Name]++
+			}
+		}
+	}
+	metadata.RUnlock()
+
+	page := leaderboardPage{Team: team, Window: selected.Name, Windows: leaderboardWindows}
+	for _, row := range rows {
+		if row.Decisions[selected.Name] > 0 {
+			page.Rows = append(page.Rows, *row)
+		}
+	}
+	sort.Slice(page.Rows, func(i, j int) bool {
+		a, b := page.Rows[i], page.Rows[j]
+		if a.Decisions[selected.Name] != b.Decisions[selected.Name] {
+			return a.Decisions[selected.Name] > b.Decisions[selected.Name]
+		}
+		return a.Reviewer < b.Reviewer
+	})
+	for i := range page.Rows {
+		page.Rows[i].Rank = i + 1
+	}
+
+	return page
+}
+
+func leaderboardFromRequest(rw http.ResponseWriter, r *http.Request) (leaderboardPage, bool) {
+	name := r.FormValue("window")
+	if name == "" {
+		name = "7d"
+	}
+	w, ok := leaderboardWindow(name)
+	if !ok {
+		httpError(rw, r, "invalid window", http.StatusBadRequest)
+		return leaderboardPage{}, false
+	}
+	team, ok := requestTeam(rw, r)
+	if !ok {
+		return leaderboardPage{}, false
+	}
+	return computeLeaderboard(w, time.Now(), team), true
+}
+
+func leaderboardHandler(rw http.ResponseWriter, r *http.Request) {
+	if page, ok := leaderboardFromRequest(rw, r); ok {
+		renderTemplate(rw, r, leaderboardTemplate, page)
+	}
+}
+
+func apiLeaderboardHandler(rw http.ResponseWriter, r *http.Request) {
+	if page, ok := leaderboardFromRequest(rw, r); ok {
+		writeJSON(rw, http.StatusOK, page)
+	}
+}
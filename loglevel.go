@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+const logLevelPath = "/admin/log-level"
+
+// Log levels, from most to least verbose. Messages below the current level
+// are not printed.
+const (
+	levelDebug int32 = iota
+	levelInfo
+	levelWarn
+)
+
+var logLevelNames = []string{"debug", "info", "warn"}
+
+var logLevel = levelInfo
+
+type logLevelState struct {
+	Level string `json:"level"`
+}
+
+func parseLogLevel(name string) (int32, error) {
+	for i, n := range logLevelNames {
+		if n == name {
+			return int32(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level: %q", name)
+}
+
+func currentLogLevel() string {
+	return logLevelNames[atomic.LoadInt32(&logLevel)]
+}
+
+func logf(level int32, format string, args ...interface{}) {
+	if level >= atomic.LoadInt32(&logLevel) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugf logs detail only wanted while diagnosing an issue.
+func debugf(format string, args ...interface{}) {
+	logf(levelDebug, format, args...)
+}
+
+// infof logs the normal course of events.
+func infof(format string, args ...interface{}) {
+	logf(levelInfo, format, args...)
+}
+
+// warnf logs failures and anything dropped or skipped.
+func warnf(format string, args ...interface{}) {
+	logf(levelWarn, format, args...)
+}
+
+// logLevelHandler reports (GET) or changes (POST level=debug|info|warn)
+// the log level until the next restart, which goes back to log_level.
+func logLevelHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		level, err := parseLogLevel(r.FormValue("level"))
+		if err != nil {
+			httpError(rw, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		atomic.StoreInt32(&logLevel, level)
+		// Printed whatever the level, so the log shows where it changed
+		fmt.Printf("Log level set to %s by %s\n", logLevelNames[level], currentUser(r).Name)
+		if err := audit(auditEntry{Actor: currentUser(r).Name, Action: "log_level", Note: logLevelNames[level]}); err != nil {
+			warnf("Log level audit failed: %v\n", err)
+		}
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(rw, http.StatusOK, logLevelState{Level: currentLogLevel()})
+}
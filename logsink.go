@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// logSinkConfig ships audit and access events to a SIEM. Syslog takes
+// udp://host:port or tcp://host:port; HTTP receives batches of
+// newline-delimited JSON.
+type logSinkConfig struct {
+	Syslog  string            `json:"syslog"`
+	HTTP    string            `json:"http"`
+	Headers map[string]string `json:"headers"`
+	AppName string            `json:"app_name"`
+	Access  bool              `json:"access"`
+}
+
+const (
+	logSinkBuffer = 1000
+	logBatchSize  = 100
+	logFlushEvery = time.Second
+
+	// local0.info
+	syslogPriority = 16*8 + 6
+)
+
+type logEvent struct {
+	Type  string      `json:"type"`
+	Event interface{} `json:"event"`
+	at    time.Time
+}
+
+type accessEntry struct {
+	At         time.Time `json:"at"`
+	Remote     string    `json:"remote"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS int64     `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+var logEvents chan logEvent
+
+func (c logSinkConfig) enabled() bool {
+	return c.Syslog != "" || c.HTTP != ""
+}
+
+func (c logSinkConfig) validate() error {
+	if c.Syslog != "" {
+		u, err := url.Parse(c.Syslog)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return fmt.Errorf("invalid log_sink.syslog: %q", c.Syslog)
+		}
+	}
+	if c.HTTP != "" {
+		u, err := url.Parse(c.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid log_sink.http: %q", c.HTTP)
+		}
+	}
+	return nil
+}
+
+// shipLog queues an event for the sink. Events are dropped rather than
+// slowing down requests when the sink falls behind.
+func shipLog(kind string, at time.Time, event interface{}) {
+	if logEvents == nil {
+		return
+	}
+	select {
+	case logEvents <- logEvent{Type: kind, Event: event, at: at}:
+	default:
+		warnf("Log sink full, dropped %s event\n", kind)
+	}
+}
+
+func startLogSink() {
+	if !cfg.LogSink.enabled() {
+		return
+	}
+	logEvents = make(chan logEvent, logSinkBuffer)
+	go runLogSink(cfg.LogSink)
+}
+
+func runLogSink(c logSinkConfig) {
+	syslog := &syslogWriter{config: c}
+	ticker := time.NewTicker(logFlushEvery)
+	defer ticker.Stop()
+
+	var batch []logEvent
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if c.Syslog != "" {
+			if err := syslog.send(batch); err != nil {
+				warnf("Syslog failed: %v\n", err)
+			}
+		}
+		if c.HTTP != "" {
+			if err := postLogBatch(c, batch); err != nil {
+				warnf("Log shipping failed: %v\n", err)
+			}
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case e := <-logEvents:
+			batch = append(batch, e)
+			if len(batch) >= logBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func postLogBatch(c logSinkConfig, batch []logEvent) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range batch {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.HTTP, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// syslogWriter sends RFC 5424 messages, using octet counting framing over
+// TCP. The connection is reopened after a failed write.
+type syslogWriter struct {
+	config logSinkConfig
+	conn   net.Conn
+}
+
+func (w *syslogWriter) send(batch []logEvent) error {
+	if w.conn == nil {
+		u, _ := url.Parse(w.config.Syslog)
+		conn, err := net.DialTimeout(u.Scheme, u.Host, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	tcp := strings.HasPrefix(w.config.Syslog, "tcp:")
+	for _, e := range batch {
+		msg, err := formatSyslog(w.config, e)
+		if err != nil {
+			return err
+		}
+		if tcp {
+			msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := w.conn.Write(msg); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func formatSyslog(c logSinkConfig, e logEvent) ([]byte, error) {
+	data, err := json.Marshal(e.Event)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	app := c.AppName
+	if app == "" {
+		app = "jobServer"
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ", syslogPriority,
+		e.at.UTC().Format(time.RFC3339Nano), host, app, os.Getpid(), e.Type)
+	return append([]byte(header), data...), nil
+}
+
+// statusWriter remembers the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// accessLogHandler ships one access event per request when enabled. It
+// wraps the proxy handler, so the client address is resolved by the time
+// the event is written; the path is taken as requested.
+func accessLogHandler(next http.Handler) http.Handler {
+	if !cfg.LogSink.enabled() || !cfg.LogSink.Access {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requested := r.URL.Path
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		userName, _, _ := r.BasicAuth()
+		shipLog("access", start, accessEntry{
+			At:         start.UTC(),
+			Remote:     remoteHost(r.RemoteAddr),
+			User:       userName,
+			Method:     r.Method,
+			Path:       requested,
+			Status:     sw.status,
+			Bytes:      sw.bytes,
+			DurationMS: time.Since(start).Milliseconds(),
+			RequestID:  requestID(r),
+		})
+	})
+}
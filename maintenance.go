@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maintenancePath       = "/admin/maintenance"
+	defaultMaintenanceFor = 5 * time.Minute
+)
+
+// Decisions are also made through GET links, so they count as writes.
+var decisionPath = regexp.MustCompile("^(/q/[a-zA-Z0-9_-]+)?/(accept|reject|escalate)/")
+
+// maintenanceState puts the server in read-only mode: views keep working
+// while anything that changes data is turned away until it is switched
+// off again.
+type maintenanceState struct {
+	sync.RWMutex
+	On         bool       `json:"on"`
+	Since      *time.Time `json:"since,omitempty"`
+	RetryAfter int        `json:"retry_after,omitempty"`
+}
+
+var maintenance maintenanceState
+
+func (s *maintenanceState) set(on bool, retryAfter int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.On = on
+	s.Since = nil
+	s.RetryAfter = 0
+	if on {
+		now := time.Now().UTC()
+		s.Since = &now
+		s.RetryAfter = retryAfter
+	}
+}
+
+func (s *maintenanceState) get() maintenanceState {
+	s.RLock()
+	defer s.RUnlock()
+	return maintenanceState{On: s.On, Since: s.Since, RetryAfter: s.RetryAfter}
+}
+
+func inMaintenance() bool {
+	return maintenance.get().On
+}
+
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return decisionPath.MatchString(r.URL.Path)
+	}
+	return true
+}
+
+// maintenanceHandler answers writes with 503 while maintenance is on. The
+// toggle itself, the log level, shutdown and reindexing stay available.
+func maintenanceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		state := maintenance.get()
+		if state.On && isWrite(r) && r.URL.Path != maintenancePath && r.URL.Path != logLevelPath && r.URL.Path != exitPath && r.URL.Path != reindexPath {
+			rw.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+			writeError(rw, r, http.StatusServiceUnavailable, codeMaintenance, tr(r, "maintenance_banner"))
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// maintenanceToggleHandler reports (GET) or switches (POST on=1|0) read-only
+// mode. retry_after sets the seconds clients are told to wait.
+func maintenanceToggleHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		on, err := strconv.ParseBool(r.FormValue("on"))
+		if err != nil {
+			httpError(rw, r, "invalid on", http.StatusBadRequest)
+			return
+		}
+		retryAfter := int(defaultMaintenanceFor / time.Second)
+		if v := r.FormValue("retry_after"); v != "" {
+			retryAfter, err = strconv.Atoi(v)
+			if err != nil || retryAfter < 0 {
+				httpError(rw, r, "invalid retry_after", http.StatusBadRequest)
+				return
+			}
+		}
+		setMaintenance(on, retryAfter, currentUser(r).Name)
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(rw, http.StatusOK, maintenance.get())
+}
+
+func setMaintenance(on bool, retryAfter int, actor string) {
+	maintenance.set(on, retryAfter)
+	action := "maintenance_off"
+	if on {
+		action = "maintenance_on"
+	}
+	if err := audit(auditEntry{Actor: actor, Action: action}); err != nil {
+		warnf("Maintenance audit failed: %v\n", err)
+	}
+}
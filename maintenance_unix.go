@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchMaintenanceSignal toggles read-only mode on SIGUSR1, for scripts
+// that run next to the server without admin credentials.
+func watchMaintenanceSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			setMaintenance(!inMaintenance(), int(defaultMaintenanceFor/time.Second), "signal")
+		}
+	}()
+}
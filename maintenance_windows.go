@@ -0,0 +1,5 @@
+package main
+
+// watchMaintenanceSignal is a no-op: there is no SIGUSR1 on Windows, use
+// the admin endpoint instead.
+func watchMaintenanceSignal() {}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	formatAuto     = "auto"
+	formatMarkdown = "markdown"
+	formatText     = "text"
+)
+
+var (
+	mdHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRule       = regexp.MustCompile(`^\s{0,3}((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	mdBullet     = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	mdOrdered    = regexp.MustCompile(`^\s{0,3}[0-9]+[.)]\s+(.*)$`)
+	mdQuote      = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	mdFence      = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold       = regexp.MustCompile(`(\*\*|__)([^*_]+?)(\*\*|__)`)
+	mdItalic     = regexp.MustCompile(`(^|[^\w*])[*_]([^*_]+?)[*_]($|[^\w*])`)
+	mdLooksMdown = regexp.MustCompile("(?m)^(#{1,6}\\s|```|\\s{0,3}[-*+]\\s|\\s{0,3}>\\s)|\\[[^\\]]+\\]\\([^)]+\\)|\\*\\*[^*]+\\*\\*")
+)
+
+// isMarkdown guesses whether a plain text body is written in Markdown.
+func isMarkdown(body []byte) bool {
+	return mdLooksMdown.Match(body)
+}
+
+// renderMarkdown converts a subset of Markdown to HTML. All text is escaped
+// and only a fixed set of tags is ever emitted, so the output is safe to
+// embed without a separate sanitizer pass.
+func renderMarkdown(src []byte) string {
+	var out bytes.Buffer
+	lines := strings.Split(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+
+	para := []string{}
+	flushPara := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+			para = para[:0]
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flushPara()
+
+		case mdFence.MatchString(line):
+			flushPara()
+			fence := mdFence.FindStringSubmatch(line)[1]
+			code := []string{}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case mdHeading.MatchString(line):
+			flushPara()
+			m := mdHeading.FindStringSubmatch(line)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+
+		case mdRule.MatchString(line):
+			flushPara()
+			out.WriteString("<hr>\n")
+
+		case mdBullet.MatchString(line), mdOrdered.MatchString(line):
+			flushPara()
+			tag, item := "ul", mdBullet
+			if !mdBullet.MatchString(line) {
+				tag, item = "ol", mdOrdered
+			}
+			out.WriteString("<" + tag + ">\n")
+			for ; i < len(lines) && item.MatchString(lines[i]); i++ {
+				out.WriteString("<li>" + renderInline(item.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+			}
+			i--
+			out.WriteString("</" + tag + ">\n")
+
+		case mdQuote.MatchString(line):
+			flushPara()
+			quote := []string{}
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quote = append(quote, mdQuote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			out.WriteString("<blockquote>\n" + renderMarkdown([]byte(strings.Join(quote, "\n"))) + "</blockquote>\n")
+
+		default:
+			para = append(para, strings.TrimSpace(line))
+		}
+	}
+	flushPara()
+
+	return out.String()
+}
+
+// renderInline handles code spans, links and emphasis. Code spans are cut
+// out first so their contents are never interpreted.
+func renderInline(text string) string {
+	var out strings.Builder
+
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		if i%2 == 1 && i < len(parts)-1 {
+			out.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			out.WriteString("`")
+		}
+		out.WriteString(renderEmphasis(part))
+	}
+
+	return strings.ReplaceAll(out.String(), "\n", "<br>\n")
+}
+
+// renderEmphasis handles links and emphasis. Emphasis is applied to the
+// text around links and to their labels, never to their URLs.
+func renderEmphasis(text string) string {
+	escaped := html.EscapeString(text)
+
+	var out strings.Builder
+	last := 0
+	for _, m := range mdLink.FindAllStringSubmatchIndex(escaped, -1) {
+		out.WriteString(renderBoldItalic(escaped[last:m[0]]))
+		label := renderBoldItalic(escaped[m[2]:m[3]])
+		href := html.UnescapeString(escaped[m[4]:m[5]])
+		if safeURL(href) {
+			label = `<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + label + `</a>`
+		}
+		out.WriteString(label)
+		last = m[1]
+	}
+	out.WriteString(renderBoldItalic(escaped[last:]))
+
+	return out.String()
+}
+
+func renderBoldItalic(escaped string) string {
+	escaped = mdBold.ReplaceAllString(escaped, "<strong>$2</strong>")
+	return mdItalic.ReplaceAllString(escaped, "$1<em>$2</em>$3")
+}
+
+// safeURL allows http, https and mailto links and relative paths, which
+// rules out javascript: and data: URLs. Browsers skip control characters
+// and whitespace in URLs, and read a backslash as a slash, so links with
+// any of them are refused rather than guessed at.
+func safeURL(href string) bool {
+	for _, r := range href {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) || r == '\\' {
+			return false
+		}
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	case "":
+		// Not //host, nor ///host which browsers read the same
+		return href != "" && !strings.HasPrefix(href, "//")
+	}
+	return false
+}
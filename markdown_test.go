@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		href string
+		want bool
+	}{
+		{"http://example.com/a", true},
+		{"HTTPS://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"/view/12", true},
+		{"view/12?raw=1", true},
+		{"../docs", true},
+		{"#notes", true},
+		{"?page=2", true},
+		{"", false},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"\x01javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"java\nscript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"​javascript:alert(1)", false},
+		{"javascript :alert(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"vbscript:msgbox", false},
+		{"//evil.example", false},
+		{"///evil.example", false},
+		{"/\\evil.example", false},
+		{"http:evil.example", false},
+		{"http:/evil.example", false},
+		{"mailto:", false},
+		{"ftp://example.com", false},
+	}
+	for _, test := range tests {
+		if got := safeURL(test.href); got != test.want {
+			t.Errorf("safeURL(%q) = %v, want %v", test.href, got, test.want)
+		}
+	}
+}
+
+func TestRenderMarkdownLinks(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		bad  string
+	}{
+		{
+			src:  "[docs](https://example.com/docs)",
+			want: `<a href="https://example.com/docs" rel="nofollow noopener">docs</a>`,
+		},
+		{
+			src:  "[a](/view/1?x=1&y=2)",
+			want: `<a href="/view/1?x=1&amp;y=2" rel="nofollow noopener">a</a>`,
+		},
+		{
+			src:  "see [the _draft_ notes](https://example.com/a_b_c/x_y) and _this_",
+			want: `see <a href="https://example.com/a_b_c/x_y" rel="nofollow noopener">the <em>draft</em> notes</a> and <em>this</em>`,
+		},
+		{
+			src:  "[**a**](/view/1?sort=__x__)",
+			want: `<a href="/view/1?sort=__x__" rel="nofollow noopener"><strong>a</strong></a>`,
+		},
+		{src: "[click](javascript:alert(1))", bad: "<a "},
+		// Entities in the source stay text, not characters of the URL
+		{src: "[click](&#x01;javascript:alert(1))", bad: `href="&#`},
+		{src: "[click](java&#9;script:alert(1))", bad: `href="java&#`},
+		{src: "[click](\x01javascript:alert(1))", want: "click", bad: "<a "},
+		{src: "[click](//evil.example)", bad: "<a "},
+		{src: `[x](http://a"onmouseover="alert(1))`, bad: `"onmouseover`},
+	}
+	for _, test := range tests {
+		got := renderMarkdown([]byte(test.src))
+		if test.want != "" && !strings.Contains(got, test.want) {
+			t.Errorf("renderMarkdown(%q) = %q, want it to contain %q", test.src, got, test.want)
+		}
+		if test.bad != "" && strings.Contains(got, test.bad) {
+			t.Errorf("renderMarkdown(%q) = %q, must not contain %q", test.src, got, test.bad)
+		}
+	}
+}
+
+func TestRenderMarkdownEscapes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"# Title <b>", "<h1>Title &lt;b&gt;</h1>"},
+		{"**bold** and _it_", "<strong>bold</strong> and <em>it</em>"},
+		{"`<script>`", "<code>&lt;script&gt;</code>"},
+		{"<img src=x onerror=alert(1)>", "&lt;img src=x onerror=alert(1)&gt;"},
+	}
+	for _, test := range tests {
+		if got := renderMarkdown([]byte(test.src)); !strings.Contains(got, test.want) {
+			t.Errorf("renderMarkdown(%q) = %q, want it to contain %q", test.src, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metaDir    = "meta"
+	metaSuffix = ".json"
+	sniffLen   = 512
+)
+
+type jobMeta struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	// DerivedTitle is set when Title was taken from the body, not given
+	DerivedTitle bool              `json:"derived_title,omitempty"`
+	Revision     int               `json:"revision"`
+	ContentType  string            `json:"content_type"`
+	Size         int64             `json:"size"`
+	SubmittedAt  time.Time         `json:"submitted_at"`
+	Attachments  []attachment      `json:"attachments,omitempty"`
+	Revisions    []revisionInfo    `json:"revisions,omitempty"`
+	Decision     *decision         `json:"decision,omitempty"`
+	Sessions     int               `json:"sessions,omitempty"`
+	Escalation   *escalation       `json:"escalation,omitempty"`
+	Appeal       *appeal           `json:"appeal,omitempty"`
+	Rereview     *rereview         `json:"rereview,omitempty"`
+	Rereviews    []int             `json:"rereviews,omitempty"`
+	Tracked      map[string]string `json:"tracked,omitempty"`
+	Team         string            `json:"team,omitempty"`
+	Queue        string            `json:"queue,omitempty"`
+	Fields       map[string]string `json:"fields,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Submitter    string            `json:"submitter,omitempty"`
+	Source       string            `json:"source,omitempty"`
+	RelayedBy    string            `json:"relayed_by,omitempty"`
+	Score        *float64          `json:"score,omitempty"`
+	Labels       []string          `json:"labels,omitempty"`
+	SHA256       string            `json:"sha256,omitempty"`
+	DuplicateOf  int               `json:"duplicate_of,omitempty"`
+	Deferred     bool              `json:"deferred,omitempty"`
+	Scan         *scanVerdict      `json:"scan,omitempty"`
+}
+
+type decision struct {
+	State         string    `json:"state"`
+	Reason        string    `json:"reason,omitempty"`
+	By            string    `json:"by"`
+	At            time.Time `json:"at"`
+	ReviewSeconds float64   `json:"review_seconds,omitempty"`
+}
+
+type metaStore struct {
+	sync.RWMutex
+	entries map[int]*jobMeta
+	sorted  map[string]*sortIndex
+}
+
+var metadata = metaStore{entries: map[int]*jobMeta{}, sorted: newSortIndexes()}
+
+func metaFile(id int) string {
+	return path.Join(contentPath, metaDir, strconv.Itoa(id)+metaSuffix)
+}
+
+// writeFileAtomic replaces name in one step so readers never see a
+// partially written file.
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *metaStore) get(id int) (jobMeta, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	m, ok := s.entries[id]
+	if !ok {
+		return jobMeta{}, false
+	}
+	return *m, true
+}
+
+func (s *metaStore) put(m jobMeta) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	err = writeFileAtomic(metaFile(m.ID), data)
+	if err == nil {
+		s.entries[m.ID] = &m
+		s.index(&m)
+	}
+	s.Unlock()
+
+	if err == nil {
+		announceJob(m.ID)
+	}
+	return err
+}
+
+// update changes the metadata of id with change and saves it, keeping the
+// store locked from reading to saving so that concurrent changes to the
+// job are not lost. Nothing is saved when change fails. change must not
+// call into the store.
+func (s *metaStore) update(id int, change func(m *jobMeta) error) (jobMeta, error) {
+	s.Lock()
+	current, ok := s.entries[id]
+	if !ok {
+		s.Unlock()
+		return jobMeta{}, fmt.Errorf("metadata not present: %d", id)
+	}
+	m := *current
+	err := change(&m)
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(m, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(metaFile(id), data)
+	}
+	if err == nil {
+		s.entries[id] = &m
+		s.index(&m)
+	}
+	s.Unlock()
+
+	if err == nil {
+		announceJob(id)
+	}
+	return m, err
+}
+
+func (s *metaStore) remove(id int) error {
+	s.Lock()
+	err := os.Remove(metaFile(id))
+	if err == nil || os.IsNotExist(err) {
+		err = nil
+		delete(s.entries, id)
+		s.unindex(id)
+	}
+	s.Unlock()
+
+	if err == nil {
+		announceJob(id)
+	}
+	return err
+}
+
+// reload reads the metadata of id again from disk, after another replica
+// changed it.
+func (s *metaStore) reload(id int) error {
+	data, err := os.ReadFile(metaFile(id))
+	if os.IsNotExist(err) {
+		s.Lock()
+		delete(s.entries, id)
+		s.unindex(id)
+		s.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m := &jobMeta{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return err
+	}
+	s.Lock()
+	s.entries[id] = m
+	s.index(m)
+	s.Unlock()
+	return nil
+}
+
+func (s *metaStore) load() error {
+	dir := path.Join(contentPath, metaDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	entries := map[int]*jobMeta{}
+	for _, entry := range names {
+		if !strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		m, err := readMeta(path.Join(dir, entry.Name()))
+		if err != nil {
+			warnf("Metadata read failed: %s [%v]\n", entry.Name(), err)
+			continue
+		}
+		entries[m.ID] = m
+	}
+
+	// Everything is swapped in at once, so a reload doesn't show readers
+	// a half-filled store
+	s.Lock()
+	s.entries = entries
+	s.rebuild()
+	s.Unlock()
+
+	return nil
+}
+
+func readMeta(name string) (*jobMeta, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m := &jobMeta{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Revision == 0 {
+		m.Revision = 1
+	}
+	return m, nil
+}
+
+// ingest records metadata for a job file in q that has none yet.
+func ingest(q *queue, id int, state string) (jobMeta, error) {
+	m, err := inspectJob(q, id, state)
+	if err != nil {
+		return jobMeta{}, err
+	}
+	return m, metadata.put(m)
+}
+
+// inspectJob reads a job file in q for the metadata its content gives.
+func inspectJob(q *queue, id int, state string) (jobMeta, error) {
+	file, err := openBody(q.file(id, state))
+	if err != nil {
+		return jobMeta{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	// The head is read far enough to take in front matter
+	hash := sha256.New()
+	head := make([]byte, frontMatterLimit)
+	n, err := io.ReadFull(io.TeeReader(file, hash), head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return jobMeta{}, err
+	}
+	rest, err := io.Copy(hash, file)
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	sniff := head[:n]
+	if len(sniff) > sniffLen {
+		sniff = sniff[:sniffLen]
+	}
+	m := jobMeta{
+		ID:          id,
+		Queue:       q.name,
+		Revision:    1,
+		ContentType: sniffContentType(sniff),
+		Size:        int64(n) + rest,
+		SubmittedAt: info.ModTime().UTC(),
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+	m.Revisions = []revisionInfo{{Number: 1, Size: m.Size, SavedAt: m.SubmittedAt}}
+	if isTextContent(m.ContentType) {
+		if fm, _, _ := splitFrontMatter(head[:n]); fm != nil {
+			applyFrontMatter(&m, fm)
+		}
+		deriveTitle(&m, head[:n])
+	}
+	return m, nil
+}
+
+// ingestMissing records metadata for job files found on disk without any.
+// It returns those in review that are over max_job_bytes, for
+// rejectOversized once decisions can be applied.
+func ingestMissing() []int {
+	oversized := []int{}
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range q.ids(dir) {
+				if m, ok := metadata.get(id); ok {
+					if m.SHA256 == "" {
+						backfillHash(q, m, dir)
+					}
+					if m.Summary == "" && !m.DerivedTitle && isTextContent(m.ContentType) {
+						backfillTitle(q, m, dir)
+					}
+					continue
+				}
+				m, err := ingest(q, id, dir)
+				if err != nil {
+					warnf("Ingest failed: ID: %d [%v]\n", id, err)
+					continue
+				}
+				if dir == "review" && checkJobSize(m.Size) != nil {
+					oversized = append(oversized, id)
+				}
+			}
+		}
+	}
+	return oversized
+}
+
+func jobContentType(id int, body []byte) string {
+	if m, ok := metadata.get(id); ok && m.ContentType != "" {
+		return m.ContentType
+	}
+	return sniffContentType(body)
+}
+
+func recordDecision(d msg) error {
+	decided := &decision{
+		State:  d.dest,
+		Reason: d.reason,
+		By:     d.user,
+		At:     time.Now().UTC(),
+	}
+	// Time under review runs from the decider's claim to the decision
+	if c, ok := claims.release(d.id); ok && c.User == d.user {
+		decided.ReviewSeconds = decided.At.Sub(c.At).Seconds()
+	}
+	_, err := metadata.update(d.id, func(m *jobMeta) error {
+		m.Decision = decided
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	publishEvent(eventDecided, d.id, jobEvent{User: d.user, State: d.dest, Reason: d.reason, At: decided.At})
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+// requestKey labels a request by the route that served it rather than its
+// path, so job IDs do not turn into series of their own, and by status,
+// so a 404 for a mistyped ID reads differently from a 500.
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+type requestCount struct {
+	count   int64
+	seconds float64
+}
+
+var requestStats = struct {
+	sync.Mutex
+	byKey map[requestKey]*requestCount
+}{byKey: map[requestKey]*requestCount{}}
+
+// metricsMethod keeps made-up methods from adding series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+func recordRequest(key requestKey, d time.Duration) {
+	requestStats.Lock()
+	defer requestStats.Unlock()
+
+	c := requestStats.byKey[key]
+	if c == nil {
+		c = &requestCount{}
+		requestStats.byKey[key] = c
+	}
+	c.count++
+	c.seconds += d.Seconds()
+}
+
+// requestMetricsHandler counts requests per route, method and status. It
+// sits inside formatHandler, so the route is looked up on the path the
+// mux will see; requests refused by auth or access checks count against
+// the route they asked for.
+func requestMetricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := http.DefaultServeMux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		sw := &statusWriter{ResponseWriter: rw}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		recordRequest(requestKey{route: route, method: metricsMethod(r.Method), code: sw.status}, time.Since(start))
+	})
+}
+
+func writeRequestMetrics(rw http.ResponseWriter) {
+	requestStats.Lock()
+	keys := make([]requestKey, 0, len(requestStats.byKey))
+	counts := map[requestKey]requestCount{}
+	for key, c := range requestStats.byKey {
+		keys = append(keys, key)
+		counts[key] = *c
+	}
+	requestStats.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	labels := func(key requestKey) string {
+		return fmt.Sprintf("route=%s,method=%q,code=\"%d\",class=\"%dxx\"", strconv.Quote(key.route), key.method, key.code, key.code/100)
+	}
+	fmt.Fprintln(rw, "# HELP jobserver_requests_total Requests served, by route, method and status.")
+	fmt.Fprintln(rw, "# TYPE jobserver_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(rw, "jobserver_requests_total{%s} %d\n", labels(key), counts[key].count)
+	}
+	fmt.Fprintln(rw, "# HELP jobserver_request_duration_seconds Time spent serving requests, by route, method and status.")
+	fmt.Fprintln(rw, "# TYPE jobserver_request_duration_seconds summary")
+	for _, key := range keys {
+		fmt.Fprintf(rw, "jobserver_request_duration_seconds_sum{%s} %g\n", labels(key), counts[key].seconds)
+		fmt.Fprintf(rw, "jobserver_request_duration_seconds_count{%s} %d\n", labels(key), counts[key].count)
+	}
+}
+
+// metricsHandler exposes internal gauges in the Prometheus text format.
+func metricsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(rw, "# HELP jobserver_update_queue_length Decisions waiting for the update workers.")
+	fmt.Fprintln(rw, "# TYPE jobserver_update_queue_length gauge")
+	length := queuedUpdates()
+	if redisDB != nil {
+		length = redisQueueLength()
+	}
+	fmt.Fprintf(rw, "jobserver_update_queue_length %d\n", length)
+	fmt.Fprintln(rw, "# HELP jobserver_update_queue_capacity Size of the update queue, over all shards.")
+	fmt.Fprintln(rw, "# TYPE jobserver_update_queue_capacity gauge")
+	fmt.Fprintf(rw, "jobserver_update_queue_capacity %d\n", updateCapacity())
+	fmt.Fprintln(rw, "# HELP jobserver_updates_refused_total Decisions refused because the update queue was full.")
+	fmt.Fprintln(rw, "# TYPE jobserver_updates_refused_total counter")
+	fmt.Fprintf(rw, "jobserver_updates_refused_total %d\n", atomic.LoadInt64(&updatesRefused))
+	fmt.Fprintln(rw, "# HELP jobserver_leader Whether this replica runs the background workers.")
+	fmt.Fprintln(rw, "# TYPE jobserver_leader gauge")
+	leader := 0
+	if isLeader() {
+		leader = 1
+	}
+	fmt.Fprintf(rw, "jobserver_leader %d\n", leader)
+	writeRequestMetrics(rw)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+)
+
+// The migrate command converts a data directory in the flat layout, where
+// a job is nothing but its file under data/<state>, to the current one:
+// metadata under data/meta, bodies shared through data/objects and the
+// state log as the record of where each job is. Every job file is hashed
+// first; after converting, the jobs are counted and hashed again and
+// checked against their new metadata. Only when all of that matches is
+// the state log written, which is what switches the server from listing
+// the state directories to the new layout. Run it with the server stopped.
+
+// flatJob is where a job file of the flat layout was found, and the hash
+// of its content.
+type flatJob struct {
+	queue *queue
+	state string
+	hash  string
+}
+
+// hashFile returns the content hash of a stored body, reading it through.
+func hashFile(name string) (string, error) {
+	file, err := openBody(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// listFlatJobs hashes every job file of every queue. A job found in two
+// places makes the tree ambiguous and is an error.
+func listFlatJobs() (map[int]flatJob, error) {
+	jobs := map[int]flatJob{}
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range getListOfFiles(path.Join(q.root, dir)) {
+				if other, ok := jobs[id]; ok {
+					return nil, fmt.Errorf("job %d is in both %s/%s and %s/%s", id, other.queue.root, other.state, q.root, dir)
+				}
+				hash, err := hashFile(q.file(id, dir))
+				if err != nil {
+					return nil, fmt.Errorf("job %d: %v", id, err)
+				}
+				jobs[id] = flatJob{queue: q, state: dir, hash: hash}
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// convertFlatJob records metadata for a job and moves its body into the
+// object store.
+func convertFlatJob(id int, job flatJob) error {
+	m, ok := metadata.get(id)
+	switch {
+	case !ok:
+		if _, err := ingest(job.queue, id, job.state); err != nil {
+			return err
+		}
+	case m.SHA256 == "":
+		m.SHA256 = job.hash
+		if err := metadata.put(m); err != nil {
+			return err
+		}
+	}
+	return adoptBody(job.queue.file(id, job.state))
+}
+
+// verifyMigration checks the converted tree against the jobs found before:
+// the same jobs in the same places, with the same content, as their
+// metadata says.
+func verifyMigration(before map[int]flatJob) error {
+	after, err := listFlatJobs()
+	if err != nil {
+		return err
+	}
+	if len(after) != len(before) {
+		return fmt.Errorf("%d jobs before converting, %d after", len(before), len(after))
+	}
+	failed := 0
+	for id, job := range before {
+		found, ok := after[id]
+		switch {
+		case !ok:
+			warnf("Migrate check failed: ID: %d [missing]\n", id)
+		case found.queue != job.queue || found.state != job.state:
+			warnf("Migrate check failed: ID: %d [moved to %s/%s]\n", id, found.queue.root, found.state)
+		case found.hash != job.hash:
+			warnf("Migrate check failed: ID: %d [content changed]\n", id)
+		default:
+			m, ok := metadata.get(id)
+			if !ok || m.SHA256 != job.hash {
+				warnf("Migrate check failed: ID: %d [metadata does not match]\n", id)
+				break
+			}
+			continue
+		}
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d jobs failed the check", failed)
+	}
+	return nil
+}
+
+// runMigrate is the migrate command. It exits the process.
+func runMigrate(args []string) {
+	set := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := set.Bool("dry-run", false, "only hash and count the jobs, converting nothing")
+	set.Parse(args)
+
+	if _, err := os.Stat(stateLogPath()); err == nil {
+		infof("Data is in the current layout already: %s exists\n", stateLogPath())
+		os.Exit(0)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("Migrate failed: %v", err)
+	}
+
+	before, err := listFlatJobs()
+	if err != nil {
+		log.Fatalf("Migrate failed: %v", err)
+	}
+	counts := map[string]int{}
+	for _, job := range before {
+		counts[job.state]++
+	}
+	for _, dir := range dirs {
+		if counts[dir] > 0 {
+			infof("Found %d jobs in %s\n", counts[dir], dir)
+		}
+	}
+	if *dryRun {
+		infof("Dry run: %d jobs would be migrated\n", len(before))
+		os.Exit(0)
+	}
+
+	failed := []int{}
+	for id, job := range before {
+		if err := convertFlatJob(id, job); err != nil {
+			warnf("Migrate failed: ID: %d [%v]\n", id, err)
+			failed = append(failed, id)
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d jobs could not be converted", len(failed))
+	}
+	if err == nil {
+		err = verifyMigration(before)
+	}
+	if err != nil {
+		// Without a state log the server keeps reading the state
+		// directories, which the conversion left in place
+		log.Fatalf("Migrate failed, not switching: %v", err)
+	}
+
+	if _, _, err := bootstrapStateLog(); err != nil {
+		log.Fatalf("Migrate failed: state log: %v", err)
+	}
+	infof("Migrated %d jobs\n", len(before))
+	os.Exit(0)
+}
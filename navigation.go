@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	nextPath     = "/next/"
+	prevPath     = "/prev/"
+	donePath     = "/done"
+	doneTemplate = "done.html"
+)
+
+type donePage struct {
+	Queue string
+	Back  string
+}
+
+// reviewOrder lists every job in review userName may take, in order.
+// Snoozed jobs are left out; skipped ones keep their place, since
+// navigating to a job is asking for it.
+func reviewOrder(q *queue, userName string, now time.Time) []int {
+	ids := []int{}
+	held := claims.active(now)
+	userSkips := skips.of(userName, now)
+	for _, sm := range q.shards("review") {
+		sm.RLock()
+		for candidate := range sm.idMap {
+			if c, ok := held[candidate]; ok && c.User != userName {
+				continue
+			}
+			if sk, ok := userSkips[candidate]; ok && sk.snoozed(now) {
+				continue
+			}
+			if inQueue(jobTeam(candidate), userName) && !isDeferred(candidate) {
+				ids = append(ids, candidate)
+			}
+		}
+		sm.RUnlock()
+	}
+	sort.Slice(ids, func(i, j int) bool { return jobLess(ids[i], ids[j]) })
+	return ids
+}
+
+// neighbour finds the job after id, or before it when backwards, in the
+// review order. id need not be in review any more, so navigation still
+// works from a job that was just decided.
+func neighbour(q *queue, userName string, id int, backwards bool) int {
+	ids := reviewOrder(q, userName, time.Now())
+	if backwards {
+		for i := len(ids) - 1; i >= 0; i-- {
+			if jobLess(ids[i], id) {
+				return ids[i]
+			}
+		}
+		return -1
+	}
+	for _, candidate := range ids {
+		if jobLess(id, candidate) {
+			return candidate
+		}
+	}
+	return -1
+}
+
+// reviewURL leads to job id, or to the page saying the queue is done when
+// there is no job.
+func (q *queue) reviewURL(id int) string {
+	if id < 0 {
+		if q.name == "" {
+			return urlFor(donePath)
+		}
+		return urlFor(donePath, "?queue=", q.name)
+	}
+	return q.viewURL(id)
+}
+
+func nextHandler(rw http.ResponseWriter, r *http.Request) {
+	navigate(rw, r, false)
+}
+
+func prevHandler(rw http.ResponseWriter, r *http.Request) {
+	navigate(rw, r, true)
+}
+
+func navigate(rw http.ResponseWriter, r *http.Request, backwards bool) {
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Navigate failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Navigate failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+
+	q := queueOf(id)
+	http.Redirect(rw, r, q.reviewURL(neighbour(q, currentUser(r).Name, id, backwards)), http.StatusFound)
+}
+
+// doneHandler tells a reviewer there is nothing left for them to review.
+func doneHandler(rw http.ResponseWriter, r *http.Request) {
+	q, ok := lookupQueue(r.URL.Query().Get("queue"))
+	if !ok || !canAccessQueue(r, q) {
+		notFound(rw, r)
+		return
+	}
+	page := donePage{Queue: q.title, Back: urlFor(rootPath)}
+	if q.name != "" {
+		page.Back = urlFor(queuePath, q.name)
+		if page.Queue == "" {
+			page.Queue = q.name
+		}
+	}
+	renderTemplate(rw, r, doneTemplate, page)
+}
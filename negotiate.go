@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const jsonSuffix = ".json"
+
+// Pages that also come as JSON, for an Accept header asking for it or the
+// .json suffix.
+var negotiablePath = regexp.MustCompile(`^(/view/[0-9]+|/q/[a-zA-Z0-9_-]+/view/[0-9]+|/escalations|/appeals|/my)\.json$`)
+
+// formatHandler turns the .json suffix into an Accept header, so the
+// routes and their access checks only ever see the plain path.
+func formatHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if negotiablePath.MatchString(r.URL.Path) {
+			r.URL.Path = strings.TrimSuffix(r.URL.Path, jsonSuffix)
+			r.URL.RawPath = ""
+			r.Header.Set("Accept", "application/json")
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// jobView is the JSON form of the review page.
+type jobView struct {
+	Job       jobMeta `json:"job"`
+	State     string  `json:"state"`
+	Body      string  `json:"body,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"`
+	HTML      string  `json:"html,omitempty"`
+	Redacted  int     `json:"redacted,omitempty"`
+	Claim     *claim  `json:"claim,omitempty"`
+	ClaimHeld bool    `json:"claim_held"`
+	Draft     *draft  `json:"draft,omitempty"`
+	RawURL    string  `json:"raw_url"`
+	ETag      string  `json:"etag"`
+}
+
+func newJobView(id int, state string, p *Page) jobView {
+	m, _ := metadata.get(id)
+	v := jobView{
+		Job:       m,
+		State:     state,
+		HTML:      p.HTML,
+		Redacted:  p.Redacted,
+		Claim:     p.Claim,
+		ClaimHeld: p.ClaimHeld,
+		Draft:     p.Draft,
+		RawURL:    urlFor(rawPath, p.ID),
+		ETag:      p.ETag,
+	}
+	// Binary bodies are only linked
+	if p.Format != formatBinary && p.Format != formatPDF && p.Format != formatImage {
+		v.Body = string(p.Head) + string(p.Body)
+		v.Truncated = p.Truncated
+	}
+	return v
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+)
+
+const (
+	objectsDir     = "objects"
+	apiObjectsPath = "/objects/"
+)
+
+var validObjectPath = regexp.MustCompile("^/api/v[0-9]+/objects/([0-9a-f]{64})$")
+
+// Job bodies are stored once per content hash under data/objects and hard
+// linked into the state and revision directories, so identical
+// submissions share storage. The link count is the reference count: an
+// object only linked from data/objects is unused.
+
+func objectFile(hash string) string {
+	return path.Join(contentPath, objectsDir, hash[:2], hash)
+}
+
+// storeBody places body at file, sharing storage with any identical body
+// already stored. It falls back to a plain copy where hard links are not
+// supported. An object stored before encryption was switched on or off is
+// replaced; files still linked to it keep the old copy until re-stored.
+func storeBody(file string, body []byte) (string, error) {
+	hash := contentHash(body)
+	object := objectFile(hash)
+
+	data, err := encodeBody(body)
+	if err != nil {
+		return "", err
+	}
+
+	if sealed, err := fileSealed(object); err != nil || sealed != (bodyKey != nil) {
+		if err := os.MkdirAll(path.Dir(object), 0755); err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(object, data); err != nil {
+			return "", err
+		}
+	}
+
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(object, tmp); err != nil {
+		return hash, writeFileAtomic(file, data)
+	}
+	return hash, os.Rename(tmp, file)
+}
+
+// adoptBody moves an existing file that predates object storage into it,
+// sealing it on the way when encryption is enabled.
+func adoptBody(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if bodyKey != nil {
+		if sealed, err := fileSealed(file); err != nil || sealed {
+			return err
+		}
+	} else if refs, ok := linkCount(info); !ok || refs > 1 {
+		return nil
+	}
+	body, err := readBody(file)
+	if err != nil {
+		return err
+	}
+	_, err = storeBody(file, body)
+	return err
+}
+
+// collectObjects removes objects nothing links to any more.
+func collectObjects() (int, error) {
+	root := path.Join(contentPath, objectsDir)
+	prefixes, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, prefix := range prefixes {
+		entries, err := os.ReadDir(path.Join(root, prefix.Name()))
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if refs, ok := linkCount(info); ok && refs == 1 {
+				if err := os.Remove(path.Join(root, prefix.Name(), entry.Name())); err == nil {
+					removed++
+				}
+			}
+		}
+	}
+	return removed, nil
+}
+
+func initObjects() {
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range q.ids(dir) {
+				if err := adoptBody(q.file(id, dir)); err != nil {
+					warnf("Object adopt failed: ID: %d [%v]\n", id, err)
+				}
+			}
+		}
+	}
+	if removed, err := collectObjects(); err != nil {
+		warnf("Object collection failed: %v\n", err)
+	} else if removed > 0 {
+		infof("Removed %d unused objects\n", removed)
+	}
+}
+
+type objectInfo struct {
+	SHA256 string `json:"sha256"`
+	Jobs   []int  `json:"jobs"`
+}
+
+// apiObjectHandler answers whether content with a hash was submitted
+// before, and as which jobs.
+func apiObjectHandler(rw http.ResponseWriter, r *http.Request) {
+	m := validObjectPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	info := objectInfo{SHA256: m[1], Jobs: []int{}}
+	metadata.RLock()
+	for _, job := range metadata.entries {
+		if job.SHA256 == m[1] && canAccessJob(r, job.ID) {
+			info.Jobs = append(info.Jobs, job.ID)
+		}
+	}
+	metadata.RUnlock()
+
+	if len(info.Jobs) == 0 {
+		notFound(rw, r)
+		return
+	}
+	sort.Ints(info.Jobs)
+	writeJSON(rw, http.StatusOK, info)
+}
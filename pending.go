@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const pendingDir = "pending"
+
+// Every decision is written to data/pending before it is handed to the
+// update worker and removed once applied, so decisions survive a crash or
+// restart. Replayed decisions may already have been applied; moveJob then
+// finds the job gone from its source state and the record is dropped.
+
+type pendingUpdate struct {
+	ID       int       `json:"id"`
+	Dest     string    `json:"dest"`
+	Reason   string    `json:"reason,omitempty"`
+	User     string    `json:"user"`
+	Checked  bool      `json:"checked,omitempty"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+var pendingSeq uint64
+
+func pendingFile(seq uint64) string {
+	return path.Join(contentPath, pendingDir, fmt.Sprintf("%020d%s", seq, metaSuffix))
+}
+
+// persistUpdate records m on disk and numbers it.
+func persistUpdate(m *msg) error {
+	m.seq = atomic.AddUint64(&pendingSeq, 1)
+	data, err := json.Marshal(pendingUpdate{
+		ID:       m.id,
+		Dest:     m.dest,
+		Reason:   m.reason,
+		User:     m.user,
+		Checked:  m.checked,
+		QueuedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(pendingFile(m.seq), data)
+}
+
+func completeUpdate(m msg) {
+	if m.raw != "" {
+		completeRedisUpdate(m)
+		return
+	}
+	if err := os.Remove(pendingFile(m.seq)); err != nil && !os.IsNotExist(err) {
+		warnf("Pending cleanup failed: ID: %d [%v]\n", m.id, err)
+	}
+}
+
+// queueUpdate persists m and waits for room in the queue. It is meant for
+// follow-up decisions taken by the server itself. Once the queues are
+// closed for shutdown, m is only persisted.
+func queueUpdate(m msg) {
+	if redisDB != nil {
+		pushRedisUpdate(m)
+		return
+	}
+	if err := persistUpdate(&m); err != nil {
+		warnf("Pending write failed: ID: %d [%v]\n", m.id, err)
+	}
+
+	updateQueues.RLock()
+	defer updateQueues.RUnlock()
+	if updateQueues.closed {
+		infof("Shutdown: decision on %d left pending for the next start\n", m.id)
+		return
+	}
+	updateChanFor(m.id) <- m
+}
+
+// erasePending removes the decisions on the jobs in ids still waiting in
+// data/pending, as their reasons may name the submitter. The update worker
+// finds the jobs gone if it gets to them.
+func erasePending(ids map[int]bool) error {
+	dir := path.Join(contentPath, pendingDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var p pendingUpdate
+		if err := json.Unmarshal(data, &p); err != nil || !ids[p.ID] {
+			continue
+		}
+		if err := os.Remove(path.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayPending queues the decisions left over from the last run, oldest
+// first. The update workers must already be running.
+func replayPending() error {
+	dir := path.Join(contentPath, pendingDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), metaSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, metaSuffix), 10, 64)
+		if err != nil {
+			warnf("Pending skipped: %s [%v]\n", name, err)
+			continue
+		}
+		if seq > pendingSeq {
+			pendingSeq = seq
+		}
+
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		var p pendingUpdate
+		if err := json.Unmarshal(data, &p); err != nil {
+			warnf("Pending skipped: %s [%v]\n", name, err)
+			continue
+		}
+		updateChanFor(p.ID) <- msg{id: p.ID, dest: p.Dest, reason: p.Reason, user: p.User, checked: p.Checked, seq: seq}
+	}
+	if len(names) > 0 {
+		infof("Replayed %d pending decisions\n", len(names))
+	}
+	if redisDB != nil {
+		go feedUpdates()
+	}
+	return nil
+}
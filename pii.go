@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+type piiConfig struct {
+	Enabled  bool              `json:"enabled"`
+	Patterns map[string]string `json:"patterns"`
+}
+
+type piiDetector struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Built-in detectors. National IDs are matched in the US SSN format;
+// other formats can be added through pii.patterns.
+var builtinPII = []piiDetector{
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"national_id", regexp.MustCompile(`\b[0-9]{3}-[0-9]{2}-[0-9]{4}\b`)},
+	{"phone", regexp.MustCompile(`(?:\+[0-9]{1,3}|\(0[0-9]{1,4}\)|\b0[0-9]{1,4})[ ./-]?[0-9]{2,}(?:[ ./-]?[0-9]{2,}){1,4}|\b[0-9]{3}[ .-][0-9]{3}[ .-][0-9]{4}\b`)},
+}
+
+var piiDetectors []piiDetector
+
+func compilePII(c piiConfig) ([]piiDetector, error) {
+	detectors := append([]piiDetector{}, builtinPII...)
+
+	names := make([]string, 0, len(c.Patterns))
+	for name := range c.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		re, err := regexp.Compile(c.Patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("pii pattern %s: %v", name, err)
+		}
+		detectors = append(detectors, piiDetector{name, re})
+	}
+	return detectors, nil
+}
+
+// redact masks every match of the configured detectors and reports how
+// many were found.
+func redact(body []byte) ([]byte, int) {
+	count := 0
+	for _, d := range piiDetectors {
+		mask := []byte("[" + d.name + "]")
+		body = d.re.ReplaceAllFunc(body, func([]byte) []byte {
+			count++
+			return mask
+		})
+	}
+	return body, count
+}
+
+func canUnmask(r *http.Request) bool {
+	return isAdmin(r) || currentUser(r).Unmask
+}
+
+func unmaskRequested(r *http.Request) bool {
+	return r.FormValue("unmask") == "1" && canUnmask(r)
+}
+
+// displayBody is the version of a text body id shown to the requesting
+// user. Unmasked views are written to the audit log.
+func displayBody(r *http.Request, id int, body []byte) ([]byte, int) {
+	if !cfg.PII.Enabled || !isTextContent(sniffContentType(body)) {
+		return body, 0
+	}
+	if unmaskRequested(r) {
+		if err := audit(auditEntry{
+			Actor:  currentUser(r).Name,
+			Action: "unmask",
+			ID:     id,
+			Note:   r.URL.Path,
+		}); err != nil {
+			warnf("Audit failed: ID: %d [%v]\n", id, err)
+		}
+		return body, 0
+	}
+	return redact(body)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// policyUser is recorded as the decider for jobs a queue policy rejects.
+const policyUser = "policy"
+
+const defaultAutoRejectReason = "Not decided within {{.After}}."
+
+// autoRejectPolicy rejects jobs still waiting for review after a while,
+// checked on a schedule (daily at midnight by default). Reason is a
+// template rendered per job with autoRejectNotice; the submitter hears of
+// the rejection like of any other through their notification preferences.
+type autoRejectPolicy struct {
+	After    duration `json:"after"`
+	Reason   string   `json:"reason"`
+	Schedule schedule `json:"schedule"`
+
+	reason *template.Template
+}
+
+// autoRejectNotice is what the reason template of a policy can refer to.
+type autoRejectNotice struct {
+	ID          int
+	Title       string
+	Queue       string
+	SubmittedAt time.Time
+	After       string
+	Days        int
+}
+
+func (p *autoRejectPolicy) compile(queueName string) error {
+	name := "auto_reject"
+	if queueName != "" {
+		name = "queue " + queueName + ": auto_reject"
+	}
+	if p.After.Duration <= 0 {
+		return errors.New(name + ".after must be positive")
+	}
+	if p.Schedule.Every == "" {
+		p.Schedule.Every = scheduleDaily
+	}
+	if err := p.Schedule.validate(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if p.Reason == "" {
+		p.Reason = defaultAutoRejectReason
+	}
+	var err error
+	if p.reason, err = template.New(name).Parse(p.Reason); err != nil {
+		return fmt.Errorf("%s.reason: %v", name, err)
+	}
+	return nil
+}
+
+// inDays spells out whole days as such, so "336h" reads as "14 days".
+func inDays(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 day"
+	case d%day == 0:
+		return strconv.Itoa(int(d/day)) + " days"
+	}
+	return d.String()
+}
+
+func (p *autoRejectPolicy) reasonFor(q *queue, m jobMeta) (string, error) {
+	notice := autoRejectNotice{
+		ID:          m.ID,
+		Title:       m.Title,
+		Queue:       q.name,
+		SubmittedAt: m.SubmittedAt,
+		After:       inDays(p.After.Duration),
+		Days:        int(p.After.Duration / (24 * time.Hour)),
+	}
+	var buf bytes.Buffer
+	if err := p.reason.Execute(&buf, notice); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// enforce rejects the jobs of q in review that were submitted more than
+// After before now.
+func (p *autoRejectPolicy) enforce(q *queue, now time.Time) {
+	rejected := 0
+	for _, id := range q.ids("review") {
+		m, ok := metadata.get(id)
+		if !ok || now.Sub(m.SubmittedAt) < p.After.Duration {
+			continue
+		}
+		reason, err := p.reasonFor(q, m)
+		if err != nil {
+			warnf("Auto-reject failed: ID: %d [%v]\n", id, err)
+			continue
+		}
+		if err := audit(auditEntry{Actor: policyUser, Action: "auto_reject", ID: id, To: "reject", Note: inDays(p.After.Duration)}); err != nil {
+			warnf("Audit failed: ID: %d [%v]\n", id, err)
+		}
+		queueUpdate(msg{id: id, dest: "reject", reason: reason, user: policyUser})
+		rejected++
+	}
+	if rejected > 0 {
+		infof("Auto-rejected %d jobs: queue %q\n", rejected, q.name)
+	}
+}
+
+func startPolicies() {
+	for _, q := range queues {
+		p := q.autoReject
+		if p == nil {
+			continue
+		}
+		name := "auto-reject"
+		if q.name != "" {
+			name += " " + q.name
+		}
+		go runScheduled(name, p.Schedule, func(q *queue) func(time.Time) {
+			return func(now time.Time) {
+				p.enforce(q, now)
+			}
+		}(q))
+	}
+}
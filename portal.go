@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	portalPath     = "/my"
+	portalTemplate = "portal.html"
+)
+
+// Submitters see their own jobs and nothing else: how far each got and,
+// once decided, the outcome and reason. Escalation is internal, so
+// escalated and deferred jobs just show as pending.
+const (
+	statusPending  = "pending"
+	statusAccepted = "accepted"
+	statusRejected = "rejected"
+	statusAppealed = "appealed"
+)
+
+type submittedJob struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	Status      string     `json:"status"`
+	Reason      string     `json:"reason,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	CanAppeal   bool       `json:"can_appeal,omitempty"`
+}
+
+type portalPage struct {
+	Submitter string         `json:"submitter"`
+	Jobs      []submittedJob `json:"jobs"`
+}
+
+// submitterPath lists what accounts with the submitter role may reach:
+// the portal, job submission, appeals and their notification preferences.
+// Whether a job is theirs is up to the handler.
+func submitterPath(r *http.Request) bool {
+	switch {
+	case r.URL.Path == portalPath:
+		return true
+	case strings.HasPrefix(r.URL.Path, appealPath):
+		return r.Method == http.MethodPost
+	case strings.HasPrefix(r.URL.Path, apiPrefix):
+		if validAPISubscriptionsPath.MatchString(r.URL.Path) {
+			return true
+		}
+		if m := validAPIJobPath.FindStringSubmatch(r.URL.Path); m != nil {
+			return r.Method == http.MethodPost && m[2] == "/appeal" && m[3] == ""
+		}
+		return r.Method == http.MethodPost && validAPIJobsPath.MatchString(r.URL.Path)
+	}
+	return false
+}
+
+// jobStatus tells a submitter where their job stands, with the reason and
+// time of the decision once there is one.
+func jobStatus(m jobMeta) (string, string, *time.Time) {
+	status := statusPending
+	switch findState(m.ID) {
+	case "accept":
+		status = statusAccepted
+	case "reject":
+		status = statusRejected
+	case "appeal":
+		return statusAppealed, "", nil
+	default:
+		return status, "", nil
+	}
+	if d := m.Decision; d != nil {
+		return status, d.Reason, &d.At
+	}
+	return status, "", nil
+}
+
+func portalHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := portalPage{Submitter: currentUser(r).Name, Jobs: []submittedJob{}}
+	metadata.RLock()
+	mine := []jobMeta{}
+	for _, m := range metadata.entries {
+		if m.Submitter == page.Submitter {
+			mine = append(mine, *m)
+		}
+	}
+	metadata.RUnlock()
+
+	for _, m := range mine {
+		job := submittedJob{ID: m.ID, Title: m.Title, SubmittedAt: m.SubmittedAt}
+		job.Status, job.Reason, job.DecidedAt = jobStatus(m)
+		job.CanAppeal = job.Status == statusRejected && m.Appeal == nil
+		page.Jobs = append(page.Jobs, job)
+	}
+	sort.Slice(page.Jobs, func(i, j int) bool {
+		return page.Jobs[i].ID > page.Jobs[j].ID
+	})
+
+	rw.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		writeJSON(rw, http.StatusOK, page)
+		return
+	}
+	renderTemplate(rw, r, portalTemplate, page)
+}
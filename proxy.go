@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var trustedProxies []*net.IPNet
+
+func parseProxies(entries []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// clientIP walks X-Forwarded-For from the right, skipping trusted proxies,
+// so a client can't spoof its address by prepending entries.
+func clientIP(r *http.Request) string {
+	remote := remoteHost(r.RemoteAddr)
+	if !isTrustedProxy(net.ParseIP(remote)) {
+		return remote
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(net.ParseIP(hop)) {
+			return hop
+		}
+		remote = hop
+	}
+
+	return remote
+}
+
+func requestScheme(r *http.Request) string {
+	if isTrustedProxy(net.ParseIP(remoteHost(r.RemoteAddr))) {
+		proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0])
+		if proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// urlFor prefixes an application path with the configured mount point.
+func urlFor(parts ...string) string {
+	return cfg.PathPrefix + strings.Join(parts, "")
+}
+
+// proxyHandler rewrites the request as seen by the proxy's client and
+// removes the mount prefix. Proxies that already strip the prefix are
+// handled too.
+func proxyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = net.JoinHostPort(clientIP(r), "0")
+		r.URL.Scheme = requestScheme(r)
+
+		prefix := cfg.PathPrefix
+		if prefix != "" {
+			if r.URL.Path == prefix {
+				http.Redirect(rw, r, prefix+"/", http.StatusMovedPermanently)
+				return
+			}
+			if strings.HasPrefix(r.URL.Path, prefix+"/") {
+				r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+				r.URL.RawPath = ""
+			}
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	queuePath = "/q/"
+	queuesDir = "queues"
+)
+
+var validQueueName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+var validQueuePath = regexp.MustCompile("^/q/([a-zA-Z0-9_-]+)(/(accept|reject|escalate|view|raw|edit|revisions|diff|draft|next|prev|skip)/([0-9]+))?/?$")
+
+type queueConfig struct {
+	Name       string            `json:"name"`
+	Title      string            `json:"title"`
+	Template   string            `json:"template"`
+	Fields     []fieldDef        `json:"fields"`
+	AutoReject *autoRejectPolicy `json:"auto_reject"`
+	Reminders  *reminderPolicy   `json:"reminders"`
+	Jira       *jiraMapping      `json:"jira"`
+	Schema     *submissionSchema `json:"schema"`
+}
+
+// queue is one review pipeline with its own state directories. Job IDs are
+// shared across queues, so metadata, attachments and revisions stay keyed
+// by ID alone. The default queue has no name and lives directly in data/.
+//
+// The in-memory state of each directory is split into cfg.Shards maps, so
+// jobs in different shards never contend for the same lock.
+type queue struct {
+	name       string
+	title      string
+	template   string
+	fields     []fieldDef
+	autoReject *autoRejectPolicy
+	reminders  *reminderPolicy
+	jira       *jiraMapping
+	schema     *submissionSchema
+	root       string
+	layout     [][]syncMap
+}
+
+const maxShards = 256
+
+// nextShard rotates where listings start, so early exits don't favour
+// the first shard.
+var nextShard uint32
+
+// shardOf spreads IDs over the shards. IDs are sequential, so the
+// remainder is as even as any hash.
+func shardOf(id int) int {
+	return id % cfg.Shards
+}
+
+var queues []*queue
+
+func (c queueConfig) validate() error {
+	if !validQueueName.MatchString(c.Name) {
+		return fmt.Errorf("invalid queue name: %q", c.Name)
+	}
+	if c.Name == defaultQueueName {
+		return fmt.Errorf("queue name %q is reserved for the default queue", c.Name)
+	}
+	if err := validateFields(c.Fields); err != nil {
+		return fmt.Errorf("queue %s: %v", c.Name, err)
+	}
+	if c.AutoReject != nil {
+		if err := c.AutoReject.compile(c.Name); err != nil {
+			return err
+		}
+	}
+	if c.Reminders != nil {
+		if err := c.Reminders.validate(c.Name); err != nil {
+			return err
+		}
+	}
+	if c.Jira != nil {
+		if err := c.Jira.compile("queue " + c.Name + ": jira"); err != nil {
+			return err
+		}
+	}
+	if c.Schema != nil {
+		return c.Schema.compile("queue " + c.Name)
+	}
+	return nil
+}
+
+func newQueue(c queueConfig) *queue {
+	q := &queue{name: c.Name, title: c.Title, template: c.Template, fields: c.Fields, root: contentPath, autoReject: c.AutoReject, reminders: c.Reminders, jira: c.Jira, schema: c.Schema}
+	if c.Name != "" {
+		q.root = path.Join(contentPath, queuesDir, c.Name)
+	}
+	return q
+}
+
+func defaultQueue() *queue {
+	return queues[0]
+}
+
+func lookupQueue(name string) (*queue, bool) {
+	for _, q := range queues {
+		if q.name == name {
+			return q, true
+		}
+	}
+	return nil, false
+}
+
+// queueOf returns the queue a job was submitted to.
+func queueOf(id int) *queue {
+	if m, ok := metadata.get(id); ok && m.Queue != "" {
+		if q, ok := lookupQueue(m.Queue); ok {
+			return q
+		}
+	}
+	return defaultQueue()
+}
+
+// jobFile is where the body of a job in state is stored.
+func jobFile(id int, state string) string {
+	return queueOf(id).file(id, state)
+}
+
+func (q *queue) file(id int, state string) string {
+	return path.Join(q.root, state, strconv.Itoa(id))
+}
+
+// states returns the map of the shard holding id in state.
+func (q *queue) states(state string, id int) *syncMap {
+	return &q.layout[getIndex(state)][shardOf(id)]
+}
+
+// shards returns every shard of state, starting at a different one on each
+// call.
+func (q *queue) shards(state string) []*syncMap {
+	layout := q.layout[getIndex(state)]
+	start := int(atomic.AddUint32(&nextShard, 1)) % len(layout)
+	all := make([]*syncMap, 0, len(layout))
+	for i := range layout {
+		all = append(all, &layout[(start+i)%len(layout)])
+	}
+	return all
+}
+
+func (q *queue) has(id int, state string) bool {
+	sm := q.states(state, id)
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.idMap[id]
+}
+
+func (q *queue) ids(state string) []int {
+	ids := []int{}
+	for _, sm := range q.shards(state) {
+		sm.RLock()
+		for id := range sm.idMap {
+			ids = append(ids, id)
+		}
+		sm.RUnlock()
+	}
+	return ids
+}
+
+// load creates the state directories of q with empty state maps, which
+// loadStates fills from the state log.
+func (q *queue) load() {
+	q.layout = [][]syncMap{}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(path.Join(q.root, dir), 0755); err != nil {
+			warnf("Error to create %s: %v\n", dir, err)
+		}
+		shards := make([]syncMap, cfg.Shards)
+		for i := range shards {
+			shards[i].idMap = make(map[int]bool)
+		}
+		q.layout = append(q.layout, shards)
+	}
+}
+
+// viewURL links to a job, using the queue's own routes for named queues.
+func (q *queue) viewURL(id int) string {
+	if q.name == "" {
+		return urlFor(viewPath, strconv.Itoa(id))
+	}
+	return urlFor(queuePath, q.name, viewPath, strconv.Itoa(id))
+}
+
+func (q *queue) viewTemplate() string {
+	if q.template != "" {
+		return q.template
+	}
+	return viewTemplate
+}
+
+func initQueues() {
+	queues = []*queue{newQueue(queueConfig{AutoReject: cfg.AutoReject, Reminders: cfg.Reminders})}
+	for _, c := range cfg.Queues {
+		queues = append(queues, newQueue(c))
+	}
+	for _, q := range queues {
+		q.load()
+	}
+}
+
+// queueHandler serves /q/<queue>/<action>/<id> by handing the request to
+// the regular route once the job is confirmed to belong to the queue.
+func queueHandler(rw http.ResponseWriter, r *http.Request) {
+	m := validQueuePath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		notFound(rw, r)
+		return
+	}
+	q, ok := lookupQueue(m[1])
+	if !ok || q.name == "" {
+		notFound(rw, r)
+		return
+	}
+
+	if m[2] == "" {
+		http.Redirect(rw, r, q.reviewURL(getRandomId(q, currentUser(r).Name, -1)), http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(m[4])
+	if err != nil || queueOf(id) != q {
+		notFound(rw, r)
+		return
+	}
+
+	r.URL.Path = m[2]
+	r.URL.RawPath = ""
+	http.DefaultServeMux.ServeHTTP(rw, r)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+)
+
+const (
+	apiQueuesPath = "/queues/"
+
+	// defaultQueueName stands for the unnamed default queue in API paths.
+	defaultQueueName = "default"
+)
+
+var validAPIQueueCountPath = regexp.MustCompile("^/api/v[0-9]+/queues/([a-zA-Z0-9_-]+)/count$")
+
+type queueCount struct {
+	Queue  string         `json:"queue"`
+	Counts map[string]int `json:"counts"`
+	Total  int            `json:"total"`
+}
+
+// count returns how many jobs are in state, without listing them.
+func (q *queue) count(state string) int {
+	n := 0
+	for _, sm := range q.shards(state) {
+		sm.RLock()
+		n += len(sm.idMap)
+		sm.RUnlock()
+	}
+	return n
+}
+
+// apiQueueCountHandler answers /queues/<name>/count with the number of
+// jobs in each state of the queue, for dashboards and for workers that
+// scale with the backlog.
+func apiQueueCountHandler(rw http.ResponseWriter, r *http.Request) {
+	match := validAPIQueueCountPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		notFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := match[1]
+	if name == defaultQueueName {
+		name = ""
+	}
+	q, ok := lookupQueue(name)
+	if !ok || !canAccessQueue(r, q) {
+		writeError(rw, r, http.StatusNotFound, codeUnknownQueue, "unknown queue")
+		return
+	}
+
+	c := queueCount{Queue: match[1], Counts: map[string]int{}}
+	for _, state := range dirs {
+		n := q.count(state)
+		c.Counts[state] = n
+		c.Total += n
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, http.StatusOK, c)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	quotaReject = "reject"
+	quotaDefer  = "defer"
+)
+
+// quotaConfig caps how many jobs one submitter can have waiting in review,
+// so a single producer can't flood the queue. Over the cap, submissions
+// are either rejected or deferred: stored, but kept from reviewers until
+// enough of the submitter's jobs have been decided. Submitters overrides
+// the cap per submitter; 0 means no cap.
+type quotaConfig struct {
+	MaxPending int            `json:"max_pending"`
+	Action     string         `json:"action"`
+	Submitters map[string]int `json:"submitters"`
+}
+
+// quotaLock makes counting and creating one step, so concurrent
+// submissions can't overshoot the cap together.
+var quotaLock sync.Mutex
+
+func (c *quotaConfig) validate() error {
+	switch c.Action {
+	case "":
+		c.Action = quotaReject
+	case quotaReject, quotaDefer:
+	default:
+		return fmt.Errorf("unknown quota.action: %q", c.Action)
+	}
+	if c.MaxPending < 0 {
+		return errors.New("quota.max_pending must not be negative")
+	}
+	for name, limit := range c.Submitters {
+		if limit < 0 {
+			return fmt.Errorf("quota.submitters: %s: limit must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func (c quotaConfig) limit(submitter string) int {
+	if limit, ok := c.Submitters[submitter]; ok {
+		return limit
+	}
+	return c.MaxPending
+}
+
+// pendingJobs lists the submitter's jobs in review, in ID order, split
+// into those reviewers see and those deferred.
+func pendingJobs(submitter string) ([]int, []int) {
+	ids := []int{}
+	metadata.RLock()
+	for id, m := range metadata.entries {
+		if m.Submitter == submitter {
+			ids = append(ids, id)
+		}
+	}
+	metadata.RUnlock()
+	sort.Ints(ids)
+
+	active, deferred := []int{}, []int{}
+	for _, id := range ids {
+		if findState(id) != "review" {
+			continue
+		}
+		if isDeferred(id) {
+			deferred = append(deferred, id)
+		} else {
+			active = append(active, id)
+		}
+	}
+	return active, deferred
+}
+
+func isDeferred(id int) bool {
+	m, ok := metadata.get(id)
+	return ok && m.Deferred
+}
+
+// checkQuota tells whether a new job by submitter fits under the cap and
+// how many are pending. It must be called with quotaLock held.
+func checkQuota(submitter string) (bool, int, int) {
+	limit := cfg.Quota.limit(submitter)
+	if limit == 0 || submitter == "" {
+		return true, 0, limit
+	}
+	active, deferred := pendingJobs(submitter)
+	return len(active) < limit && len(deferred) == 0, len(active) + len(deferred), limit
+}
+
+// releaseDeferred hands deferred jobs of submitter to reviewers, oldest
+// first, as far as the cap allows.
+func releaseDeferred(submitter string) {
+	if submitter == "" {
+		return
+	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+
+	active, deferred := pendingJobs(submitter)
+	limit := cfg.Quota.limit(submitter)
+	for _, id := range deferred {
+		if limit != 0 && len(active) >= limit {
+			return
+		}
+		if _, ok := metadata.get(id); !ok {
+			continue
+		}
+		_, err := metadata.update(id, func(m *jobMeta) error {
+			m.Deferred = false
+			return nil
+		})
+		if err != nil {
+			warnf("Deferred release failed: ID: %d [%v]\n", id, err)
+			return
+		}
+		active = append(active, id)
+		infof("Deferred released: ID: %d\n", id)
+	}
+}
+
+// releaseAllDeferred applies the current caps to jobs deferred under
+// earlier ones.
+func releaseAllDeferred() {
+	submitters := map[string]bool{}
+	metadata.RLock()
+	for _, m := range metadata.entries {
+		if m.Deferred {
+			submitters[m.Submitter] = true
+		}
+	}
+	metadata.RUnlock()
+	for submitter := range submitters {
+		releaseDeferred(submitter)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	reasonsFile     = "reasons.json"
+	reasonsPath     = "/admin/reasons"
+	reasonsTemplate = "reasons.html"
+)
+
+type cannedReason struct {
+	ID    int    `json:"id"`
+	Label string `json:"label"`
+	Text  string `json:"text"`
+}
+
+// reasonStore holds the admin-managed rejection reasons offered to
+// reviewers.
+type reasonStore struct {
+	sync.RWMutex
+	reasons []cannedReason
+	last    int
+}
+
+var reasons = reasonStore{}
+
+func (s *reasonStore) load() error {
+	data, err := os.ReadFile(path.Join(contentPath, reasonsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := json.Unmarshal(data, &s.reasons); err != nil {
+		return fmt.Errorf("parse %s: %v", reasonsFile, err)
+	}
+	for _, reason := range s.reasons {
+		if reason.ID > s.last {
+			s.last = reason.ID
+		}
+	}
+	return nil
+}
+
+// persist must be called with the lock held.
+func (s *reasonStore) persist() error {
+	data, err := json.MarshalIndent(s.reasons, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(contentPath, reasonsFile), data)
+}
+
+func (s *reasonStore) list() []cannedReason {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]cannedReason{}, s.reasons...)
+}
+
+func (s *reasonStore) get(id int) (cannedReason, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, reason := range s.reasons {
+		if reason.ID == id {
+			return reason, true
+		}
+	}
+	return cannedReason{}, false
+}
+
+func (s *reasonStore) save(reason cannedReason) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if reason.ID == 0 {
+		s.last++
+		reason.ID = s.last
+		s.reasons = append(s.reasons, reason)
+		return s.persist()
+	}
+	for i := range s.reasons {
+		if s.reasons[i].ID == reason.ID {
+			s.reasons[i] = reason
+			return s.persist()
+		}
+	}
+	return fmt.Errorf("reason not present: %d", reason.ID)
+}
+
+func (s *reasonStore) remove(id int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.reasons {
+		if s.reasons[i].ID == id {
+			s.reasons = append(s.reasons[:i], s.reasons[i+1:]...)
+			return s.persist()
+		}
+	}
+	return fmt.Errorf("reason not present: %d", id)
+}
+
+// decisionReason prefers the reviewer's own text and falls back to the
+// selected canned reason.
+func decisionReason(r *http.Request) string {
+	if reason := strings.TrimSpace(r.FormValue("reason")); reason != "" {
+		return reason
+	}
+	id, err := strconv.Atoi(r.FormValue("reason_template"))
+	if err != nil {
+		return ""
+	}
+	if reason, ok := reasons.get(id); ok {
+		return reason.Text
+	}
+	return ""
+}
+
+func reasonsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderTemplate(rw, r, reasonsTemplate, reasons.list())
+		return
+	}
+
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	var err error
+
+	switch r.FormValue("action") {
+	case "save":
+		reason := cannedReason{
+			ID:    id,
+			Label: strings.TrimSpace(r.FormValue("label")),
+			Text:  strings.TrimSpace(r.FormValue("text")),
+		}
+		if reason.Label == "" || reason.Text == "" {
+			httpError(rw, r, "label and text are required", http.StatusBadRequest)
+			return
+		}
+		err = reasons.save(reason)
+	case "delete":
+		err = reasons.remove(id)
+	default:
+		httpError(rw, r, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		warnf("Reason update failed: %v\n", err)
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, urlFor(reasonsPath), http.StatusSeeOther)
+}
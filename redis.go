@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisConfig lets several replicas share one data directory: decisions go
+// through a Redis list, claims live in a Redis hash and job changes are
+// announced so every replica refreshes its view of the job. One replica is
+// elected to run the background workers. Teams, access, holds, reasons and
+// drafts are still read from the data directory at startup only.
+type redisConfig struct {
+	Addr     string `json:"addr"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	TLS      bool   `json:"tls"`
+	Prefix   string `json:"prefix"`
+	Replica  string `json:"replica"`
+}
+
+const redisTimeout = 10 * time.Second
+
+func (c redisConfig) enabled() bool {
+	return c.Addr != ""
+}
+
+func (c *redisConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid redis.addr: %v", err)
+	}
+	if c.DB < 0 {
+		return errors.New("redis.db must not be negative")
+	}
+	if c.Prefix == "" {
+		c.Prefix = "jobserver:"
+	}
+	if c.Replica == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("redis.replica: %v", err)
+		}
+		c.Replica = host
+	}
+	return nil
+}
+
+func (c redisConfig) key(parts ...string) string {
+	return c.Prefix + strings.Join(parts, ":")
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn speaks RESP over one connection. It is not safe for
+// concurrent use; redisClient serialises access.
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func dialRedis(c redisConfig) (*redisConn, error) {
+	var conn net.Conn
+	var err error
+	if c.TLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: redisTimeout}, "tcp", c.Addr, nil)
+	} else {
+		conn, err = net.DialTimeout("tcp", c.Addr, redisTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &redisConn{conn: conn, rd: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	if c.Password != "" {
+		args := []string{"AUTH", c.Password}
+		if c.Username != "" {
+			args = []string{"AUTH", c.Username, c.Password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return rc, nil
+}
+
+func (c *redisConn) send(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read returns a reply as string, int64, []interface{} or nil. Error
+// replies come back as redisError.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (c *redisConn) close() {
+	c.conn.Close()
+}
+
+// redisClient is a single reconnecting connection shared by callers.
+type redisClient struct {
+	sync.Mutex
+	config redisConfig
+	conn   *redisConn
+}
+
+func newRedisClient(c redisConfig) *redisClient {
+	return &redisClient{config: c}
+}
+
+// with runs fn on the connection, holding it for the whole call so
+// WATCH/MULTI/EXEC sequences aren't interleaved. The connection is dropped
+// after a network error.
+func (r *redisClient) with(timeout time.Duration, fn func(c *redisConn) error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.conn == nil {
+		conn, err := dialRedis(r.config)
+		if err != nil {
+			return err
+		}
+		r.conn = conn
+	}
+
+	r.conn.conn.SetDeadline(time.Now().Add(timeout))
+	err := fn(r.conn)
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			r.conn.close()
+			r.conn = nil
+		}
+	}
+	return err
+}
+
+func (r *redisClient) do(args ...string) (interface{}, error) {
+	var reply interface{}
+	err := r.with(redisTimeout, func(c *redisConn) error {
+		var err error
+		reply, err = c.do(args...)
+		return err
+	})
+	return reply, err
+}
+
+func redisString(reply interface{}) (string, bool) {
+	s, ok := reply.(string)
+	return s, ok
+}
+
+func redisInt(reply interface{}) int64 {
+	n, _ := reply.(int64)
+	return n
+}
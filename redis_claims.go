@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// redisClaims keeps claims in one Redis hash, job ID to JSON claim, so all
+// replicas see the same holders. Changes to a claim run in a WATCH/MULTI
+// transaction and are retried if another replica got there first.
+type redisClaims struct {
+	client *redisClient
+	key    string
+}
+
+const redisClaimRetries = 5
+
+var errClaimContended = errors.New("claim changed concurrently")
+
+func newRedisClaims(client *redisClient) *redisClaims {
+	return &redisClaims{client: client, key: client.config.key("claims")}
+}
+
+func decodeClaim(reply interface{}) (claim, bool) {
+	s, ok := redisString(reply)
+	if !ok {
+		return claim{}, false
+	}
+	var c claim
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return claim{}, false
+	}
+	return c, true
+}
+
+// update reads the claim on id, lets change decide on the new one (nil
+// deletes it) and writes it back atomically.
+func (s *redisClaims) update(id int, change func(c claim, ok bool) *claim) error {
+	field := strconv.Itoa(id)
+	for i := 0; i < redisClaimRetries; i++ {
+		err := s.client.with(redisTimeout, func(conn *redisConn) error {
+			if _, err := conn.do("WATCH", s.key); err != nil {
+				return err
+			}
+			reply, err := conn.do("HGET", s.key, field)
+			if err != nil {
+				return err
+			}
+			c, ok := decodeClaim(reply)
+			next := change(c, ok)
+
+			if _, err := conn.do("MULTI"); err != nil {
+				return err
+			}
+			if next == nil {
+				_, err = conn.do("HDEL", s.key, field)
+			} else {
+				data, _ := json.Marshal(next)
+				_, err = conn.do("HSET", s.key, field, string(data))
+			}
+			if err != nil {
+				conn.do("DISCARD")
+				return err
+			}
+			exec, err := conn.do("EXEC")
+			if err != nil {
+				return err
+			}
+			if exec == nil {
+				return errClaimContended
+			}
+			return nil
+		})
+		if err != errClaimContended {
+			return err
+		}
+	}
+	return errClaimContended
+}
+
+func (s *redisClaims) all() map[int]claim {
+	held := map[int]claim{}
+	reply, err := s.client.do("HGETALL", s.key)
+	if err != nil {
+		warnf("Claim read failed: %v\n", err)
+		return held
+	}
+	items, _ := reply.([]interface{})
+	for i := 0; i+1 < len(items); i += 2 {
+		field, _ := redisString(items[i])
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		if c, ok := decodeClaim(items[i+1]); ok {
+			held[id] = c
+		}
+	}
+	return held
+}
+
+func (s *redisClaims) acquire(id int, userName string, now time.Time) (claim, bool, bool) {
+	var result claim
+	held, isNew := false, false
+	err := s.update(id, func(c claim, ok bool) *claim {
+		if ok && c.active(now) && c.User != userName {
+			result, held, isNew = c, false, false
+			return &c
+		}
+		isNew = !ok || !c.active(now) || c.User != userName
+		if isNew {
+			c = claim{User: userName, At: now}
+		}
+		c.Expires = now.Add(cfg.ClaimTTL.Duration)
+		result, held = c, true
+		return &c
+	})
+	if err != nil {
+		warnf("Claim failed: ID: %d [%v]\n", id, err)
+		return claim{}, false, false
+	}
+	return result, held, isNew
+}
+
+func (s *redisClaims) get(id int, now time.Time) (claim, bool) {
+	reply, err := s.client.do("HGET", s.key, strconv.Itoa(id))
+	if err != nil {
+		warnf("Claim read failed: ID: %d [%v]\n", id, err)
+		return claim{}, false
+	}
+	c, ok := decodeClaim(reply)
+	if !ok || !c.active(now) {
+		return claim{}, false
+	}
+	return c, true
+}
+
+func (s *redisClaims) assign(id int, userName string, now time.Time) string {
+	previous := ""
+	err := s.update(id, func(c claim, ok bool) *claim {
+		previous = ""
+		if ok && c.active(now) {
+			previous = c.User
+		}
+		return &claim{User: userName, At: now, Expires: now.Add(cfg.ClaimTTL.Duration), Assigned: true}
+	})
+	if err != nil {
+		warnf("Assign failed: ID: %d [%v]\n", id, err)
+	}
+	return previous
+}
+
+func (s *redisClaims) heldBy(userName string, now time.Time) []int {
+	ids := []int{}
+	for id, c := range s.all() {
+		if c.User == userName && c.active(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *redisClaims) assignedTo(userName string) []int {
+	ids := []int{}
+	for id, c := range s.all() {
+		if c.User == userName && c.Assigned {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *redisClaims) active(now time.Time) map[int]claim {
+	held := s.all()
+	for id, c := range held {
+		if !c.active(now) {
+			delete(held, id)
+		}
+	}
+	return held
+}
+
+func (s *redisClaims) release(id int) (claim, bool) {
+	var released claim
+	found := false
+	err := s.update(id, func(c claim, ok bool) *claim {
+		released, found = c, ok
+		return nil
+	})
+	if err != nil {
+		warnf("Release failed: ID: %d [%v]\n", id, err)
+		return claim{}, false
+	}
+	return released, found
+}
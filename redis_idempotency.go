@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// redisIdempotency keeps idempotent responses in Redis keys expiring with
+// the window, so a retry landing on another replica is still recognised.
+type redisIdempotency struct {
+	client *redisClient
+}
+
+func newRedisIdempotency(client *redisClient) *redisIdempotency {
+	return &redisIdempotency{client: client}
+}
+
+func (s *redisIdempotency) redisKey(key string) string {
+	return s.client.config.key("idempotency", key)
+}
+
+func ttlMillis(expires time.Time) string {
+	ms := time.Until(expires).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+func (s *redisIdempotency) reserve(key string, res idempotentResponse) (idempotentResponse, bool, error) {
+	data, _ := json.Marshal(res)
+	reply, err := s.client.do("SET", s.redisKey(key), string(data), "NX", "PX", ttlMillis(res.Expires))
+	if err != nil {
+		return res, false, err
+	}
+	if reply == "OK" {
+		return res, true, nil
+	}
+
+	reply, err = s.client.do("GET", s.redisKey(key))
+	if err != nil {
+		return res, false, err
+	}
+	value, ok := redisString(reply)
+	if !ok {
+		// Expired in between
+		return s.reserve(key, res)
+	}
+	var prev idempotentResponse
+	if err := json.Unmarshal([]byte(value), &prev); err != nil {
+		return res, false, err
+	}
+	return prev, false, nil
+}
+
+func (s *redisIdempotency) complete(key string, res idempotentResponse) error {
+	data, _ := json.Marshal(res)
+	_, err := s.client.do("SET", s.redisKey(key), string(data), "PX", ttlMillis(res.Expires))
+	return err
+}
+
+func (s *redisIdempotency) forget(key string) {
+	s.client.do("DEL", s.redisKey(key))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// With Redis configured, one replica at a time is the leader and runs the
+// background workers that must not run on every replica: scheduled
+// reports, the expired claim sweep and state snapshots. Leadership is a
+// key holding the replica name with a TTL, renewed well before it lapses.
+// A replica that can't renew steps down at once, so at worst a task is
+// skipped rather than run twice.
+
+const (
+	leaderTTL   = 15 * time.Second
+	leaderRenew = 5 * time.Second
+)
+
+var leading int32
+
+// isLeader reports whether this replica runs the background workers.
+// Without Redis there is only one replica.
+func isLeader() bool {
+	return redisDB == nil || atomic.LoadInt32(&leading) == 1
+}
+
+func leaderKey() string {
+	return cfg.Redis.key("leader")
+}
+
+func campaign() {
+	for {
+		select {
+		case <-exit:
+			return
+		default:
+		}
+
+		won, err := holdLeadership()
+		if err != nil {
+			warnf("Leader election failed: %v\n", err)
+		}
+		setLeading(won && err == nil)
+		time.Sleep(leaderRenew)
+	}
+}
+
+func setLeading(won bool) {
+	var v int32
+	if won {
+		v = 1
+	}
+	if atomic.SwapInt32(&leading, v) != v {
+		if won {
+			infof("Replica %s is now the leader\n", cfg.Redis.Replica)
+		} else {
+			infof("Replica %s is no longer the leader\n", cfg.Redis.Replica)
+		}
+	}
+}
+
+// holdLeadership takes the leader key if it is free or renews it if this
+// replica already holds it.
+func holdLeadership() (bool, error) {
+	won := false
+	ttl := strconv.FormatInt(leaderTTL.Milliseconds(), 10)
+	err := redisDB.with(redisTimeout, func(c *redisConn) error {
+		if _, err := c.do("WATCH", leaderKey()); err != nil {
+			return err
+		}
+		reply, err := c.do("GET", leaderKey())
+		if err != nil {
+			return err
+		}
+		holder, ok := redisString(reply)
+		switch {
+		case !ok:
+			if _, err := c.do("UNWATCH"); err != nil {
+				return err
+			}
+			reply, err = c.do("SET", leaderKey(), cfg.Redis.Replica, "NX", "PX", ttl)
+			won = reply == "OK"
+			return err
+		case holder != cfg.Redis.Replica:
+			_, err := c.do("UNWATCH")
+			return err
+		}
+
+		// Renew only if the key didn't lapse to another replica meanwhile
+		if _, err := c.do("MULTI"); err != nil {
+			return err
+		}
+		if _, err := c.do("PEXPIRE", leaderKey(), ttl); err != nil {
+			c.do("DISCARD")
+			return err
+		}
+		exec, err := c.do("EXEC")
+		won = exec != nil
+		return err
+	})
+	return won, err
+}
+
+// resign hands leadership back at shutdown so another replica can take
+// over without waiting for the key to expire.
+func resign() {
+	if !isLeader() || redisDB == nil {
+		return
+	}
+	setLeading(false)
+	err := redisDB.with(redisTimeout, func(c *redisConn) error {
+		if _, err := c.do("WATCH", leaderKey()); err != nil {
+			return err
+		}
+		reply, err := c.do("GET", leaderKey())
+		if err != nil {
+			return err
+		}
+		if holder, _ := redisString(reply); holder != cfg.Redis.Replica {
+			_, err := c.do("UNWATCH")
+			return err
+		}
+		if _, err := c.do("MULTI"); err != nil {
+			return err
+		}
+		if _, err := c.do("DEL", leaderKey()); err != nil {
+			c.do("DISCARD")
+			return err
+		}
+		_, err = c.do("EXEC")
+		return err
+	})
+	if err != nil {
+		warnf("Leader resign failed: %v\n", err)
+	}
+}
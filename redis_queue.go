@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With Redis configured, decisions are pushed to a shared list and any
+// replica's worker may apply them. A worker moves what it takes to its own
+// processing list first, so decisions held by a replica that dies are
+// requeued when it comes back.
+
+var (
+	redisDB    *redisClient
+	redisFeed  *redisClient
+	feedStop   = make(chan struct{})
+	feedPaused = make(chan struct{})
+)
+
+const redisFeedWait = "1"
+
+func updatesKey() string {
+	return cfg.Redis.key("updates")
+}
+
+func processingKey() string {
+	return cfg.Redis.key("processing", cfg.Redis.Replica)
+}
+
+func jobsChannel() string {
+	return cfg.Redis.key("jobs")
+}
+
+func startRedis() error {
+	redisDB = newRedisClient(cfg.Redis)
+	redisFeed = newRedisClient(cfg.Redis)
+	if _, err := redisDB.do("PING"); err != nil {
+		return err
+	}
+	claims = newRedisClaims(redisDB)
+	idempotency = newRedisIdempotency(redisDB)
+	if err := syncLastID(); err != nil {
+		return err
+	}
+	go subscribeJobs()
+	go campaign()
+	return nil
+}
+
+// syncLastID raises the shared ID counter to at least the highest ID on
+// disk.
+func syncLastID() error {
+	reply, err := redisDB.do("GET", cfg.Redis.key("last_id"))
+	if err != nil {
+		return err
+	}
+	s, _ := redisString(reply)
+	shared, _ := strconv.Atoi(s)
+
+	idAlloc.Lock()
+	defer idAlloc.Unlock()
+	if shared < idAlloc.last {
+		_, err = redisDB.do("SET", cfg.Redis.key("last_id"), strconv.Itoa(idAlloc.last))
+	}
+	return err
+}
+
+func redisNextID() (int, error) {
+	reply, err := redisDB.do("INCR", cfg.Redis.key("last_id"))
+	return int(redisInt(reply)), err
+}
+
+func encodeUpdate(m msg) (string, error) {
+	data, err := json.Marshal(pendingUpdate{
+		ID:       m.id,
+		Dest:     m.dest,
+		Reason:   m.reason,
+		User:     m.user,
+		Checked:  m.checked,
+		QueuedAt: time.Now().UTC(),
+	})
+	return string(data), err
+}
+
+// offerRedisUpdate pushes m unless the shared queue is full.
+func offerRedisUpdate(m msg) bool {
+	reply, err := redisDB.do("LLEN", updatesKey())
+	if err != nil {
+		warnf("Update queue failed: ID: %d [%v]\n", m.id, err)
+		return false
+	}
+	if redisInt(reply) >= int64(updateCapacity()) {
+		return false
+	}
+	return pushRedisUpdate(m)
+}
+
+func pushRedisUpdate(m msg) bool {
+	data, err := encodeUpdate(m)
+	if err == nil {
+		_, err = redisDB.do("RPUSH", updatesKey(), data)
+	}
+	if err != nil {
+		warnf("Update queue failed: ID: %d [%v]\n", m.id, err)
+		return false
+	}
+	return true
+}
+
+func redisQueueLength() int {
+	reply, err := redisDB.do("LLEN", updatesKey())
+	if err != nil {
+		return 0
+	}
+	return int(redisInt(reply))
+}
+
+// feedUpdates hands decisions from Redis to the local update worker until
+// stopped. Decisions left in the processing list are requeued first.
+func feedUpdates() {
+	defer close(feedPaused)
+
+	for {
+		reply, err := redisDB.do("RPOPLPUSH", processingKey(), updatesKey())
+		if err != nil || reply == nil {
+			break
+		}
+	}
+
+	for {
+		select {
+		case <-feedStop:
+			return
+		default:
+		}
+
+		var reply interface{}
+		err := redisFeed.with(redisTimeout, func(c *redisConn) error {
+			var err error
+			reply, err = c.do("BRPOPLPUSH", updatesKey(), processingKey(), redisFeedWait)
+			return err
+		})
+		if err != nil {
+			warnf("Update feed failed: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		raw, ok := redisString(reply)
+		if !ok {
+			continue
+		}
+
+		var p pendingUpdate
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			warnf("Update skipped: %q [%v]\n", raw, err)
+			redisDB.do("LREM", processingKey(), "1", raw)
+			continue
+		}
+		select {
+		case updateChanFor(p.ID) <- msg{id: p.ID, dest: p.Dest, reason: p.Reason, user: p.User, checked: p.Checked, raw: raw}:
+		case <-feedStop:
+			// Still in the processing list, requeued on the next start
+			return
+		}
+	}
+}
+
+func stopFeed() {
+	close(feedStop)
+	<-feedPaused
+}
+
+func completeRedisUpdate(m msg) {
+	if _, err := redisDB.do("LREM", processingKey(), "1", m.raw); err != nil {
+		warnf("Update cleanup failed: ID: %d [%v]\n", m.id, err)
+	}
+}
+
+// announceJob tells the other replicas that a job's files or metadata
+// changed.
+func announceJob(id int) {
+	if redisDB == nil {
+		return
+	}
+	if _, err := redisDB.do("PUBLISH", jobsChannel(), cfg.Redis.Replica+" "+strconv.Itoa(id)); err != nil {
+		warnf("Announce failed: ID: %d [%v]\n", id, err)
+	}
+}
+
+func subscribeJobs() {
+	for {
+		if err := listenJobs(); err != nil {
+			warnf("Job subscription failed: %v\n", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func listenJobs() error {
+	conn, err := dialRedis(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+
+	if err := conn.send("SUBSCRIBE", jobsChannel()); err != nil {
+		return err
+	}
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		items, _ := reply.([]interface{})
+		if len(items) != 3 || items[0] != "message" {
+			continue
+		}
+		payload, _ := redisString(items[2])
+		fields := strings.Fields(payload)
+		if len(fields) != 2 || fields[0] == cfg.Redis.Replica {
+			continue
+		}
+		if id, err := strconv.Atoi(fields[1]); err == nil {
+			refreshJob(id)
+		}
+	}
+}
+
+// refreshJob reloads a job changed by another replica from the shared data
+// directory.
+func refreshJob(id int) {
+	if err := metadata.reload(id); err != nil {
+		warnf("Refresh failed: ID: %d [%v]\n", id, err)
+	}
+
+	q := queueOf(id)
+	for _, dir := range dirs {
+		_, err := os.Stat(q.file(id, dir))
+		sm := q.states(dir, id)
+		sm.Lock()
+		if err == nil {
+			sm.idMap[id] = true
+		} else {
+			delete(sm.idMap, id)
+		}
+		sm.Unlock()
+	}
+}
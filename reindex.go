@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	reindexPath = "/admin/reindex"
+	// reindexEvery is how many jobs pass between progress reports
+	reindexEvery = 500
+)
+
+// A reindex rebuilds what the server keeps about jobs from the job files
+// themselves, for recovery after the metadata or the state log got
+// corrupted: the state log is written anew from the state directories,
+// metadata that can't be read is taken again from the job's content, and
+// the size, hash and content type recorded for the others are checked
+// against it. The metadata cache and sort indexes are then loaded afresh.
+// There is no full-text search index to rebuild: the job list filters and
+// sorts from the metadata cache and sort indexes alone.
+//
+// It runs as "jobServer reindex" with the server stopped, or through
+// POST /admin/reindex while maintenance mode keeps writes out.
+
+type reindexReport struct {
+	Jobs int `json:"jobs"`
+	// Ingested jobs had no readable metadata
+	Ingested []int `json:"ingested,omitempty"`
+	// Refreshed jobs had metadata that no longer matched their content
+	Refreshed []int `json:"refreshed,omitempty"`
+	// Orphaned metadata has no job file; it is kept as it is
+	Orphaned []int   `json:"orphaned,omitempty"`
+	Failed   []int   `json:"failed,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// reindexState is what GET /admin/reindex reports: the progress of the
+// running reindex, or how the last one went.
+type reindexState struct {
+	sync.RWMutex
+	Running    bool           `json:"running"`
+	Done       int            `json:"done"`
+	Total      int            `json:"total"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Report     *reindexReport `json:"report,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+var reindexing reindexState
+
+func (s *reindexState) get() reindexState {
+	s.RLock()
+	defer s.RUnlock()
+	return reindexState{
+		Running:    s.Running,
+		Done:       s.Done,
+		Total:      s.Total,
+		StartedAt:  s.StartedAt,
+		FinishedAt: s.FinishedAt,
+		Report:     s.Report,
+		Error:      s.Error,
+	}
+}
+
+// start marks a reindex as running, unless one already is.
+func (s *reindexState) start() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.Running {
+		return false
+	}
+	now := time.Now().UTC()
+	s.Running, s.Done, s.Total = true, 0, 0
+	s.StartedAt, s.FinishedAt = &now, nil
+	s.Report, s.Error = nil, ""
+	return true
+}
+
+func (s *reindexState) progress(done, total int) {
+	s.Lock()
+	s.Done, s.Total = done, total
+	s.Unlock()
+}
+
+func (s *reindexState) finish(report reindexReport, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().UTC()
+	s.Running = false
+	s.FinishedAt = &now
+	s.Report = &report
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// reindex rebuilds the state log and metadata from the job files, calling
+// progress every reindexEvery jobs and once at the end.
+func reindex(progress func(done, total int)) (reindexReport, error) {
+	start := time.Now()
+	report := reindexReport{}
+
+	// The update workers stay paused until the state log and cache agree
+	// with the state directories again, as for a backup snapshot
+	updatesPaused.Lock()
+	defer updatesPaused.Unlock()
+
+	jobs, events := listStateDirs()
+	report.Jobs = len(events)
+	for i, e := range events {
+		q, _ := lookupQueue(e.Queue)
+		if err := reindexJob(q, e.ID, e.State, &report); err != nil {
+			warnf("Reindex failed: ID: %d [%v]\n", e.ID, err)
+			report.Failed = append(report.Failed, e.ID)
+		}
+		if (i+1)%reindexEvery == 0 {
+			progress(i+1, len(events))
+		}
+	}
+	progress(len(events), len(events))
+
+	names, err := os.ReadDir(path.Join(contentPath, metaDir))
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	for _, entry := range names {
+		id, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), metaSuffix))
+		if err != nil || !strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		if _, ok := jobs[id]; !ok {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+
+	if err := rewriteStateLog(jobs, events); err != nil {
+		return report, err
+	}
+	if err := metadata.load(); err != nil {
+		return report, err
+	}
+	initLastID()
+
+	report.Seconds = time.Since(start).Seconds()
+	return report, nil
+}
+
+// reindexJob checks the metadata file of one job against its content and
+// writes it again where it is missing, unreadable or out of date.
+func reindexJob(q *queue, id int, state string, report *reindexReport) error {
+	fresh, err := inspectJob(q, id, state)
+	if err != nil {
+		return err
+	}
+
+	m, err := readMeta(metaFile(id))
+	switch {
+	case err != nil:
+		if !os.IsNotExist(err) {
+			warnf("Reindex replaces metadata: ID: %d [%v]\n", id, err)
+		}
+		report.Ingested = append(report.Ingested, id)
+		m = &fresh
+	case m.ID != id || m.Queue != fresh.Queue || m.Size != fresh.Size ||
+		m.SHA256 != fresh.SHA256 || m.ContentType != fresh.ContentType:
+		report.Refreshed = append(report.Refreshed, id)
+		m.ID = id
+		m.Queue = fresh.Queue
+		m.Size = fresh.Size
+		m.SHA256 = fresh.SHA256
+		m.ContentType = fresh.ContentType
+		if m.Title == "" || m.DerivedTitle {
+			m.Title, m.Summary, m.DerivedTitle = fresh.Title, fresh.Summary, fresh.DerivedTitle
+		}
+	default:
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaFile(id), data)
+}
+
+// runReindex is the reindex command. It exits the process.
+func runReindex() {
+	infof("Reindexing jobs\n")
+	report, err := reindex(func(done, total int) {
+		infof("Reindexed %d of %d jobs\n", done, total)
+	})
+	if err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+	infof("Reindex done in %.1fs: %d jobs, %d ingested, %d refreshed, %d orphaned metadata, %d failed\n",
+		report.Seconds, report.Jobs, len(report.Ingested), len(report.Refreshed), len(report.Orphaned), len(report.Failed))
+	if len(report.Failed) > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// reindexHandler reports reindex progress (GET) or starts a reindex (POST).
+// A reindex rewrites the state log, so maintenance mode must be on first.
+func reindexHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeJSON(rw, http.StatusOK, reindexing.get())
+	case http.MethodPost:
+		if !inMaintenance() {
+			writeError(rw, r, http.StatusConflict, codeConflict, "maintenance mode must be on to reindex")
+			return
+		}
+		if !reindexing.start() {
+			writeError(rw, r, http.StatusConflict, codeConflict, "a reindex is already running")
+			return
+		}
+		if err := audit(auditEntry{Actor: currentUser(r).Name, Action: "reindex"}); err != nil {
+			warnf("Reindex audit failed: %v\n", err)
+		}
+		go func() {
+			report, err := reindex(reindexing.progress)
+			if err != nil {
+				warnf("Reindex failed: %v\n", err)
+			} else {
+				infof("Reindex done: %d jobs, %d ingested, %d refreshed, %d failed\n",
+					report.Jobs, len(report.Ingested), len(report.Refreshed), len(report.Failed))
+			}
+			reindexing.finish(report, err)
+		}()
+		writeJSON(rw, http.StatusAccepted, reindexing.get())
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reminderPolicy sends reviewers a digest of what has been sitting in a
+// queue for longer than After: jobs assigned to them that long ago and
+// jobs nobody has claimed since they came in. Reviewers opt in through
+// the notify target of their user entry; a Slack incoming webhook works
+// as the webhook, since the message carries a text field.
+type reminderPolicy struct {
+	After    duration `json:"after"`
+	Schedule schedule `json:"schedule"`
+}
+
+// staleJob is one line of a reminder digest.
+type staleJob struct {
+	ID       int       `json:"id"`
+	Title    string    `json:"title,omitempty"`
+	Since    time.Time `json:"since"`
+	Assigned bool      `json:"assigned,omitempty"`
+}
+
+type reminderDigest struct {
+	Queue    string     `json:"queue,omitempty"`
+	Reviewer string     `json:"reviewer"`
+	After    string     `json:"after"`
+	Jobs     []staleJob `json:"jobs"`
+}
+
+func (p *reminderPolicy) validate(queueName string) error {
+	name := "reminders"
+	if queueName != "" {
+		name = "queue " + queueName + ": reminders"
+	}
+	if p.After.Duration <= 0 {
+		return errors.New(name + ".after must be positive")
+	}
+	if p.Schedule.Every == "" {
+		p.Schedule.Every = scheduleDaily
+	}
+	if err := p.Schedule.validate(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// staleJobs lists the jobs of q userName should be reminded of at now.
+func (p *reminderPolicy) staleJobs(q *queue, u user, now time.Time) []staleJob {
+	held := claims.active(now)
+	cutoff := now.Add(-p.After.Duration)
+
+	jobs := []staleJob{}
+	for _, id := range q.ids("review") {
+		m, ok := metadata.get(id)
+		if !ok || m.Deferred {
+			continue
+		}
+		job := staleJob{ID: id, Title: m.Title, Since: m.SubmittedAt}
+		if c, ok := held[id]; ok {
+			if !c.Assigned || c.User != u.Name {
+				continue
+			}
+			job.Since, job.Assigned = c.At, true
+		} else if !inQueue(m.Team, u.Name) {
+			continue
+		}
+		if job.Since.Before(cutoff) {
+			jobs = append(jobs, job)
+		}
+	}
+	// Their own assignments first, then oldest first
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Assigned != jobs[j].Assigned {
+			return jobs[i].Assigned
+		}
+		if !jobs[i].Since.Equal(jobs[j].Since) {
+			return jobs[i].Since.Before(jobs[j].Since)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// waitingFor rounds how long a job has waited to what a digest needs.
+func waitingFor(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return inDays(d.Truncate(24 * time.Hour))
+	}
+	return d.Round(time.Minute).String()
+}
+
+func formatDigest(q *queue, d reminderDigest, now time.Time) (string, string) {
+	where := "the review queue"
+	if q.name != "" {
+		where = "queue " + q.name
+	}
+	subject := fmt.Sprintf("%d jobs waiting in %s", len(d.Jobs), where)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "These jobs in %s have been waiting for more than %s:\n\n", where, d.After)
+	for _, job := range d.Jobs {
+		fmt.Fprintf(&text, "%d", job.ID)
+		if job.Title != "" {
+			fmt.Fprintf(&text, " %s", job.Title)
+		}
+		what := "unclaimed"
+		if job.Assigned {
+			what = "assigned to you"
+		}
+		fmt.Fprintf(&text, " (%s for %s)\n  %s\n", what, waitingFor(now.Sub(job.Since)), q.viewURL(job.ID))
+	}
+	return subject, text.String()
+}
+
+// remind sends every reviewer with access to q and a notify target a
+// digest of the jobs they should look at, if there are any.
+func (p *reminderPolicy) remind(q *queue, now time.Time) {
+	for _, u := range cfg.Users {
+		if u.Role == roleSubmitter || u.Notify.empty() {
+			continue
+		}
+		if q.name != "" && u.Role != roleAdmin && !access.allowed(q.name, u.Name) {
+			continue
+		}
+		jobs := p.staleJobs(q, u, now)
+		if len(jobs) == 0 {
+			continue
+		}
+
+		d := reminderDigest{Queue: q.name, Reviewer: u.Name, After: inDays(p.After.Duration), Jobs: jobs}
+		subject, text := formatDigest(q, d, now)
+		if err := deliver(u.Notify, subject, text, d); err != nil {
+			warnf("Reminder failed: %s [%v]\n", strconv.Quote(u.Name), err)
+		}
+	}
+}
+
+func startReminders() {
+	for _, q := range queues {
+		p := q.reminders
+		if p == nil {
+			continue
+		}
+		name := "reminders"
+		if q.name != "" {
+			name += " " + q.name
+		}
+		go runScheduled(name, p.Schedule, func(q *queue) func(time.Time) {
+			return func(now time.Time) {
+				p.remind(q, now)
+			}
+		}(q))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/template"
+	"time"
+)
+
+const (
+	reportTemplate = "reports/summary.txt"
+	reportsPath    = "/admin/reports"
+)
+
+type reportConfig struct {
+	Name     string   `json:"name"`
+	Schedule schedule `json:"schedule"`
+	Template string   `json:"template"`
+	Deliver  delivery `json:"deliver"`
+}
+
+type report struct {
+	Name          string         `json:"name"`
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	Received      int            `json:"received"`
+	Decided       int            `json:"decided"`
+	ByState       map[string]int `json:"decided_by_state"`
+	Backlog       int            `json:"backlog"`
+	SLA           duration       `json:"sla"`
+	SLABreaches   []int          `json:"sla_breaches"`
+	LateDecisions int            `json:"late_decisions"`
+}
+
+func (c reportConfig) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("report without name")
+	}
+	if c.Deliver.empty() {
+		return fmt.Errorf("report %s: no delivery target", c.Name)
+	}
+	if err := c.Schedule.validate(); err != nil {
+		return fmt.Errorf("report %s: %v", c.Name, err)
+	}
+	return nil
+}
+
+func buildReport(name string, from, to time.Time) report {
+	rep := report{
+		Name:    name,
+		From:    from,
+		To:      to,
+		ByState: map[string]int{},
+		SLA:     cfg.SLA,
+	}
+
+	pending := []int{}
+	for _, q := range queues {
+		pending = append(pending, q.ids("review")...)
+	}
+	rep.Backlog = len(pending)
+
+	metadata.RLock()
+	defer metadata.RUnlock()
+
+	for _, m := range metadata.entries {
+		if inRange(m.SubmittedAt, from, to) {
+			rep.Received++
+		}
+		d := m.Decision
+		if d == nil || !inRange(d.At, from, to) {
+			continue
+		}
+		rep.Decided++
+		rep.ByState[d.State]++
+		if rep.SLA.Duration > 0 && d.At.Sub(m.SubmittedAt) > rep.SLA.Duration {
+			rep.LateDecisions++
+		}
+	}
+
+	if rep.SLA.Duration > 0 {
+		for _, id := range pending {
+			m, ok := metadata.entries[id]
+			if ok && to.Sub(m.SubmittedAt) > rep.SLA.Duration {
+				rep.SLABreaches = append(rep.SLABreaches, id)
+			}
+		}
+		sort.Ints(rep.SLABreaches)
+	}
+
+	return rep
+}
+
+func loadReportTemplate(file string) (*template.Template, error) {
+	t := template.New("").Funcs(templateFuncs)
+	if file != "" {
+		return t.ParseFiles(file)
+	}
+	return t.ParseFS(assetFS("tmpl", templateDir()), reportTemplate)
+}
+
+func renderReport(rc reportConfig, rep report) (string, string, error) {
+	t, err := loadReportTemplate(rc.Template)
+	if err != nil {
+		return "", "", err
+	}
+
+	var subject, body bytes.Buffer
+	if err := t.ExecuteTemplate(&subject, "subject", rep); err != nil {
+		return "", "", err
+	}
+	if err := t.ExecuteTemplate(&body, "body", rep); err != nil {
+		return "", "", err
+	}
+	return subject.String(), body.String(), nil
+}
+
+func runReport(rc reportConfig, now time.Time) error {
+	rep := buildReport(rc.Name, now.Add(-rc.Schedule.period()), now)
+
+	subject, text, err := renderReport(rc, rep)
+	if err != nil {
+		return err
+	}
+	return deliver(rc.Deliver, subject, text, rep)
+}
+
+func startReports() {
+	for _, rc := range cfg.Reports {
+		go runScheduled("report "+rc.Name, rc.Schedule, func(rc reportConfig) func(time.Time) {
+			return func(now time.Time) {
+				if err := runReport(rc, now); err != nil {
+					warnf("Report failed: %s [%v]\n", rc.Name, err)
+				}
+			}
+		}(rc))
+	}
+}
+
+// reportsHandler lets an admin send a configured report immediately.
+func reportsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("name")
+	for _, rc := range cfg.Reports {
+		if rc.Name != name {
+			continue
+		}
+		if err := runReport(rc, time.Now()); err != nil {
+			warnf("Report failed: %s [%v]\n", rc.Name, err)
+			httpError(rw, r, err.Error(), http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(rw, "Report %s sent\n", rc.Name)
+		return
+	}
+	notFound(rw, r)
+}
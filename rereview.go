@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rereview links a job back to the decided job it re-reviews, e.g. after
+// a policy change. The original keeps its decision; the copy goes through
+// review like a new submission, with the earlier decision for context.
+type rereview struct {
+	Of       int       `json:"of"`
+	Decision decision  `json:"decision"`
+	Reason   string    `json:"reason,omitempty"`
+	By       string    `json:"by"`
+	At       time.Time `json:"at"`
+}
+
+// rereviewLock makes checking for a pending copy and creating one a
+// single step.
+var rereviewLock sync.Mutex
+
+// checkRereview validates flagging job m for re-review on behalf of the
+// request's user. Only decided jobs can be re-reviewed, and only one copy
+// at a time may be waiting.
+func checkRereview(r *http.Request, m jobMeta) (int, *apiError) {
+	if !isSenior(r) {
+		return http.StatusForbidden, &apiError{Code: codeForbidden, Message: "permission denied"}
+	}
+	state := findState(m.ID)
+	if (state != "accept" && state != "reject") || m.Decision == nil {
+		return http.StatusConflict, &apiError{
+			Code:    codeInvalidTransition,
+			Message: fmt.Sprintf("job %d is not decided", m.ID),
+			Details: map[string]string{"from": state, "to": "review"},
+		}
+	}
+	for _, id := range m.Rereviews {
+		if state := findState(id); state == "review" || state == "escalate" {
+			return http.StatusConflict, &apiError{
+				Code:    codeRereviewPending,
+				Message: fmt.Sprintf("job %d is already up for re-review as %d", m.ID, id),
+				Details: map[string]int{"rereview": id},
+			}
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// copyAttachments copies the attachments of job from over to job to.
+func copyAttachments(from int, to int, list []attachment) error {
+	for _, a := range list {
+		data, err := os.ReadFile(attachmentFile(from, a.Name))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(attachmentFile(to, a.Name)), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(attachmentFile(to, a.Name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRereview puts a copy of decided job m back into review, linked to
+// it both ways.
+func createRereview(m jobMeta, userName string, reason string) (jobMeta, error) {
+	q := queueOf(m.ID)
+	body, err := readBody(q.file(m.ID, findState(m.ID)))
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	c, err := createJob(q, bodyBytes(body), jobMeta{
+		Team:      m.Team,
+		Fields:    m.Fields,
+		Tags:      m.Tags,
+		Submitter: m.Submitter,
+		Source:    m.Source,
+		RelayedBy: m.RelayedBy,
+		Score:     m.Score,
+		Labels:    m.Labels,
+	})
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	copied := true
+	if err := copyAttachments(m.ID, c.ID, m.Attachments); err != nil {
+		warnf("Attachment copy failed: ID: %d -> %d [%v]\n", m.ID, c.ID, err)
+		copied = false
+	}
+	c, err = metadata.update(c.ID, func(meta *jobMeta) error {
+		if m.Title != "" {
+			meta.Title = m.Title
+		}
+		meta.Rereview = &rereview{Of: m.ID, Decision: *m.Decision, Reason: reason, By: userName, At: time.Now().UTC()}
+		if copied {
+			meta.Attachments = m.Attachments
+		}
+		return nil
+	})
+	if err != nil {
+		return c, err
+	}
+
+	_, err = metadata.update(m.ID, func(m *jobMeta) error {
+		m.Rereviews = append(append([]int{}, m.Rereviews...), c.ID)
+		return nil
+	})
+	if err != nil {
+		return c, err
+	}
+
+	entry := auditEntry{Actor: userName, Action: "rereview", ID: m.ID, Note: "copy " + strconv.Itoa(c.ID)}
+	if reason != "" {
+		entry.Note += " " + reason
+	}
+	if err := audit(entry); err != nil {
+		warnf("Rereview audit failed: %v\n", err)
+	}
+	return c, nil
+}
+
+// apiRereview flags a decided job for re-review, with the form field
+// "reason" saying why, and answers with the new copy.
+func apiRereview(rw http.ResponseWriter, r *http.Request, id int) {
+	rereviewLock.Lock()
+	defer rereviewLock.Unlock()
+
+	m, ok := metadata.get(id)
+	if !ok {
+		notFound(rw, r)
+		return
+	}
+	if status, failure := checkRereview(r, m); failure != nil {
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+
+	c, err := createRereview(m, currentUser(r).Name, strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		warnf("Rereview failed: ID: %d [%v]\n", m.ID, err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Location", apiURL(r, apiJobsPath, "/", strconv.Itoa(c.ID)))
+	writeJSON(rw, http.StatusCreated, c)
+}
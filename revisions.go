@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	revisionsPath     = "/revisions/"
+	diffPath          = "/diff/"
+	revisionsTemplate = "revisions.html"
+	diffTemplate      = "diff.html"
+)
+
+type revisionInfo struct {
+	Number  int       `json:"number"`
+	Title   string    `json:"title,omitempty"`
+	Size    int64     `json:"size"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+type revisionsPage struct {
+	ID        string
+	Title     string
+	Current   int
+	Revisions []revisionInfo
+}
+
+type diffPage struct {
+	revisionsPage
+	A, B   int
+	Binary bool
+	Hunks  []diffHunk
+}
+
+// jobRevisions lists every known revision of a job. Jobs ingested before
+// revisions were tracked get a single synthesised entry.
+func jobRevisions(m jobMeta) []revisionInfo {
+	if len(m.Revisions) > 0 {
+		return m.Revisions
+	}
+	return []revisionInfo{{Number: m.Revision, Title: m.Title, Size: m.Size, SavedAt: m.SubmittedAt}}
+}
+
+func readRevision(id int, rev int) ([]byte, error) {
+	m, ok := metadata.get(id)
+	if !ok || rev < 1 || rev > m.Revision {
+		return nil, fmt.Errorf("revision not present: %d@%d", id, rev)
+	}
+	if rev == m.Revision {
+		state := findState(id)
+		if state == "" {
+			return nil, fmt.Errorf("entry not present: %d", id)
+		}
+		return readBody(jobFile(id, state))
+	}
+	return readBody(revisionFile(id, rev))
+}
+
+func isTextContent(contentType string) bool {
+	format := classifyContent(contentType)
+	return format == formatText || format == formatMarkdown
+}
+
+// diffRevisions returns the hunks between two revisions as r may see them,
+// or binary=true when either side can't be shown as text.
+func diffRevisions(r *http.Request, id int, a, b int) (hunks []diffHunk, binary bool, err error) {
+	oldBody, err := readRevision(id, a)
+	if err != nil {
+		return nil, false, err
+	}
+	newBody, err := readRevision(id, b)
+	if err != nil {
+		return nil, false, err
+	}
+	if !isTextContent(sniffContentType(oldBody)) || !isTextContent(sniffContentType(newBody)) {
+		return nil, true, nil
+	}
+	oldBody, _ = displayBody(r, id, oldBody)
+	newBody, _ = displayBody(r, id, newBody)
+	return unifiedHunks(string(oldBody), string(newBody)), false, nil
+}
+
+func parseRevisionPair(r *http.Request, m jobMeta) (int, int) {
+	a, err := strconv.Atoi(r.FormValue("a"))
+	if err != nil {
+		a = m.Revision - 1
+	}
+	b, err := strconv.Atoi(r.FormValue("b"))
+	if err != nil {
+		b = m.Revision
+	}
+	if a < 1 {
+		a = 1
+	}
+	return a, b
+}
+
+func newRevisionsPage(m jobMeta) revisionsPage {
+	title := m.Title
+	if title == "" {
+		title = "Job"
+	}
+	return revisionsPage{
+		ID:        strconv.Itoa(m.ID),
+		Title:     title,
+		Current:   m.Revision,
+		Revisions: jobRevisions(m),
+	}
+}
+
+func revisionsHandler(rw http.ResponseWriter, r *http.Request) {
+	m, ok := jobMetaFromPath(rw, r)
+	if !ok {
+		return
+	}
+	renderTemplate(rw, r, revisionsTemplate, newRevisionsPage(m))
+}
+
+func diffHandler(rw http.ResponseWriter, r *http.Request) {
+	m, ok := jobMetaFromPath(rw, r)
+	if !ok {
+		return
+	}
+
+	a, b := parseRevisionPair(r, m)
+	hunks, binary, err := diffRevisions(r, m.ID, a, b)
+	if err != nil {
+		warnf("Diff failed: ID: %d [%v]\n", m.ID, err)
+		notFound(rw, r)
+		return
+	}
+
+	renderTemplate(rw, r, diffTemplate, diffPage{
+		revisionsPage: newRevisionsPage(m),
+		A:             a,
+		B:             b,
+		Binary:        binary,
+		Hunks:         hunks,
+	})
+}
+
+func jobMetaFromPath(rw http.ResponseWriter, r *http.Request) (jobMeta, bool) {
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Load failed: %v\n", err)
+		notFound(rw, r)
+		return jobMeta{}, false
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil {
+		warnf("Load failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return jobMeta{}, false
+	}
+
+	m, ok := metadata.get(id)
+	if !ok {
+		warnf("Load failed: ID: %d [metadata not present]\n", id)
+		notFound(rw, r)
+		return jobMeta{}, false
+	}
+	return m, true
+}
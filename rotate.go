@@ -0,0 +1,191 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotationConfig applies to every log written to a file: the audit log
+// and, when log_file is set, the server log. Zero values disable each
+// limit.
+type rotationConfig struct {
+	MaxSize  int64    `json:"max_size"`
+	MaxAge   duration `json:"max_age"`
+	Keep     int      `json:"keep"`
+	Retain   duration `json:"retain"`
+	Compress bool     `json:"compress"`
+}
+
+const rotatedTimeFormat = "20060102T150405.000"
+
+func (c rotationConfig) validate() error {
+	if c.MaxSize < 0 || c.MaxAge.Duration < 0 || c.Keep < 0 || c.Retain.Duration < 0 {
+		return errors.New("log_rotation limits must not be negative")
+	}
+	return nil
+}
+
+// rotatingFile is an append-only file that is renamed aside once it grows
+// past MaxSize or has been written to for MaxAge. Rotated files are
+// named <name>.<time>, optionally gzipped, and pruned to Keep and Retain.
+type rotatingFile struct {
+	sync.Mutex
+	name   string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingFile(name string) *rotatingFile {
+	return &rotatingFile{name: name}
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	rc := cfg.LogRotation
+	if f.file != nil && (rc.MaxSize > 0 && f.size+int64(len(b)) > rc.MaxSize ||
+		rc.MaxAge.Duration > 0 && time.Since(f.opened) >= rc.MaxAge.Duration) {
+		if err := f.rotate(rc); err != nil {
+			fmt.Fprintf(os.Stderr, "Log rotation failed: %s [%v]\n", f.name, err)
+		}
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// open must be called with the lock held.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+// rotate must be called with the lock held.
+func (f *rotatingFile) rotate(rc rotationConfig) error {
+	f.file.Close()
+	f.file = nil
+
+	rotated := f.name + "." + time.Now().UTC().Format(rotatedTimeFormat)
+	if err := os.Rename(f.name, rotated); err != nil {
+		return err
+	}
+	go func() {
+		if rc.Compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Log compression failed: %s [%v]\n", rotated, err)
+			}
+		}
+		pruneRotated(f.name, rc)
+	}()
+	return nil
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(name + ".gz.tmp")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".gz.tmp", name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// pruneRotated removes rotated copies of name beyond the configured count
+// or age, oldest first.
+func pruneRotated(name string, rc rotationConfig) {
+	matches, err := filepath.Glob(name + ".*")
+	if err != nil {
+		return
+	}
+	rotated := []string{}
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".tmp") {
+			rotated = append(rotated, m)
+		}
+	}
+	// The timestamp suffix sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+
+	for i, m := range rotated {
+		expired := false
+		if rc.Keep > 0 && i >= rc.Keep {
+			expired = true
+		}
+		if info, err := os.Stat(m); err == nil && rc.Retain.Duration > 0 && time.Since(info.ModTime()) > rc.Retain.Duration {
+			expired = true
+		}
+		if expired {
+			if err := os.Remove(m); err != nil {
+				fmt.Fprintf(os.Stderr, "Log prune failed: %s [%v]\n", m, err)
+			}
+		}
+	}
+}
+
+// logToFile sends everything the server prints to a rotated file.
+func logToFile(name string) error {
+	out := newRotatingFile(name)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		if _, err := io.Copy(out, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Log copy failed: %v\n", err)
+		}
+	}()
+	os.Stdout = w
+	log.SetOutput(out)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ruleAccept = "accept"
+	ruleReject = "reject"
+	ruleTag    = "tag"
+	ruleRoute  = "route"
+	// rulePriority sets the job's score, as a scoring service would
+	rulePriority = "priority"
+)
+
+// ruleVars are the variables rule expressions can read.
+var ruleVars = []string{"body", "size", "queue", "submitter", "content_type", "fields", "score", "labels"}
+
+// rulesUser is recorded as the decider for jobs a rule accepts or rejects.
+const rulesUser = "rules"
+
+// ruleMatch conditions must all hold for a rule to fire. Empty conditions
+// match anything. When is an expression (see expression) over ruleVars
+// that must come out true.
+type ruleMatch struct {
+	Queue       string            `json:"queue"`
+	Submitter   string            `json:"submitter"`
+	ContentType string            `json:"content_type"`
+	Content     string            `json:"content"`
+	Fields      map[string]string `json:"fields"`
+	MinScore    *float64          `json:"min_score"`
+	MaxScore    *float64          `json:"max_score"`
+	Label       string            `json:"label"`
+	When        string            `json:"when"`
+
+	content *regexp.Regexp
+	fields  map[string]*regexp.Regexp
+	when    *expression
+}
+
+// rule is one step of the rules engine. Priority is the expression a
+// priority rule computes the score with, such as
+// `len(body) > 10000 ? 1 : 5`.
+type rule struct {
+	Name     string    `json:"name"`
+	Match    ruleMatch `json:"match"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+	Tag      string    `json:"tag"`
+	Team     string    `json:"team"`
+	Priority string    `json:"priority"`
+
+	priority *expression
+}
+
+// submission is what rules see of a job before it is stored.
+type submission struct {
+	queue       string
+	submitter   string
+	contentType string
+	body        []byte
+	fields      map[string]string
+	score       scoreResult
+}
+
+type ruleOutcome struct {
+	Team     string
+	Tags     []string
+	Decision string
+	Reason   string
+	Score    *float64
+	Matched  []rule
+}
+
+// env is what rule expressions see of the submission.
+func (s submission) env() map[string]interface{} {
+	fields := map[string]interface{}{}
+	for name, v := range s.fields {
+		fields[name] = v
+	}
+	labels := []interface{}{}
+	for _, l := range s.score.Labels {
+		labels = append(labels, l)
+	}
+	var score interface{}
+	if s.score.Score != nil {
+		score = *s.score.Score
+	}
+	return map[string]interface{}{
+		"body":         string(s.body),
+		"size":         float64(len(s.body)),
+		"queue":        s.queue,
+		"submitter":    s.submitter,
+		"content_type": baseMediaType(s.contentType),
+		"fields":       fields,
+		"score":        score,
+		"labels":       labels,
+	}
+}
+
+func (ru *rule) compile() error {
+	if ru.Name == "" {
+		return errors.New("rule without name")
+	}
+	switch ru.Action {
+	case ruleAccept, ruleReject:
+	case ruleTag:
+		if ru.Tag == "" {
+			return fmt.Errorf("rule %s: tag is required", ru.Name)
+		}
+	case ruleRoute:
+		if ru.Team == "" {
+			return fmt.Errorf("rule %s: team is required", ru.Name)
+		}
+	case rulePriority:
+		if ru.Priority == "" {
+			return fmt.Errorf("rule %s: priority is required", ru.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: invalid action %q", ru.Name, ru.Action)
+	}
+
+	var err error
+	if ru.Priority != "" {
+		if ru.priority, err = compileExpression(ru.Priority, ruleVars); err != nil {
+			return fmt.Errorf("rule %s: priority: %v", ru.Name, err)
+		}
+	}
+	if ru.Match.When != "" {
+		if ru.Match.when, err = compileExpression(ru.Match.When, ruleVars); err != nil {
+			return fmt.Errorf("rule %s: when: %v", ru.Name, err)
+		}
+	}
+	if ru.Match.Content != "" {
+		if ru.Match.content, err = regexp.Compile(ru.Match.Content); err != nil {
+			return fmt.Errorf("rule %s: %v", ru.Name, err)
+		}
+	}
+	ru.Match.fields = map[string]*regexp.Regexp{}
+	for name, expr := range ru.Match.Fields {
+		if ru.Match.fields[name], err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("rule %s: field %s: %v", ru.Name, name, err)
+		}
+	}
+	return nil
+}
+
+func (m ruleMatch) matches(s submission) bool {
+	if m.Queue != "" && m.Queue != s.queue {
+		return false
+	}
+	if m.Submitter != "" && m.Submitter != s.submitter {
+		return false
+	}
+	if m.ContentType != "" && !strings.HasPrefix(baseMediaType(s.contentType), m.ContentType) {
+		return false
+	}
+	if m.content != nil && !m.content.Match(s.body) {
+		return false
+	}
+	for name, re := range m.fields {
+		if !re.MatchString(s.fields[name]) {
+			return false
+		}
+	}
+	if m.MinScore != nil && (s.score.Score == nil || *s.score.Score < *m.MinScore) {
+		return false
+	}
+	if m.MaxScore != nil && (s.score.Score == nil || *s.score.Score > *m.MaxScore) {
+		return false
+	}
+	if m.Label != "" && !hasLabel(s.score.Labels, m.Label) {
+		return false
+	}
+	if m.when != nil {
+		ok, err := m.when.evalBool(s.env())
+		if err != nil {
+			warnf("Rule condition failed: %q [%v]\n", m.When, err)
+			return false
+		}
+		return ok
+	}
+	return true
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateRules runs every configured rule in order. Tags and routing
+// accumulate, a priority replaces the score for the rules after it; the
+// first accept or reject settles the job and stops evaluation. Submitters
+// the rules flag is off for skip the rules.
+func evaluateRules(s submission) ruleOutcome {
+	out := ruleOutcome{}
+	if !flags.on(flagRules, s.submitter) {
+		return out
+	}
+	for _, ru := range cfg.Rules {
+		if !ru.Match.matches(s) {
+			continue
+		}
+		if ru.Action == rulePriority {
+			score, err := ru.priority.evalNumber(s.env())
+			if err != nil {
+				warnf("Rule failed: %s [%v]\n", ru.Name, err)
+				continue
+			}
+			s.score.Score = &score
+			out.Score = &score
+		}
+		out.Matched = append(out.Matched, ru)
+
+		switch ru.Action {
+		case ruleTag:
+			out.Tags = append(out.Tags, ru.Tag)
+		case ruleRoute:
+			out.Team = ru.Team
+		case ruleAccept, ruleReject:
+			out.Decision = ru.Action
+			out.Reason = ru.Reason
+			return out
+		}
+	}
+	return out
+}
+
+// applyRules records the outcome for a stored job and queues any automatic
+// decision.
+func applyRules(id int, out ruleOutcome) {
+	for _, ru := range out.Matched {
+		if err := audit(auditEntry{
+			Actor:  rulesUser,
+			Action: "rule",
+			ID:     id,
+			To:     ru.Action,
+			Note:   ru.Name,
+		}); err != nil {
+			warnf("Audit failed: ID: %d [%v]\n", id, err)
+		}
+	}
+
+	if out.Decision != "" {
+		queueUpdate(msg{id: id, dest: out.Decision, reason: out.Reason, user: rulesUser})
+	}
+}
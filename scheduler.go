@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scheduleDaily  = "daily"
+	scheduleWeekly = "weekly"
+)
+
+// schedule describes a recurring wall clock time, e.g. daily at 08:00 or
+// weekly on monday at 08:00.
+type schedule struct {
+	Every   string `json:"every"`
+	At      string `json:"at"`
+	Weekday string `json:"weekday"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func (s schedule) validate() error {
+	if _, _, err := s.clock(); err != nil {
+		return err
+	}
+	switch s.Every {
+	case scheduleDaily:
+	case scheduleWeekly:
+		if _, ok := weekdays[strings.ToLower(s.Weekday)]; !ok {
+			return fmt.Errorf("invalid weekday: %q", s.Weekday)
+		}
+	default:
+		return fmt.Errorf("invalid schedule: %q", s.Every)
+	}
+	return nil
+}
+
+func (s schedule) clock() (int, int, error) {
+	at := s.At
+	if at == "" {
+		at = "00:00"
+	}
+	parts := strings.Split(at, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid time: %q", s.At)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, fmt.Errorf("invalid time: %q", s.At)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time: %q", s.At)
+	}
+	return hour, minute, nil
+}
+
+func (s schedule) period() time.Duration {
+	if s.Every == scheduleWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// next returns the first scheduled time strictly after now.
+func (s schedule) next(now time.Time) time.Time {
+	hour, minute, _ := s.clock()
+	t := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+
+	if s.Every == scheduleWeekly {
+		want := weekdays[strings.ToLower(s.Weekday)]
+		t = t.AddDate(0, 0, (int(want)-int(t.Weekday())+7)%7)
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 7)
+		}
+		return t
+	}
+
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// runScheduled calls task at every scheduled time until the server exits.
+// Only the leader runs it when there are several replicas.
+func runScheduled(name string, s schedule, task func(time.Time)) {
+	for {
+		next := s.next(time.Now())
+		debugf("Scheduled %s at %s\n", name, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-exit:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if !isLeader() {
+				debugf("Skipped %s: not the leader\n", name)
+				continue
+			}
+			task(now)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxSchemaErrors = 50
+
+// submissionSchema is the JSON schema a queue holds submissions to, given
+// inline or as the path of a file. It covers the validation keywords of
+// JSON Schema for types, objects, arrays, strings, numbers and the
+// combinators; $ref is not supported. Annotations such as title or
+// format are accepted and ignored.
+type submissionSchema struct {
+	raw  json.RawMessage
+	root *schemaNode
+}
+
+// schemaError is a failed check, at the JSON pointer of the offending
+// value; the empty pointer is the whole document.
+type schemaError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type schemaNode struct {
+	accept *bool
+
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	properties           map[string]*schemaNode
+	required             []string
+	additional           *schemaNode
+	items                *schemaNode
+	minItems, maxItems   *int
+	uniqueItems          bool
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	exclusiveMin         *float64
+	exclusiveMax         *float64
+	multipleOf           *float64
+	allOf, anyOf, oneOf  []*schemaNode
+	not                  *schemaNode
+}
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// schemaAnnotations are keywords that don't take part in validation.
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true, "format": true,
+	"readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+func (s *submissionSchema) UnmarshalJSON(data []byte) error {
+	s.raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+func (s *submissionSchema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+func (s *submissionSchema) compile(name string) error {
+	raw := []byte(s.raw)
+	var file string
+	if json.Unmarshal(raw, &file) == nil {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%s: schema: %v", name, err)
+		}
+		raw = data
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("%s: schema: %v", name, err)
+	}
+	root, err := compileSchemaNode(doc, "")
+	if err != nil {
+		return fmt.Errorf("%s: schema%s", name, err)
+	}
+	s.root = root
+	return nil
+}
+
+func compileSchemaNode(doc interface{}, at string) (*schemaNode, error) {
+	fail := func(format string, args ...interface{}) (*schemaNode, error) {
+		return nil, fmt.Errorf(" at %q: %s", "#"+at, fmt.Sprintf(format, args...))
+	}
+	if b, ok := doc.(bool); ok {
+		return &schemaNode{accept: &b}, nil
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return fail("a schema must be an object or a boolean")
+	}
+
+	n := &schemaNode{}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := obj[key]
+		sub := at + "/" + key
+		var err error
+		switch key {
+		case "type":
+			switch v := value.(type) {
+			case string:
+				n.types = []string{v}
+			case []interface{}:
+				for _, t := range v {
+					s, _ := t.(string)
+					n.types = append(n.types, s)
+				}
+			}
+			if len(n.types) == 0 {
+				return fail("type must be a type name or a list of them")
+			}
+			for _, t := range n.types {
+				if !schemaTypes[t] {
+					return fail("unknown type %q", t)
+				}
+			}
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return fail("enum must be a non-empty list")
+			}
+			n.enum = list
+		case "const":
+			n.constant, n.hasConst = value, true
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return fail("properties must be an object")
+			}
+			n.properties = map[string]*schemaNode{}
+			for name, p := range props {
+				if n.properties[name], err = compileSchemaNode(p, sub+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := value.([]interface{})
+			if !ok {
+				return fail("required must be a list of names")
+			}
+			for _, v := range list {
+				s, ok := v.(string)
+				if !ok {
+					return fail("required must be a list of names")
+				}
+				n.required = append(n.required, s)
+			}
+		case "additionalProperties":
+			n.additional, err = compileSchemaNode(value, sub)
+		case "items":
+			n.items, err = compileSchemaNode(value, sub)
+		case "not":
+			n.not, err = compileSchemaNode(value, sub)
+		case "allOf", "anyOf", "oneOf":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return fail("%s must be a non-empty list of schemas", key)
+			}
+			nodes := []*schemaNode{}
+			for i, v := range list {
+				node, err := compileSchemaNode(v, sub+"/"+strconv.Itoa(i))
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, node)
+			}
+			switch key {
+			case "allOf":
+				n.allOf = nodes
+			case "anyOf":
+				n.anyOf = nodes
+			default:
+				n.oneOf = nodes
+			}
+		case "minItems", "maxItems", "minLength", "maxLength":
+			f, ok := value.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return fail("%s must be a non-negative integer", key)
+			}
+			i := int(f)
+			switch key {
+			case "minItems":
+				n.minItems = &i
+			case "maxItems":
+				n.maxItems = &i
+			case "minLength":
+				n.minLength = &i
+			default:
+				n.maxLength = &i
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+			f, ok := value.(float64)
+			if !ok || key == "multipleOf" && f <= 0 {
+				return fail("%s must be a number", key)
+			}
+			switch key {
+			case "minimum":
+				n.minimum = &f
+			case "maximum":
+				n.maximum = &f
+			case "exclusiveMinimum":
+				n.exclusiveMin = &f
+			case "exclusiveMaximum":
+				n.exclusiveMax = &f
+			default:
+				n.multipleOf = &f
+			}
+		case "uniqueItems":
+			b, ok := value.(bool)
+			if !ok {
+				return fail("uniqueItems must be a boolean")
+			}
+			n.uniqueItems = b
+		case "pattern":
+			s, ok := value.(string)
+			if !ok {
+				return fail("pattern must be a string")
+			}
+			if n.pattern, err = regexp.Compile(s); err != nil {
+				return fail("pattern: %v", err)
+			}
+		default:
+			if !schemaAnnotations[key] {
+				return fail("unsupported keyword %q", key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// validate checks a submission body against the schema.
+func (s *submissionSchema) validate(body []byte) []schemaError {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return []schemaError{{Field: "", Message: "body is not valid JSON: " + err.Error()}}
+	}
+	if dec.More() {
+		return []schemaError{{Field: "", Message: "body holds more than one JSON value"}}
+	}
+	errs := []schemaError{}
+	s.root.check(doc, "", &errs)
+	if len(errs) > maxSchemaErrors {
+		errs = errs[:maxSchemaErrors]
+	}
+	return errs
+}
+
+// matches reports whether v passes n, without collecting why not.
+func (n *schemaNode) matches(v interface{}) bool {
+	errs := []schemaError{}
+	n.check(v, "", &errs)
+	return len(errs) == 0
+}
+
+func (n *schemaNode) check(v interface{}, at string, errs *[]schemaError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, schemaError{Field: at, Message: fmt.Sprintf(format, args...)})
+	}
+	if n.accept != nil {
+		if !*n.accept {
+			fail("is not allowed")
+		}
+		return
+	}
+
+	if len(n.types) > 0 && !hasSchemaType(v, n.types) {
+		fail("must be %s", strings.Join(n.types, " or "))
+		return
+	}
+	if n.enum != nil {
+		found := false
+		for _, e := range n.enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			fail("must be one of %s", schemaValues(n.enum))
+		}
+	}
+	if n.hasConst && !reflect.DeepEqual(n.constant, v) {
+		fail("must be %s", schemaValues([]interface{}{n.constant}))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range n.required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, schemaError{Field: at + "/" + pointerToken(name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := at + "/" + pointerToken(name)
+			if p, ok := n.properties[name]; ok {
+				p.check(v[name], field, errs)
+			} else if n.additional != nil {
+				n.additional.check(v[name], field, errs)
+			}
+		}
+	case []interface{}:
+		if n.minItems != nil && len(v) < *n.minItems {
+			fail("must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			fail("must have at most %d items", *n.maxItems)
+		}
+		if n.uniqueItems {
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						fail("items %d and %d are the same", j, i)
+					}
+				}
+			}
+		}
+		if n.items != nil {
+			for i, item := range v {
+				n.items.check(item, at+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if n.minLength != nil && length < *n.minLength {
+			fail("must be at least %d characters", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail("must be at most %d characters", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail("must match %s", n.pattern)
+		}
+	case float64:
+		if n.minimum != nil && v < *n.minimum {
+			fail("must be at least %v", *n.minimum)
+		}
+		if n.maximum != nil && v > *n.maximum {
+			fail("must be at most %v", *n.maximum)
+		}
+		if n.exclusiveMin != nil && v <= *n.exclusiveMin {
+			fail("must be more than %v", *n.exclusiveMin)
+		}
+		if n.exclusiveMax != nil && v >= *n.exclusiveMax {
+			fail("must be less than %v", *n.exclusiveMax)
+		}
+		if n.multipleOf != nil {
+			if q := v / *n.multipleOf; q != math.Trunc(q) {
+				fail("must be a multiple of %v", *n.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range n.allOf {
+		sub.check(v, at, errs)
+	}
+	if n.anyOf != nil {
+		matched := false
+		for _, sub := range n.anyOf {
+			matched = matched || sub.matches(v)
+		}
+		if !matched {
+			fail("must match at least one of the allowed schemas")
+		}
+	}
+	if n.oneOf != nil {
+		matched := 0
+		for _, sub := range n.oneOf {
+			if sub.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one of the allowed schemas, matches %d", matched)
+		}
+	}
+	if n.not != nil && n.not.matches(v) {
+		fail("must not match the excluded schema")
+	}
+}
+
+func hasSchemaType(v interface{}, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func schemaValues(values []interface{}) string {
+	parts := []string{}
+	for _, v := range values {
+		data, _ := json.Marshal(v)
+		parts = append(parts, string(data))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pointerToken escapes a property name for a JSON pointer (RFC 6901).
+func pointerToken(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
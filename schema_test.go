@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func compileTestSchema(t *testing.T, src string) *submissionSchema {
+	t.Helper()
+	s := &submissionSchema{raw: json.RawMessage(src)}
+	if err := s.compile("q"); err != nil {
+		t.Fatalf("compile %s: %v", src, err)
+	}
+	return s
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`[]`, `q: schema at "#": a schema must be an object or a boolean`},
+		{`{"type": "text"}`, `q: schema at "#": unknown type "text"`},
+		{`{"type": []}`, `q: schema at "#": type must be a type name or a list of them`},
+		{`{"enum": []}`, `q: schema at "#": enum must be a non-empty list`},
+		{`{"required": ["a", 1]}`, `q: schema at "#": required must be a list of names`},
+		{`{"properties": {"a": {"minLength": -1}}}`, `q: schema at "#/properties/a": minLength must be a non-negative integer`},
+		{`{"maxItems": 1.5}`, `q: schema at "#": maxItems must be a non-negative integer`},
+		{`{"multipleOf": 0}`, `q: schema at "#": multipleOf must be a number`},
+		{`{"anyOf": [true, 3]}`, `q: schema at "#/anyOf/1": a schema must be an object or a boolean`},
+		{`{"oneOf": []}`, `q: schema at "#": oneOf must be a non-empty list of schemas`},
+		{`{"uniqueItems": "yes"}`, `q: schema at "#": uniqueItems must be a boolean`},
+		{`{"pattern": "("}`, "q: schema at \"#\": pattern: error parsing regexp: missing closing ): `(`"},
+		{`{"$ref": "#/definitions/a"}`, `q: schema at "#": unsupported keyword "$ref"`},
+		{`{"type": "object"`, "q: schema: unexpected end of JSON input"},
+		{`"/nonexistent/schema.json"`, "q: schema: open /nonexistent/schema.json: no such file or directory"},
+	}
+	for _, test := range tests {
+		s := &submissionSchema{raw: json.RawMessage(test.src)}
+		if err := s.compile("q"); err == nil || err.Error() != test.err {
+			t.Errorf("compile %s: error %v, want %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		schema string
+		body   string
+		want   []schemaError
+	}{
+		{`true`, `{"a": 1}`, nil},
+		{`false`, `1`, []schemaError{{"", "is not allowed"}}},
+		{`{"title": "Job", "format": "x"}`, `"anything"`, nil},
+		{`{"type": "object"}`, `[]`, []schemaError{{"", "must be object"}}},
+		{`{"type": ["string", "null"]}`, `null`, nil},
+		{`{"type": ["string", "null"]}`, `1`, []schemaError{{"", "must be string or null"}}},
+		{`{"type": "integer"}`, `2.0`, nil},
+		{`{"type": "integer"}`, `2.5`, []schemaError{{"", "must be integer"}}},
+		{`{"enum": ["a", 1]}`, `"b"`, []schemaError{{"", `must be one of "a", 1`}}},
+		{`{"const": {"x": true}}`, `{"x": true}`, nil},
+		{`{"const": "x"}`, `"y"`, []schemaError{{"", `must be "x"`}}},
+		{
+			`{"type": "object", "required": ["name", "a/b"], "properties": {"name": {"type": "string"}}}`,
+			`{}`,
+			[]schemaError{{"/name", "is required"}, {"/a~1b", "is required"}},
+		},
+		{
+			`{"properties": {"n": {"minimum": 1}}, "additionalProperties": false}`,
+			`{"n": 0, "z": 1, "m~": 2}`,
+			[]schemaError{{"/m~0", "is not allowed"}, {"/n", "must be at least 1"}, {"/z", "is not allowed"}},
+		},
+		{`{"minItems": 2}`, `[1]`, []schemaError{{"", "must have at least 2 items"}}},
+		{`{"maxItems": 1}`, `[1, 2]`, []schemaError{{"", "must have at most 1 items"}}},
+		{`{"uniqueItems": true}`, `[1, {"a": 1}, {"a": 1}]`, []schemaError{{"", "items 1 and 2 are the same"}}},
+		{`{"items": {"type": "number"}}`, `[1, "x"]`, []schemaError{{"/1", "must be number"}}},
+		{`{"minLength": 2}`, `"é"`, []schemaError{{"", "must be at least 2 characters"}}},
+		{`{"maxLength": 2}`, `"ééé"`, []schemaError{{"", "must be at most 2 characters"}}},
+		{`{"pattern": "^[a-z]+$"}`, `"ab1"`, []schemaError{{"", "must match ^[a-z]+$"}}},
+		{`{"maximum": 10}`, `10.5`, []schemaError{{"", "must be at most 10"}}},
+		{`{"exclusiveMinimum": 0}`, `0`, []schemaError{{"", "must be more than 0"}}},
+		{`{"exclusiveMaximum": 1}`, `1`, []schemaError{{"", "must be less than 1"}}},
+		{`{"multipleOf": 0.5}`, `1.5`, nil},
+		{`{"multipleOf": 2}`, `3`, []schemaError{{"", "must be a multiple of 2"}}},
+		{`{"minLength": 3}`, `1`, nil},
+		{
+			`{"allOf": [{"minimum": 2}, {"maximum": 0}]}`,
+			`1`,
+			[]schemaError{{"", "must be at least 2"}, {"", "must be at most 0"}},
+		},
+		{`{"anyOf": [{"type": "string"}, {"minimum": 5}]}`, `6`, nil},
+		{`{"anyOf": [{"type": "string"}, {"minimum": 5}]}`, `4`, []schemaError{{"", "must match at least one of the allowed schemas"}}},
+		{`{"oneOf": [{"type": "number"}, {"minimum": 5}]}`, `4`, nil},
+		{`{"oneOf": [{"type": "number"}, {"minimum": 5}]}`, `6`, []schemaError{{"", "must match exactly one of the allowed schemas, matches 2"}}},
+		{`{"not": {"type": "null"}}`, `null`, []schemaError{{"", "must not match the excluded schema"}}},
+		{`{"type": "object"}`, `{"a": `, []schemaError{{"", "body is not valid JSON: unexpected EOF"}}},
+		{`{"type": "object"}`, `{} {}`, []schemaError{{"", "body holds more than one JSON value"}}},
+	}
+	for _, test := range tests {
+		s := compileTestSchema(t, test.schema)
+		got := s.validate([]byte(test.body))
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("validate %s against %s = %v, want %v", test.body, test.schema, got, test.want)
+		}
+	}
+}
+
+func TestSchemaValidateLimitsErrors(t *testing.T) {
+	s := compileTestSchema(t, `{"items": false}`)
+	body, _ := json.Marshal(make([]int, maxSchemaErrors+10))
+	if got := s.validate(body); len(got) != maxSchemaErrors {
+		t.Errorf("validate returned %d errors, want %d", len(got), maxSchemaErrors)
+	}
+}
+
+func TestSchemaFromFile(t *testing.T) {
+	file := t.TempDir() + "/schema.json"
+	if err := os.WriteFile(file, []byte(`{"required": ["id"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := json.Marshal(file)
+	s := compileTestSchema(t, string(quoted))
+	want := []schemaError{{"/id", "is required"}}
+	if got := s.validate([]byte(`{}`)); !reflect.DeepEqual(got, want) {
+		t.Errorf("validate = %v, want %v", got, want)
+	}
+	if data, _ := json.Marshal(s); string(data) != string(quoted) {
+		t.Errorf("marshal = %s, want %s", data, quoted)
+	}
+}
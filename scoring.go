@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type scoringConfig struct {
+	URL        string   `json:"url"`
+	Timeout    duration `json:"timeout"`
+	Prioritize bool     `json:"prioritize"`
+}
+
+type scoreResult struct {
+	Score  *float64 `json:"score"`
+	Labels []string `json:"labels"`
+}
+
+var scoringClient = &http.Client{}
+
+// scoreSubmission posts the content to the scoring service. The service
+// answers with {"score": 0.93, "labels": ["spam"]}; either may be absent.
+func scoreSubmission(queueName string, contentType string, body []byte) (scoreResult, error) {
+	result := scoreResult{}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.Scoring.URL, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if queueName != "" {
+		req.Header.Set("X-Job-Queue", queueName)
+	}
+
+	client := *scoringClient
+	client.Timeout = cfg.Scoring.Timeout.Duration
+	resp, err := client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return result, fmt.Errorf("%s returned %s", cfg.Scoring.URL, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return result, fmt.Errorf("decode score: %v", err)
+	}
+	return result, nil
+}
+
+// scoreOf returns the job's score for ordering, with unscored jobs last.
+func scoreOf(id int) (float64, bool) {
+	m, ok := metadata.get(id)
+	if !ok || m.Score == nil {
+		return 0, false
+	}
+	return *m.Score, true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Selection strategies decide which job a reviewer gets next. Random takes
+// whatever map iteration yields first, which spreads reviewers over the
+// queue. Ordered and seeded make selection reproducible for end-to-end
+// tests and bug reports: ordered always hands out the first job in
+// jobLess order, seeded shuffles with a fixed seed, so the same requests
+// against the same data get the same jobs. Weighted draws at random, but
+// favours jobs by score and by time waiting, so mostly the most important
+// jobs are handed out while the rest still get their turn.
+const (
+	selectRandom   = "random"
+	selectOrdered  = "ordered"
+	selectSeeded   = "seeded"
+	selectWeighted = "weighted"
+)
+
+// Whatever the strategy, jobs waiting in review longer than MaxWait are
+// handed out first; 0 turns aging off.
+type selectionConfig struct {
+	Strategy string           `json:"strategy"`
+	Seed     int64            `json:"seed"`
+	MaxWait  duration         `json:"max_wait"`
+	Weights  selectionWeights `json:"weights"`
+}
+
+// selectionWeights make up a job's weight for weighted selection:
+// Base + Priority * score + Age * hours waiting. A job with twice the
+// weight of another is twice as likely to be drawn first.
+type selectionWeights struct {
+	Base     float64 `json:"base"`
+	Priority float64 `json:"priority"`
+	Age      float64 `json:"age"`
+}
+
+// minWeight keeps jobs with no or a negative score in the draw.
+const minWeight = 1e-6
+
+type agedJob struct {
+	ID    int
+	Since time.Time
+}
+
+func (c *selectionConfig) validate() error {
+	switch c.Strategy {
+	case "":
+		c.Strategy = selectRandom
+	case selectRandom, selectOrdered, selectSeeded, selectWeighted:
+	default:
+		return fmt.Errorf("unknown selection.strategy: %q", c.Strategy)
+	}
+	w := c.Weights
+	if w.Base < 0 || w.Priority < 0 || w.Age < 0 {
+		return errors.New("selection.weights must not be negative")
+	}
+	if c.MaxWait.Duration < 0 {
+		return errors.New("selection.max_wait must not be negative")
+	}
+	return nil
+}
+
+func (c selectionConfig) aging() bool {
+	return c.MaxWait.Duration > 0
+}
+
+// overdue reports whether job id has waited for review longer than
+// selection.max_wait, and since when.
+func overdue(id int, now time.Time) (time.Time, bool) {
+	if !cfg.Selection.aging() {
+		return time.Time{}, false
+	}
+	m, ok := metadata.get(id)
+	if !ok || m.SubmittedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return m.SubmittedAt, now.Sub(m.SubmittedAt) > cfg.Selection.MaxWait.Duration
+}
+
+// selectionStrategy is the strategy picking jobs for userName: the
+// configured one where the selection flag is on, random elsewhere.
+func selectionStrategy(userName string) string {
+	if !flags.on(flagSelection, userName) {
+		return selectRandom
+	}
+	return cfg.Selection.Strategy
+}
+
+// exhaustive reports whether candidates must all be collected before
+// ranking, rather than taking the first few the maps yield.
+func exhaustive(strategy string) bool {
+	return strategy != selectRandom || cfg.Scoring.Prioritize
+}
+
+var seeded struct {
+	sync.Mutex
+	rand *rand.Rand
+}
+
+// withRand runs fn with the seeded source, or the shared one when no seed
+// is configured.
+func withRand(fn func(r *rand.Rand)) {
+	seeded.Lock()
+	defer seeded.Unlock()
+	if seeded.rand == nil {
+		seed := cfg.Selection.Seed
+		if seed == 0 && cfg.Selection.Strategy != selectSeeded {
+			seed = time.Now().UnixNano()
+		}
+		seeded.rand = rand.New(rand.NewSource(seed))
+	}
+	fn(seeded.rand)
+}
+
+// selectionWeight is the weight of job id at now.
+func selectionWeight(id int, now time.Time) float64 {
+	w := cfg.Selection.Weights
+	weight := w.Base
+	if m, ok := metadata.get(id); ok {
+		if m.Score != nil {
+			weight += w.Priority * *m.Score
+		}
+		if !m.SubmittedAt.IsZero() {
+			weight += w.Age * now.Sub(m.SubmittedAt).Hours()
+		}
+	}
+	return math.Max(weight, minWeight)
+}
+
+// weightedShuffle orders ids by a weighted draw without replacement: each
+// job gets the key log(u)/weight for uniform u, and the largest keys come
+// first (Efraimidis and Spirakis).
+func weightedShuffle(ids []int, now time.Time) {
+	keys := map[int]float64{}
+	withRand(func(r *rand.Rand) {
+		for _, id := range ids {
+			keys[id] = math.Log(1-r.Float64()) / selectionWeight(id, now)
+		}
+	})
+	sort.Slice(ids, func(i, j int) bool { return keys[ids[i]] > keys[ids[j]] })
+}
+
+// scoreBefore reports whether a has to come before b by score alone:
+// highest first, unscored last.
+func scoreBefore(a, b int) bool {
+	as, aok := scoreOf(a)
+	bs, bok := scoreOf(b)
+	if aok != bok {
+		return aok
+	}
+	return aok && as > bs
+}
+
+// jobLess is the order jobs are handed to reviewers in: by score when
+// scoring prioritizes, otherwise oldest first. Random selection has no
+// order of its own, so navigation falls back to submission order.
+func jobLess(a, b int) bool {
+	if cfg.Scoring.Prioritize && (scoreBefore(a, b) || scoreBefore(b, a)) {
+		return scoreBefore(a, b)
+	}
+	return a < b
+}
+
+// rankCandidates orders candidates for selection by strategy, best first.
+func rankCandidates(ids []int, strategy string, now time.Time) {
+	switch strategy {
+	case selectOrdered:
+		sort.Slice(ids, func(i, j int) bool { return jobLess(ids[i], ids[j]) })
+
+	case selectWeighted:
+		sort.Ints(ids)
+		weightedShuffle(ids, now)
+
+	case selectSeeded:
+		sort.Ints(ids)
+		withRand(func(r *rand.Rand) {
+			r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+		})
+		if cfg.Scoring.Prioritize {
+			sort.SliceStable(ids, func(i, j int) bool { return scoreBefore(ids[i], ids[j]) })
+		}
+
+	default:
+		if cfg.Scoring.Prioritize {
+			sort.Slice(ids, func(i, j int) bool { return jobLess(ids[i], ids[j]) })
+		}
+	}
+}
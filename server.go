@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 )
 
 const (
@@ -18,6 +25,7 @@ const (
 	viewPath   = "/view/"
 	acceptPath = "/accept/"
 	rejectPath = "/reject/"
+	rawPath    = "/raw/"
 	exitPath   = "/exit"
 )
 
@@ -28,16 +36,42 @@ const (
 
 const (
 	contentPath    = "data"
-	templatePath   = "tmpl/"
 	templateSuffix = ".html"
 	contentSuffix  = ".txt"
 	contentPrefix  = "comment-"
 )
 
 type Page struct {
-	Title string
-	Body  []byte
-	ID    string
+	Title       string
+	Body        []byte
+	Head        []byte
+	FrontMatter *frontMatter
+	Size        int64
+	Truncated   bool
+	ID          string
+	ContentType string
+	Format      string
+	HTML        string
+	Raw         bool
+	Attachments []attachment
+	Revision    int
+	ETag        string
+	Draft       *draft
+	Claim       *claim
+	ClaimHeld   bool
+	Escalation  *escalation
+	Appeal      *appeal
+	Rereview    *rereview
+	Queue       string
+	Submitter   string
+	Source      string
+	Fields      []fieldValue
+	Tags        []string
+	Score       string
+	Labels      []string
+	Duplicate   *jobMeta
+	Redacted    int
+	CanUnmask   bool
 }
 
 type syncMap struct {
@@ -46,38 +80,81 @@ type syncMap struct {
 }
 
 type msg struct {
-	id   int
-	dest string
+	id     int
+	dest   string
+	reason string
+	user   string
+	// checked is set when the pre-decision hook passed the decision before
+	// it was queued, so the update worker needn't run it again
+	checked bool
+	seq     uint64
+	raw     string
 }
 
-var dirs = []string{"review", "accept", "reject"}
-var updateChan = make(chan msg, 100)
+var dirs = []string{"review", "accept", "reject", "escalate", "appeal"}
+
+// updateChans feed the update workers, one per shard, so decisions on one
+// job are always applied in order by the same worker. They are created in
+// main, each with the configured capacity.
+var updateChans []chan msg
+
+var templateFuncs = template.FuncMap{
+	"url":         urlFor,
+	"asset":       assetURL,
+	"site":        func() site { return cfg.Site },
+	"maintenance": inMaintenance,
+	"lang":        func() string { return defaultLocale },
+	"reasons":     reasons.list,
+	"teams":       teams.names,
+	"queues":      func() []queueConfig { return cfg.Queues },
+	"role":        func() string { return roleAdmin },
+	"T": func(key string, args ...interface{}) string {
+		return translate(defaultLocale, key, args...)
+	},
+}
 
-var templates = template.Must(template.ParseFiles(
-	templatePath+editTemplate,
-	templatePath+viewTemplate,
-))
-var validPath = regexp.MustCompile("^/(accept|reject|view)/([0-9]+)$")
+var templates map[string]*template.Template
+var staticFS fs.FS
+var validPath = regexp.MustCompile("^/(accept|reject|escalate|view|raw|edit|revisions|diff|draft|next|prev|skip|appeal)/([0-9]+)$")
 
 var exit = make(chan struct{})
-var layout []syncMap
 
-func loadPage(id int, pageDir string) (*Page, error) {
-	index := getIndex("review")
+// defaultPageTitle heads jobs whose metadata and front matter give no title.
+const defaultPageTitle = "Job"
 
-	layout[index].RLock()
-	if !layout[index].idMap[id] {
+func loadPage(id int, pageDir string) (*Page, error) {
+	q := queueOf(id)
+	if !q.has(id, pageDir) {
 		return nil, fmt.Errorf("entry not present: %d", id)
 	}
-	layout[index].RUnlock()
 
-	name := strconv.Itoa(id)
-	file := path.Join(contentPath, pageDir, name)
-	body, err := os.ReadFile(file)
+	file, err := openBody(q.file(id, pageDir))
 	if err != nil {
 		return nil, err
 	}
-	return &Page{Title: "Job", Body: body, ID: name}, nil
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Pages show at most inline_body_bytes, the rest is streamed from /raw/
+	var src io.Reader = file
+	if m, ok := metadata.get(id); ok && m.ContentType != "" && !isTextContent(m.ContentType) {
+		src = io.LimitReader(file, sniffLen)
+	} else if cfg.InlineBodyBytes > 0 {
+		src = io.LimitReader(file, cfg.InlineBodyBytes)
+	}
+	body, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	name := strconv.Itoa(id)
+	return &Page{Title: defaultPageTitle, Body: body, Size: size, Truncated: int64(len(body)) < size, ID: name}, nil
 }
 
 func getJobID(rw http.ResponseWriter, r *http.Request) (string, error) {
@@ -89,81 +166,258 @@ func getJobID(rw http.ResponseWriter, r *http.Request) (string, error) {
 	return m[2], nil
 }
 
-func renderTemplate(rw http.ResponseWriter, tmpl string, p *Page) {
-	err := templates.ExecuteTemplate(rw, tmpl, p)
+func renderTemplate(rw http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	if buf, ok := executeTemplate(rw, r, tmpl, data); ok {
+		buf.WriteTo(rw)
+	}
+}
+
+// renderValidated renders like renderTemplate, answering 304 Not Modified
+// when the client already has the same page.
+func renderValidated(rw http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	if buf, ok := executeTemplate(rw, r, tmpl, data); ok {
+		writeValidated(rw, r, buf.Bytes())
+	}
+}
+
+// executeTemplate renders tmpl into a buffer, reporting failures to the
+// client itself. Those reports are plain text, as the error page is a
+// template too.
+func executeTemplate(rw http.ResponseWriter, r *http.Request, tmpl string, data interface{}) (*bytes.Buffer, bool) {
+	t := templates
+	if cfg.Dev {
+		var err error
+		t, err = loadTemplates()
+		if err != nil {
+			renderDevError(rw, "Template parse failed", err)
+			return nil, false
+		}
+	}
+
+	page, ok := t[tmpl]
+	if !ok {
+		warnf("Render failed: unknown template %s\n", tmpl)
+		http.Error(rw, tr(r, "template_missing"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	page, err := localizeTemplate(page, requestLocale(r))
 	if err != nil {
+		warnf("Render failed: %s [%v]\n", tmpl, err)
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return nil, false
 	}
+
+	page.Funcs(template.FuncMap{
+		"queues": func() []queueConfig { return visibleQueues(r) },
+		"role":   func() string { return currentUser(r).Role },
+	})
+
+	var buf bytes.Buffer
+	err = page.ExecuteTemplate(&buf, layoutTemplate, data)
+	if err != nil {
+		if cfg.Dev {
+			renderDevError(rw, "Template execution failed", err)
+			return nil, false
+		}
+		warnf("Render failed: %s [%v]\n", tmpl, err)
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return &buf, true
+}
+
+func renderDevError(rw http.ResponseWriter, title string, err error) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(rw, "<h1>%s</h1>\n<pre>%s</pre>\n", title, template.HTMLEscapeString(err.Error()))
 }
 
 func viewHandler(rw http.ResponseWriter, r *http.Request) {
 	title, err := getJobID(rw, r)
 	if err != nil {
-		fmt.Printf("Load failed: %v\n", err)
-		http.NotFound(rw, r)
+		warnf("Load failed: %v\n", err)
+		notFound(rw, r)
 		return
 	}
 
 	id, err := strconv.Atoi(title)
 	if err != nil {
-		fmt.Printf("Load failed: ID: %s [%v]\n", title, err)
-		http.NotFound(rw, r)
+		warnf("Load failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
+		return
+	}
+
+	state := findState(id)
+	if !canViewJob(rw, r, id, state) {
 		return
 	}
+	if state != "escalate" && state != "appeal" {
+		state = "review"
+	}
 
-	p, err := loadPage(id, "review")
+	p, err := loadPage(id, state)
 	if err != nil {
-		fmt.Printf("Load failed: ID: %d [%v]\n", id, err)
-		http.NotFound(rw, r)
+		warnf("Load failed: ID: %d [%v]\n", id, err)
+		notFound(rw, r)
+		return
+	}
+
+	preparePage(id, p, r)
+	rw.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		writeJSON(rw, http.StatusOK, newJobView(id, state, p))
 		return
 	}
+	renderValidated(rw, r, queueOf(id).viewTemplate(), p)
+}
 
-	renderTemplate(rw, viewTemplate, p)
+// canViewJob checks that the request's user may see job id, in state:
+// escalated jobs are for admins, appealed ones for seniors, and any job
+// routed to a team for its members. It answers 403 when not.
+func canViewJob(rw http.ResponseWriter, r *http.Request, id int, state string) bool {
+	switch state {
+	case "escalate":
+		if !requireAdmin(rw, r) {
+			return false
+		}
+	case "appeal":
+		if !isSenior(r) {
+			httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+			return false
+		}
+	}
+	if !canSeeJob(r, id) {
+		httpError(rw, r, tr(r, "forbidden"), http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
 func rootHandler(rw http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != rootPath {
-		http.NotFound(rw, r)
+		notFound(rw, r)
 		return
 	}
 	// TODO: Add functionality to list entries available to review
-	http.Redirect(rw, r, "/view/FrontPage", http.StatusFound)
+	q := queues[0]
+	http.Redirect(rw, r, q.reviewURL(getRandomId(q, currentUser(r).Name, -1)), http.StatusFound)
 }
 
 func acceptHandler(rw http.ResponseWriter, r *http.Request) {
-	title, err := getJobID(rw, r)
-	if err != nil {
-		fmt.Printf("Load failed: %v\n", err)
-		http.NotFound(rw, r)
-		return
-	}
+	decisionHandler(rw, r, "accept")
+}
 
-	id, err := strconv.Atoi(title)
-	if err != nil {
-		fmt.Printf("Load failed: ID: %s [%v]\n", title, err)
-		http.NotFound(rw, r)
-		return
+// claimCandidates is how many unclaimed jobs getRandomId tries before
+// giving up when each is claimed by another reviewer first.
+const claimCandidates = 20
+
+// getRandomId picks the next job for userName and claims it. The claim is
+// taken before the job is handed out, so concurrent requests, also on
+// other replicas sharing Redis claims, never get the same job: whoever
+// loses the claim moves on to the next candidate. Job except, one just
+// decided whose decision may not be applied yet, is never picked.
+func getRandomId(q *queue, userName string, except int) int {
+	// Jobs assigned to the reviewer come first
+	assigned := claims.assignedTo(userName)
+	sort.Slice(assigned, func(i, j int) bool { return jobLess(assigned[i], assigned[j]) })
+	for _, candidate := range assigned {
+		if candidate != except && q.has(candidate, "review") {
+			return candidate
+		}
 	}
 
-	updateChan <- msg{id, "accept"}
-	random := getRandomId()
-	newPath := "/view/" + strconv.Itoa(random)
-	http.Redirect(rw, r, newPath, http.StatusFound)
+	for _, candidate := range reviewCandidates(q, userName, time.Now()) {
+		if candidate == except {
+			continue
+		}
+		if _, held := claimForView(candidate, userName); held {
+			debugf("Random ID: %d\n", candidate)
+			return candidate
+		}
+	}
+	return -1
 }
 
-func getRandomId() int {
-	id := -1
+// reviewCandidates lists jobs in review that userName may claim, best
+// first as ranked by the selection strategy. Jobs waiting longer than
+// selection.max_wait come before all others, longest waiting first, so no
+// strategy can leave a job behind for good. Jobs the reviewer skipped come
+// after all others, longest skipped first, and snoozed jobs and jobs
+// deferred by a submitter quota not at all.
+func reviewCandidates(q *queue, userName string, now time.Time) []int {
+	ids := []int{}
+	aged := []agedJob{}
+	skipped := []skip{}
+	// One read of all claims rather than one per job, which matters when
+	// claims live in Redis
+	held := claims.active(now)
+	userSkips := skips.of(userName, now)
+	strategy := selectionStrategy(userName)
+	for _, sm := range q.shards("review") {
+		sm.RLock()
+		for candidate := range sm.idMap {
+			// Once enough candidates are found, only overdue jobs are
+			// still looked for
+			full := !exhaustive(strategy) && len(ids) == claimCandidates
+			if full && !cfg.Selection.aging() {
+				break
+			}
+			if c, ok := held[candidate]; ok && c.User != userName {
+				continue
+			}
+			if !inQueue(jobTeam(candidate), userName) || isDeferred(candidate) {
+				continue
+			}
+			if sk, ok := userSkips[candidate]; ok {
+				if !sk.snoozed(now) {
+					skipped = append(skipped, sk)
+				}
+				continue
+			}
+			if since, ok := overdue(candidate, now); ok {
+				aged = append(aged, agedJob{ID: candidate, Since: since})
+				continue
+			}
+			if !full {
+				ids = append(ids, candidate)
+			}
+		}
+		sm.RUnlock()
+	}
 
-	index := getIndex("review")
-	sm := &layout[index]
-	sm.RLock()
-	for id = range sm.idMap {
-		fmt.Printf("Random ID: %d\n", id)
-		break
+	rankCandidates(ids, strategy, now)
+	sort.Slice(aged, func(i, j int) bool {
+		if !aged[i].Since.Equal(aged[j].Since) {
+			return aged[i].Since.Before(aged[j].Since)
+		}
+		return aged[i].ID < aged[j].ID
+	})
+	ranked := make([]int, 0, len(aged)+len(ids)+len(skipped))
+	for _, job := range aged {
+		ranked = append(ranked, job.ID)
+	}
+	ids = append(ranked, ids...)
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].At.Before(skipped[j].At) })
+	for _, sk := range skipped {
+		ids = append(ids, sk.ID)
 	}
-	sm.RUnlock()
+	if len(ids) > claimCandidates {
+		ids = ids[:claimCandidates]
+	}
+	return ids
+}
 
-	return id
+// findState returns the directory currently holding the job, or "" if the
+// ID is unknown.
+func findState(id int) string {
+	q := queueOf(id)
+	for _, dir := range dirs {
+		if q.has(id, dir) {
+			return dir
+		}
+	}
+	return ""
 }
 
 func getIndex(path string) int {
@@ -175,64 +429,249 @@ func getIndex(path string) int {
 	return -1
 }
 
-func update() {
-	for {
-		// TODO: Add graceful handling
-		m := <-updateChan
+// updatesDone is closed once every update worker has drained its queue.
+var updatesDone = make(chan struct{})
 
-		index := getIndex("review")
-		sm := &layout[index]
-		sm.Lock()
-		if sm.idMap[m.id] {
-			sm.idMap[m.id] = false
-		} else {
-			continue
+// updateQueues is closed for shutdown once no more decisions can come in
+// from requests. Decisions the server takes itself after that stay in
+// data/pending, to be applied at the next start.
+var updateQueues struct {
+	sync.RWMutex
+	closed bool
+}
+
+// updatesPaused is held by the update workers while they apply an update;
+// taking it for writing pauses them between updates.
+var updatesPaused sync.RWMutex
+
+// updateRetryAfter is the delay in seconds suggested to clients when the
+// update queue is full.
+const updateRetryAfter = 5
+
+// updatesRefused counts decisions turned away because the queue was full.
+var updatesRefused int64
+
+// enqueueUpdate persists m and hands it to the update worker without
+// waiting, failing when the queue is full or m can't be saved.
+func enqueueUpdate(m msg) bool {
+	if redisDB != nil {
+		if !offerRedisUpdate(m) {
+			atomic.AddInt64(&updatesRefused, 1)
+			return false
 		}
-		sm.Unlock()
+		return true
+	}
+	updateQueues.RLock()
+	defer updateQueues.RUnlock()
+	if updateQueues.closed {
+		return false
+	}
+	ch := updateChanFor(m.id)
+	if len(ch) == cap(ch) {
+		atomic.AddInt64(&updatesRefused, 1)
+		return false
+	}
+	if err := persistUpdate(&m); err != nil {
+		warnf("Pending write failed: ID: %d [%v]\n", m.id, err)
+		return false
+	}
+	select {
+	case ch <- m:
+		return true
+	default:
+		completeUpdate(m)
+		atomic.AddInt64(&updatesRefused, 1)
+		return false
+	}
+}
+
+func updateQueueFull(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Retry-After", strconv.Itoa(updateRetryAfter))
+	writeError(rw, r, http.StatusServiceUnavailable, codeQueueFull, tr(r, "update_queue_full"))
+}
 
-		index = getIndex(m.dest)
-		sm = &layout[index]
-		sm.Lock()
-		if !sm.idMap[m.id] {
-			sm.idMap[m.id] = true
+func updateChanFor(id int) chan msg {
+	return updateChans[shardOf(id)]
+}
+
+// queuedUpdates is the number of decisions waiting in all shards.
+func queuedUpdates() int {
+	n := 0
+	for _, ch := range updateChans {
+		n += len(ch)
+	}
+	return n
+}
+
+func updateCapacity() int {
+	return cfg.UpdateQueue * cfg.Shards
+}
+
+// startUpdates runs one update worker per shard.
+func startUpdates() {
+	var workers sync.WaitGroup
+	for i := 0; i < cfg.Shards; i++ {
+		ch := make(chan msg, cfg.UpdateQueue)
+		updateChans = append(updateChans, ch)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			update(ch)
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(updatesDone)
+	}()
+}
+
+func closeUpdates() {
+	updateQueues.Lock()
+	defer updateQueues.Unlock()
+
+	updateQueues.closed = true
+	for _, ch := range updateChans {
+		close(ch)
+	}
+}
+
+func update(ch chan msg) {
+	for m := range ch {
+		updatesPaused.RLock()
+		if err := moveJob(m); err != nil {
+			warnf("Update failed: ID: %d -> %s [%v]\n", m.id, m.dest, err)
 		}
-		sm.Unlock()
+		completeUpdate(m)
+		updatesPaused.RUnlock()
+	}
+}
 
-		file := strconv.Itoa(m.id)
-		oldPath := path.Join(contentPath, "review", file)
-		newPath := path.Join(contentPath, m.dest, file)
-		err := os.Rename(oldPath, newPath)
-		if err != nil {
-			fmt.Printf("Move failed: %s -> %s [%v]\n", oldPath, newPath, err)
+// canMove tells whether a job may go from state src to dest. Jobs are
+// decided in review, once escalated or once appealed; only rejections can
+// be appealed.
+func canMove(src string, dest string) bool {
+	switch src {
+	case "review":
+		return dest == "accept" || dest == "reject" || dest == "escalate"
+	case "escalate", "appeal":
+		return dest == "accept" || dest == "reject"
+	case "reject":
+		return dest == "appeal"
+	}
+	return false
+}
+
+func moveJob(m msg) error {
+	if redisDB != nil {
+		// Another replica may have created or moved the job
+		refreshJob(m.id)
+	}
+	src := findState(m.id)
+	if !canMove(src, m.dest) {
+		return fmt.Errorf("entry not present: %d", m.id)
+	}
+	// Decisions the server takes itself meet the hook here
+	if !m.checked && m.dest != "appeal" {
+		d := decisionReport{ID: m.id, From: src, To: m.dest, Reason: m.reason, By: m.user}
+		if err := checkDecisionHook(d); err != nil {
+			if err := audit(auditEntry{Actor: m.user, Action: "hook_refused", ID: m.id, To: m.dest, Note: err.Error()}); err != nil {
+				warnf("Hook audit failed: %v\n", err)
+			}
+			return err
 		}
 	}
+
+	// Wait for an edit of the job to finish rewriting its body
+	editLock.RLock()
+	defer editLock.RUnlock()
+
+	q := queueOf(m.id)
+	sm := q.states(src, m.id)
+	sm.Lock()
+	if !sm.idMap[m.id] {
+		sm.Unlock()
+		return fmt.Errorf("entry not present: %d", m.id)
+	}
+	delete(sm.idMap, m.id)
+	sm.Unlock()
+
+	sm = q.states(m.dest, m.id)
+	sm.Lock()
+	sm.idMap[m.id] = true
+	sm.Unlock()
+
+	oldPath := q.file(m.id, src)
+	newPath := q.file(m.id, m.dest)
+	err := os.Rename(oldPath, newPath)
+	if os.IsNotExist(err) {
+		// Moved before a crash that kept the move out of the state log
+		if _, statErr := os.Stat(newPath); statErr == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("move %s -> %s: %v", oldPath, newPath, err)
+	}
+	recordState(stateEvent{Type: stateMove, ID: m.id, Queue: q.name, From: src, State: m.dest})
+
+	// The job no longer counts against its submitter's quota
+	if meta, ok := metadata.get(m.id); ok && cfg.Quota.limit(meta.Submitter) != 0 {
+		defer releaseDeferred(meta.Submitter)
+	}
+	switch m.dest {
+	case "escalate":
+		return recordEscalation(m)
+	case "appeal":
+		return recordAppeal(m)
+	}
+	return recordDecision(m)
 }
 
 func rejectHandler(rw http.ResponseWriter, r *http.Request) {
+	decisionHandler(rw, r, "reject")
+}
+
+// decisionHandler takes a decision from the review page, checked as API
+// decisions are, and sends the reviewer on to their next job.
+func decisionHandler(rw http.ResponseWriter, r *http.Request, dest string) {
 	title, err := getJobID(rw, r)
 	if err != nil {
-		fmt.Printf("Load failed: %v\n", err)
-		http.NotFound(rw, r)
+		warnf("Load failed: %v\n", err)
+		notFound(rw, r)
 		return
 	}
 
 	id, err := strconv.Atoi(title)
 	if err != nil {
-		fmt.Printf("Load failed: ID: %s [%v]\n", title, err)
-		http.NotFound(rw, r)
+		warnf("Load failed: ID: %s [%v]\n", title, err)
+		notFound(rw, r)
 		return
 	}
 
-	updateChan <- msg{id, "reject"}
+	report, status, failure := checkDecision(r, id, dest, decisionReason(r))
+	if failure != nil {
+		writeAPIError(rw, r, status, *failure)
+		return
+	}
+	if !enqueueUpdate(report.update()) {
+		updateQueueFull(rw, r)
+		return
+	}
 
-	random := getRandomId()
-	newPath := "/view/" + strconv.Itoa(random)
-	http.Redirect(rw, r, newPath, http.StatusFound)
+	http.Redirect(rw, r, afterDecision(r, id, report.From), http.StatusFound)
 }
 
-func exitHandler(rw http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(rw, "Terminating server...")
-	close(exit)
+func limitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if cfg.MaxBodyBytes > 0 {
+			if r.ContentLength > cfg.MaxBodyBytes {
+				httpError(rw, r, tr(r, "body_too_large"), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(rw, r.Body, cfg.MaxBodyBytes)
+		}
+		next.ServeHTTP(rw, r)
+	})
 }
 
 func getListOfFiles(path string) []int {
@@ -240,20 +679,20 @@ func getListOfFiles(path string) []int {
 
 	dir, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("Error to access %s: %v\n", path, err)
+		warnf("Error to access %s: %v\n", path, err)
 		return fileIDs
 	}
 
 	filenames, err := dir.Readdirnames(0)
 	if err != nil {
-		fmt.Printf("Error to read files: %v\n", err)
+		warnf("Error to read files: %v\n", err)
 		return fileIDs
 	}
 
 	for _, name := range filenames {
 		id, err := strconv.Atoi(name)
 		if err != nil || id == 0 {
-			fmt.Printf("Issue with conversion for filename : %s\n", name)
+			warnf("Issue with conversion for filename : %s\n", name)
 			continue
 		}
 		fileIDs = append(fileIDs, id)
@@ -262,39 +701,178 @@ func getListOfFiles(path string) []int {
 	return fileIDs
 }
 
-func initData() []syncMap {
-	smList := []syncMap{}
-
-	for _, dir := range dirs {
-		ids := getListOfFiles("data/" + dir)
-		m := make(map[int]bool)
-		for _, id := range ids {
-			m[id] = true
+func main() {
+	configFile := flag.String("config", "", "path to JSON config file")
+	addr := flag.String("addr", "", "listen address (overrides config)")
+	dev := flag.Bool("dev", false, "re-parse templates from disk on every request")
+	seed := flag.Int64("seed", 0, "select jobs deterministically with this seed (overrides config strategy)")
+	flag.Parse()
+
+	if *configFile != "" {
+		c, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg = c
+	}
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+	if *seed != 0 {
+		cfg.Selection.Strategy = selectSeeded
+		cfg.Selection.Seed = *seed
+	}
+	if *dev {
+		cfg.Dev = true
+	}
+	if cfg.LogLevel != "" {
+		logLevel, _ = parseLogLevel(cfg.LogLevel)
+	}
+	flags.load(cfg.Flags)
+	if cfg.LogFile != "" {
+		if err := logToFile(cfg.LogFile); err != nil {
+			log.Fatalf("Log file failed: %v", err)
 		}
-		smList = append(smList, syncMap{idMap: m})
 	}
 
-	return smList
-}
-
-func main() {
+	tmpl, err := loadTemplates()
+	if err != nil {
+		log.Fatalf("Template load failed: %v", err)
+	}
+	templates = tmpl
+	staticFS = assetFS("static", cfg.StaticDir)
 
-	layout = initData()
+	if err := metadata.load(); err != nil {
+		log.Fatalf("Metadata load failed: %v", err)
+	}
+	if err := reasons.load(); err != nil {
+		log.Fatalf("Reason load failed: %v", err)
+	}
+	if err := drafts.load(); err != nil {
+		log.Fatalf("Draft load failed: %v", err)
+	}
+	if err := skips.load(); err != nil {
+		log.Fatalf("Skip load failed: %v", err)
+	}
+	if err := teams.load(); err != nil {
+		log.Fatalf("Team load failed: %v", err)
+	}
+	if err := access.load(); err != nil {
+		log.Fatalf("Access load failed: %v", err)
+	}
+	if err := holds.load(); err != nil {
+		log.Fatalf("Hold load failed: %v", err)
+	}
+	if err := subscriptions.load(); err != nil {
+		log.Fatalf("Subscription load failed: %v", err)
+	}
+	initQueues()
+	switch flag.Arg(0) {
+	case "":
+	case "reindex":
+		runReindex()
+	case "export":
+		runExport(flag.Args()[1:])
+	case "migrate":
+		runMigrate(flag.Args()[1:])
+	default:
+		log.Fatalf("Unknown command: %s", flag.Arg(0))
+	}
+	for _, q := range queues {
+		if _, ok := templates[q.viewTemplate()]; !ok {
+			log.Fatalf("Queue %s: template not present: %s", q.name, q.viewTemplate())
+		}
+	}
+	if err := loadStates(); err != nil {
+		log.Fatalf("State load failed: %v", err)
+	}
+	oversized := ingestMissing()
+	releaseAllDeferred()
+	initObjects()
+	clearSpool()
+	clearBackups()
+	initLastID()
+	if cfg.Redis.enabled() {
+		if err := startRedis(); err != nil {
+			log.Fatalf("Redis failed: %v", err)
+		}
+	}
 
-	go update()
+	startUpdates()
+	if err := replayPending(); err != nil {
+		log.Fatalf("Pending replay failed: %v", err)
+	}
+	rejectOversized(oversized)
+	startReports()
+	startPolicies()
+	startReminders()
+	startInbox()
+	startTrackers()
+	startLogSink()
+	startEvents()
+	watchMaintenanceSignal()
 	http.HandleFunc(rootPath, rootHandler)
+	http.HandleFunc(staticPath, staticHandler)
 	http.HandleFunc(viewPath, viewHandler)
-	http.HandleFunc(acceptPath, acceptHandler)
-	http.HandleFunc(rejectPath, rejectHandler)
+	http.HandleFunc(queuePath, queueHandler)
+	http.HandleFunc(rawPath, rawHandler)
+	http.HandleFunc(editPath, editHandler)
+	http.HandleFunc(revisionsPath, revisionsHandler)
+	http.HandleFunc(diffPath, diffHandler)
+	http.HandleFunc(draftPath, draftHandler)
+	http.HandleFunc(draftsPath, draftsHandler)
+	http.HandleFunc(reasonsPath, reasonsHandler)
+	http.HandleFunc(assignmentsPath, assignmentsHandler)
+	http.HandleFunc(teamsPath, teamsHandler)
+	http.HandleFunc(accessPath, queueAccessHandler)
+	http.HandleFunc(statsPath, statsHandler)
+	http.HandleFunc(leaderboardPath, leaderboardHandler)
+	http.HandleFunc(reportsPath, reportsHandler)
+	http.HandleFunc(spreadsheetsPath, spreadsheetsHandler)
+	http.HandleFunc(attachmentPath, attachmentHandler)
+	registerAPI()
+	http.HandleFunc(acceptPath, idempotent(acceptHandler))
+	http.HandleFunc(rejectPath, idempotent(rejectHandler))
+	http.HandleFunc(escalatePath, idempotent(escalateHandler))
+	http.HandleFunc(escalationsPath, escalationsHandler)
+	http.HandleFunc(nextPath, nextHandler)
+	http.HandleFunc(skipPath, skipHandler)
+	http.HandleFunc(prevPath, prevHandler)
+	http.HandleFunc(donePath, doneHandler)
+	http.HandleFunc(portalPath, portalHandler)
+	http.HandleFunc(appealPath, appealHandler)
+	http.HandleFunc(appealsPath, appealsHandler)
+	http.HandleFunc(calendarPath, calendarHandler)
+	http.HandleFunc(maintenancePath, maintenanceToggleHandler)
+	http.HandleFunc(reindexPath, reindexHandler)
+	http.HandleFunc(backupPath, backupHandler)
+	http.HandleFunc(exportPath, exportHandler)
+	http.HandleFunc(logLevelPath, logLevelHandler)
+	http.HandleFunc(flagsPath, flagsHandler)
+	http.HandleFunc(metricsPath, metricsHandler)
 	http.HandleFunc(exitPath, exitHandler)
 
-	go func() {
-		log.Fatal(http.ListenAndServe(":8080", nil))
-	}()
+	srv := &http.Server{
+		Handler:           requestIDHandler(accessLogHandler(proxyHandler(formatHandler(requestMetricsHandler(compressHandler(limitHandler(localeHandler(authHandler(maintenanceHandler(accessHandler(http.DefaultServeMux))))))))))),
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
 
-	<-exit
-	// TODO: Need to improve termination logic
-	fmt.Println("Initiate graceful termination")
-	fmt.Println("Gracefully terminated")
+	listeners, err := getListeners(cfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(ln)
+	}
 
+	<-exit
+	shutdown(srv)
 }
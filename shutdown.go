@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	exitTemplate    = "exit.html"
+	exitTokenTTL    = 5 * time.Minute
+	shutdownTimeout = 30 * time.Second
+)
+
+// exitToken confirms a shutdown. It is handed out on the confirmation page
+// and accepted once, so a stray or cross-site POST can't stop the server.
+type exitToken struct {
+	sync.Mutex
+	value   string
+	expires time.Time
+}
+
+var pendingExit exitToken
+
+// exitOnce closes exit. A second token can be redeemed before the
+// listener stops, and must not close it again.
+var exitOnce sync.Once
+
+func (t *exitToken) issue(now time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.value = hex.EncodeToString(b)
+	t.expires = now.Add(exitTokenTTL)
+	return t.value, nil
+}
+
+func (t *exitToken) redeem(value string, now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	ok := t.value != "" && now.Before(t.expires) &&
+		subtle.ConstantTimeCompare([]byte(value), []byte(t.value)) == 1
+	if ok {
+		t.value = ""
+	}
+	return ok
+}
+
+func exitHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		token, err := pendingExit.issue(time.Now())
+		if err != nil {
+			httpError(rw, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		renderTemplate(rw, r, exitTemplate, token)
+	case http.MethodPost:
+		if !pendingExit.redeem(r.FormValue("token"), time.Now()) {
+			httpError(rw, r, tr(r, "invalid_exit_token"), http.StatusForbidden)
+			return
+		}
+		if err := audit(auditEntry{Actor: currentUser(r).Name, Action: "shutdown"}); err != nil {
+			warnf("Shutdown audit failed: %v\n", err)
+		}
+		fmt.Fprint(rw, tr(r, "terminating"))
+		exitOnce.Do(func() { close(exit) })
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// shutdown stops accepting requests, lets running ones finish and then
+// applies the decisions still queued for the update worker.
+func shutdown(srv *http.Server) {
+	infof("Initiate graceful termination\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		warnf("Shutdown failed: %v\n", err)
+	}
+
+	// Only request handlers queue updates, and none are left running
+	if redisDB != nil {
+		stopFeed()
+	}
+	closeUpdates()
+	select {
+	case <-updatesDone:
+	case <-ctx.Done():
+		infof("Shutdown: %d updates left pending for the next start\n", queuedUpdates())
+	}
+	if isLeader() {
+		if err := takeSnapshot(); err != nil {
+			warnf("State snapshot failed: %v\n", err)
+		}
+	}
+	resign()
+
+	infof("Gracefully terminated\n")
+}
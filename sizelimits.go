@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+)
+
+// sizeLimitError turns away a job body or attachment larger than its
+// configured cap.
+type sizeLimitError struct {
+	what  string
+	size  int64
+	limit int64
+}
+
+func (e *sizeLimitError) Error() string {
+	if e.size > e.limit {
+		return fmt.Sprintf("%s is %d bytes, the limit is %d", e.what, e.size, e.limit)
+	}
+	return fmt.Sprintf("%s is over the limit of %d bytes", e.what, e.limit)
+}
+
+// requestTooLarge reports whether err came from reading past
+// max_body_bytes. The error http.MaxBytesReader returns isn't exported
+// before Go 1.19, so it is told by its text.
+func requestTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+// writeTooLarge answers 413 when err is about a size limit, and reports
+// whether it did.
+func writeTooLarge(rw http.ResponseWriter, r *http.Request, err error) bool {
+	var limit *sizeLimitError
+	switch {
+	case errors.As(err, &limit):
+		writeError(rw, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge, err.Error())
+	case requestTooLarge(err):
+		writeError(rw, r, http.StatusRequestEntityTooLarge, codeBodyTooLarge,
+			fmt.Sprintf("request is over the limit of %d bytes", cfg.MaxBodyBytes))
+	default:
+		return false
+	}
+	return true
+}
+
+func checkJobSize(size int64) error {
+	if cfg.MaxJobBytes > 0 && size > cfg.MaxJobBytes {
+		return &sizeLimitError{what: "job body", size: size, limit: cfg.MaxJobBytes}
+	}
+	return nil
+}
+
+// checkAttachmentSizes checks the attachments of form before any of them
+// is saved.
+func checkAttachmentSizes(form *multipart.Form) error {
+	if form == nil || cfg.MaxAttachmentBytes == 0 {
+		return nil
+	}
+	for _, header := range form.File["attachment"] {
+		if header.Size > cfg.MaxAttachmentBytes {
+			return &sizeLimitError{what: "attachment " + strconv.Quote(header.Filename), size: header.Size, limit: cfg.MaxAttachmentBytes}
+		}
+	}
+	return nil
+}
+
+// rejectOversized rejects the jobs over max_job_bytes that the ingestion
+// scan found on disk. The update workers must already be running.
+func rejectOversized(ids []int) {
+	for _, id := range ids {
+		reason := fmt.Sprintf("Larger than the limit of %d bytes.", cfg.MaxJobBytes)
+		if err := audit(auditEntry{Actor: policyUser, Action: "size_reject", ID: id, To: "reject", Note: strconv.FormatInt(cfg.MaxJobBytes, 10)}); err != nil {
+			warnf("Audit failed: ID: %d [%v]\n", id, err)
+		}
+		queueUpdate(msg{id: id, dest: "reject", reason: reason, user: policyUser})
+	}
+	if len(ids) > 0 {
+		warnf("Rejected %d oversized jobs found on disk\n", len(ids))
+	}
+}
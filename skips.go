@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	skipPath = "/skip/"
+	skipsDir = "skips"
+)
+
+// skip puts a job aside for one reviewer. A skipped job goes to the back
+// of their rotation, a snoozed one is not offered to them again until the
+// snooze ends. Other reviewers are not affected.
+type skip struct {
+	ID    int        `json:"id"`
+	At    time.Time  `json:"at"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// skipStore keeps skips per reviewer, one file each like drafts.
+type skipStore struct {
+	sync.Mutex
+	byUser map[string]map[int]skip
+}
+
+var skips = skipStore{byUser: map[string]map[int]skip{}}
+
+func (s skip) snoozed(now time.Time) bool {
+	return s.Until != nil && now.Before(*s.Until)
+}
+
+// expired skips are dropped: a skip once its job has left review, a
+// snooze once it has ended.
+func (s skip) expired(now time.Time) bool {
+	return findState(s.ID) != "review" || (s.Until != nil && !s.snoozed(now))
+}
+
+func skipFile(userName string) string {
+	return path.Join(contentPath, skipsDir, hex.EncodeToString([]byte(userName))+metaSuffix)
+}
+
+func (s *skipStore) load() error {
+	dir := path.Join(contentPath, skipsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for _, entry := range entries {
+		name, err := hex.DecodeString(strings.TrimSuffix(entry.Name(), metaSuffix))
+		if err != nil || !strings.HasSuffix(entry.Name(), metaSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			warnf("Skip read failed: %s [%v]\n", entry.Name(), err)
+			continue
+		}
+		list := []skip{}
+		if err := json.Unmarshal(data, &list); err != nil {
+			warnf("Skip parse failed: %s [%v]\n", entry.Name(), err)
+			continue
+		}
+		userSkips := map[int]skip{}
+		for _, sk := range list {
+			userSkips[sk.ID] = sk
+		}
+		s.byUser[string(name)] = userSkips
+	}
+
+	return nil
+}
+
+// persist must be called with the lock held. Expired skips are dropped on
+// the way.
+func (s *skipStore) persist(userName string, now time.Time) error {
+	list := []skip{}
+	for id, sk := range s.byUser[userName] {
+		if sk.expired(now) {
+			delete(s.byUser[userName], id)
+			continue
+		}
+		list = append(list, sk)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(skipFile(userName), data)
+}
+
+// of returns the skips of userName still in force.
+func (s *skipStore) of(userName string, now time.Time) map[int]skip {
+	s.Lock()
+	defer s.Unlock()
+
+	active := map[int]skip{}
+	for id, sk := range s.byUser[userName] {
+		if sk.Until == nil || sk.snoozed(now) {
+			active[id] = sk
+		}
+	}
+	return active
+}
+
+func (s *skipStore) add(userName string, sk skip) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.byUser[userName] == nil {
+		s.byUser[userName] = map[int]skip{}
+	}
+	s.byUser[userName][sk.ID] = sk
+	return s.persist(userName, sk.At)
+}
+
+// removeJob drops every reviewer's skip of a job.
+func (s *skipStore) removeJob(id int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for userName, userSkips := range s.byUser {
+		if _, ok := userSkips[id]; !ok {
+			continue
+		}
+		delete(userSkips, id)
+		if err := s.persist(userName, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSnooze reads how long to snooze for: a duration such as "4h", or
+// a time from a datetime-local input or in RFC 3339. Empty means skip.
+func parseSnooze(value string, now time.Time) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	}
+	if err != nil {
+		d, durErr := time.ParseDuration(value)
+		if durErr != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid snooze: %s", value)
+		}
+		t = now.Add(d)
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// skipHandler snoozes a job for the reviewer until "until", or skips it
+// when that is missing or "skip" is set, and moves on to their next job.
+// The reviewer's claim is released so others can take the job meanwhile.
+func skipHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	title, err := getJobID(rw, r)
+	if err != nil {
+		warnf("Skip failed: %v\n", err)
+		notFound(rw, r)
+		return
+	}
+
+	id, err := strconv.Atoi(title)
+	if err != nil || findState(id) != "review" {
+		warnf("Skip failed: ID: %s [entry not present]\n", title)
+		notFound(rw, r)
+		return
+	}
+
+	now := time.Now().UTC()
+	value := r.FormValue("until")
+	if r.FormValue("skip") != "" {
+		value = ""
+	}
+	until, err := parseSnooze(value, now)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userName := currentUser(r).Name
+	if err := skips.add(userName, skip{ID: id, At: now, Until: until}); err != nil {
+		warnf("Skip failed: ID: %d [%v]\n", id, err)
+		httpError(rw, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if c, ok := claims.get(id, now); ok && c.User == userName && !c.Assigned {
+		claims.release(id)
+	}
+	http.Redirect(rw, r, afterDecision(r, id, "review"), http.StatusSeeOther)
+}
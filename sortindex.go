@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Orders the list API can sort jobs by. Each has a sortIndex in the
+// metadata store.
+const (
+	sortByID          = "id"
+	sortBySubmittedAt = "submitted_at"
+	sortByPriority    = "priority"
+)
+
+// sortKeys turn a job's metadata into a key that orders the same way as
+// the field it is named after. Ties are broken by ID.
+var sortKeys = map[string]func(m *jobMeta) uint64{
+	sortByID: func(m *jobMeta) uint64 {
+		return uint64(m.ID)
+	},
+	sortBySubmittedAt: func(m *jobMeta) uint64 {
+		return uint64(m.SubmittedAt.UnixNano()) ^ 1<<63
+	},
+	sortByPriority: func(m *jobMeta) uint64 {
+		if m.Score == nil {
+			return 0
+		}
+		return floatKey(*m.Score)
+	},
+}
+
+// floatKey maps f to a uint64 that compares as f does. Flipping the sign
+// bit of positive numbers and all bits of negative ones puts them in
+// order; nothing maps to 0, which is left for jobs without a score.
+func floatKey(f float64) uint64 {
+	b := math.Float64bits(f)
+	if b>>63 == 1 {
+		return ^b
+	}
+	return b | 1<<63
+}
+
+type sortEntry struct {
+	Key uint64
+	ID  int
+}
+
+func (e sortEntry) less(o sortEntry) bool {
+	return e.Key < o.Key || e.Key == o.Key && e.ID < o.ID
+}
+
+// sortIndex keeps the jobs in the order of one sort key, so a page of the
+// list costs a binary search and a walk rather than sorting every job.
+type sortIndex struct {
+	key     func(m *jobMeta) uint64
+	entries []sortEntry
+	keys    map[int]uint64
+}
+
+func newSortIndexes() map[string]*sortIndex {
+	indexes := map[string]*sortIndex{}
+	for name, key := range sortKeys {
+		indexes[name] = &sortIndex{key: key, keys: map[int]uint64{}}
+	}
+	return indexes
+}
+
+// search returns the position of the first entry not before e.
+func (x *sortIndex) search(e sortEntry) int {
+	return sort.Search(len(x.entries), func(i int) bool {
+		return !x.entries[i].less(e)
+	})
+}
+
+func (x *sortIndex) put(m *jobMeta) {
+	key := x.key(m)
+	if old, ok := x.keys[m.ID]; ok {
+		if old == key {
+			return
+		}
+		x.remove(m.ID)
+	}
+	e := sortEntry{key, m.ID}
+	i := x.search(e)
+	x.entries = append(x.entries, sortEntry{})
+	copy(x.entries[i+1:], x.entries[i:])
+	x.entries[i] = e
+	x.keys[m.ID] = key
+}
+
+func (x *sortIndex) remove(id int) {
+	key, ok := x.keys[id]
+	if !ok {
+		return
+	}
+	i := x.search(sortEntry{key, id})
+	x.entries = append(x.entries[:i], x.entries[i+1:]...)
+	delete(x.keys, id)
+}
+
+// scanChunk is how many entries scan copies out per turn of the lock.
+const scanChunk = 256
+
+// scan calls fn on the entries of index name in order, or in reverse when
+// desc is set, starting after from if given, until fn returns false. The
+// store is not locked while fn runs, so fn may use it.
+func (s *metaStore) scan(name string, from *sortEntry, desc bool, fn func(e sortEntry) bool) {
+	chunk := make([]sortEntry, 0, scanChunk)
+	for {
+		chunk = chunk[:0]
+		s.RLock()
+		x := s.sorted[name]
+		if desc {
+			i := len(x.entries)
+			if from != nil {
+				i = x.search(*from)
+			}
+			for i--; i >= 0 && len(chunk) < scanChunk; i-- {
+				chunk = append(chunk, x.entries[i])
+			}
+		} else {
+			i := 0
+			if from != nil {
+				i = x.search(*from)
+				if i < len(x.entries) && x.entries[i] == *from {
+					i++
+				}
+			}
+			for ; i < len(x.entries) && len(chunk) < scanChunk; i++ {
+				chunk = append(chunk, x.entries[i])
+			}
+		}
+		s.RUnlock()
+
+		for _, e := range chunk {
+			if !fn(e) {
+				return
+			}
+		}
+		if len(chunk) < scanChunk {
+			return
+		}
+		last := chunk[len(chunk)-1]
+		from = &last
+	}
+}
+
+// rebuild sorts all entries into fresh indexes at once, which beats
+// inserting them one by one. The caller holds the lock.
+func (s *metaStore) rebuild() {
+	s.sorted = newSortIndexes()
+	for _, x := range s.sorted {
+		x.entries = make([]sortEntry, 0, len(s.entries))
+		for id, m := range s.entries {
+			key := x.key(m)
+			x.entries = append(x.entries, sortEntry{key, id})
+			x.keys[id] = key
+		}
+		sort.Slice(x.entries, func(i, j int) bool {
+			return x.entries[i].less(x.entries[j])
+		})
+	}
+}
+
+// index brings the sort indexes up to date with m. The caller holds the
+// lock.
+func (s *metaStore) index(m *jobMeta) {
+	for _, x := range s.sorted {
+		x.put(m)
+	}
+}
+
+// unindex drops id from the sort indexes. The caller holds the lock.
+func (s *metaStore) unindex(id int) {
+	for _, x := range s.sorted {
+		x.remove(id)
+	}
+}
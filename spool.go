@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+)
+
+const spoolDir = "spool"
+
+// spooledBody is a submission copied to disk as it arrives, so a large
+// body is never held in memory whole. It is hashed on the way in and, on
+// store, moved into object storage rather than copied again.
+type spooledBody struct {
+	name string
+	size int64
+	hash string
+	head []byte
+}
+
+// spoolBody copies src to the spool, failing with a sizeLimitError once it
+// runs past max_job_bytes.
+func spoolBody(src io.Reader) (*spooledBody, error) {
+	dir := path.Join(contentPath, spoolDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, "upload-")
+	if err != nil {
+		return nil, err
+	}
+	b := &spooledBody{name: file.Name()}
+
+	hash := sha256.New()
+	if cfg.MaxJobBytes > 0 {
+		src = io.LimitReader(src, cfg.MaxJobBytes+1)
+	}
+	b.size, err = io.Copy(io.MultiWriter(file, hash), src)
+	if err == nil && b.size > cfg.MaxJobBytes && cfg.MaxJobBytes > 0 {
+		err = &sizeLimitError{what: "job body", limit: cfg.MaxJobBytes}
+	}
+	if err == nil {
+		b.head = make([]byte, sniffLen)
+		var n int
+		n, err = file.ReadAt(b.head, 0)
+		if err == io.EOF {
+			err = nil
+		}
+		b.head = b.head[:n]
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	b.hash = hex.EncodeToString(hash.Sum(nil))
+	return b, nil
+}
+
+// bytes reads the whole body, for the checks that look at the content.
+func (b *spooledBody) bytes() ([]byte, error) {
+	return os.ReadFile(b.name)
+}
+
+// store places the body at file. Plain bodies take the spool file itself
+// as their object; encrypted ones are sealed in memory, as sealing needs
+// the whole body.
+func (b *spooledBody) store(file string) error {
+	if bodyKey != nil {
+		body, err := b.bytes()
+		if err != nil {
+			return err
+		}
+		_, err = storeBody(file, body)
+		return err
+	}
+
+	object := objectFile(b.hash)
+	if sealed, err := fileSealed(object); err != nil || sealed {
+		if err := os.MkdirAll(path.Dir(object), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(b.name, object); err != nil {
+			return err
+		}
+	}
+
+	tmp := file + ".tmp"
+	os.Remove(tmp)
+	if err := os.Link(object, tmp); err != nil {
+		return b.copyTo(object, file)
+	}
+	return os.Rename(tmp, file)
+}
+
+// copyTo streams the stored object to file where hard links are not
+// supported.
+func (b *spooledBody) copyTo(object string, file string) error {
+	src, err := os.Open(object)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file + ".tmp")
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// close removes the spool file, if it wasn't moved into storage.
+func (b *spooledBody) close() {
+	os.Remove(b.name)
+}
+
+// eraseSpooled removes spooled uploads whose content hash is in hashes.
+// Uploads only stay in the spool while their request runs, so this is for
+// bodies of erased jobs being sent again at the time.
+func eraseSpooled(hashes map[string]bool) error {
+	dir := path.Join(contentPath, spoolDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil || !hashes[hex.EncodeToString(hash.Sum(nil))] {
+			continue
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearSpool removes uploads left behind by a crash.
+func clearSpool() {
+	if err := os.RemoveAll(path.Join(contentPath, spoolDir)); err != nil {
+		warnf("Spool cleanup failed: %v\n", err)
+	}
+}
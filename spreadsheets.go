@@ -0,0 +1,400 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	spreadsheetsPath = "/reports/"
+	xlsxSheetLimit   = 31
+)
+
+// sheetWriter writes a table row by row straight to the response, so a
+// download never has to fit in memory.
+type sheetWriter interface {
+	row(cells ...interface{}) error
+	close() error
+}
+
+// csvSheet writes a table as CSV.
+type csvSheet struct {
+	w *csv.Writer
+}
+
+// xlsxSheet writes a table as a single-sheet XLSX workbook. The zip is
+// written as it goes; only the worksheet part grows with the table.
+type xlsxSheet struct {
+	zw    *zip.Writer
+	sheet io.Writer
+}
+
+// spreadsheet is one of the downloads: a table over the decisions taken
+// in a date range.
+type spreadsheet struct {
+	Name  string
+	Title string
+	write func(sw sheetWriter, r *http.Request, decided []jobMeta) error
+}
+
+// decidedStates are the decisions counted in the summary tables.
+var decidedStates = []string{"accept", "reject"}
+
+var spreadsheets = []spreadsheet{
+	{Name: "reviewers", Title: "report_reviewers", write: writeByReviewer},
+	{Name: "weeks", Title: "report_weeks", write: writeByWeek},
+	{Name: "reasons", Title: "report_reasons", write: writeByReason},
+	{Name: "decisions", Title: "report_decisions", write: writeDecisions},
+}
+
+func cellString(cell interface{}) string {
+	switch v := cell.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(cell)
+}
+
+func newCSVSheet(w io.Writer) *csvSheet {
+	return &csvSheet{w: csv.NewWriter(w)}
+}
+
+func (s *csvSheet) row(cells ...interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		v := cellString(cell)
+		if _, ok := cell.(string); ok && v != "" && strings.ContainsAny(v[:1], "=+-@\t\r") {
+			// Keep reasons typed by reviewers from running as formulas,
+			// including ones hidden behind a leading tab or carriage return
+			v = "'" + v
+		}
+		record[i] = v
+	}
+	return s.w.Write(record)
+}
+
+func (s *csvSheet) close() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// sheetName shortens name to what Excel accepts as a sheet name.
+func sheetName(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, c) {
+			return '_'
+		}
+		return c
+	}, name)
+	for utf8.RuneCountInString(name) > xlsxSheetLimit {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func newXLSXSheet(w io.Writer, name string) (*xlsxSheet, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, data string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(sheetName(name)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.data); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, xlsxSheetStart); err != nil {
+		return nil, err
+	}
+	return &xlsxSheet{zw: zw, sheet: sheet}, nil
+}
+
+func (s *xlsxSheet) row(cells ...interface{}) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for _, cell := range cells {
+		switch cell.(type) {
+		case int, float64:
+			b.WriteString("<c><v>" + cellString(cell) + "</v></c>")
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + xmlEscape(cellString(cell)) + "</t></is></c>")
+		}
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(s.sheet, b.String())
+	return err
+}
+
+func (s *xlsxSheet) close() error {
+	if _, err := io.WriteString(s.sheet, xlsxSheetEnd); err != nil {
+		return err
+	}
+	return s.zw.Close()
+}
+
+// reasonCategory files a decision reason under the label of the canned
+// reason it was taken from; anything typed by hand is "other".
+func reasonCategory(r *http.Request, reason string, canned []cannedReason) string {
+	if reason == "" {
+		return tr(r, "reason_none")
+	}
+	for _, c := range canned {
+		if c.Text == reason {
+			return c.Label
+		}
+	}
+	return tr(r, "reason_other")
+}
+
+// stateCounts is a row of decisions per state, in decidedStates order
+// followed by the total.
+type stateCounts struct {
+	key     string
+	byState map[string]int
+	total   int
+}
+
+func (c *stateCounts) add(state string) {
+	if c.byState == nil {
+		c.byState = map[string]int{}
+	}
+	c.byState[state]++
+	c.total++
+}
+
+func (c *stateCounts) cells() []interface{} {
+	cells := []interface{}{}
+	for _, state := range decidedStates {
+		cells = append(cells, c.byState[state])
+	}
+	return append(cells, c.total)
+}
+
+func stateHeader(r *http.Request, first ...interface{}) []interface{} {
+	for _, state := range decidedStates {
+		first = append(first, tr(r, state+"_state"))
+	}
+	return append(first, tr(r, "decisions"))
+}
+
+// countBy groups decisions by key, sorted by key.
+func countBy(decided []jobMeta, key func(m jobMeta) string) []*stateCounts {
+	groups := map[string]*stateCounts{}
+	for _, m := range decided {
+		k := key(m)
+		if groups[k] == nil {
+			groups[k] = &stateCounts{key: k}
+		}
+		groups[k].add(m.Decision.State)
+	}
+	list := []*stateCounts{}
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key < list[j].key
+	})
+	return list
+}
+
+func writeByReviewer(sw sheetWriter, r *http.Request, decided []jobMeta) error {
+	if err := sw.row(stateHeader(r, tr(r, "reviewer"))...); err != nil {
+		return err
+	}
+	for _, c := range countBy(decided, func(m jobMeta) string { return m.Decision.By }) {
+		if err := sw.row(append([]interface{}{c.key}, c.cells()...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// weekOf is the Monday starting the ISO week t falls in.
+func weekOf(t time.Time) string {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset).Format(dayFormat)
+}
+
+func writeByWeek(sw sheetWriter, r *http.Request, decided []jobMeta) error {
+	if err := sw.row(stateHeader(r, tr(r, "week"), tr(r, "week_start"))...); err != nil {
+		return err
+	}
+	for _, c := range countBy(decided, func(m jobMeta) string { return weekOf(m.Decision.At) }) {
+		start, _ := time.Parse(dayFormat, c.key)
+		year, week := start.ISOWeek()
+		if err := sw.row(append([]interface{}{fmt.Sprintf("%d-W%02d", year, week), c.key}, c.cells()...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeByReason(sw sheetWriter, r *http.Request, decided []jobMeta) error {
+	canned := reasons.list()
+	if err := sw.row(stateHeader(r, tr(r, "reason_category"))...); err != nil {
+		return err
+	}
+	for _, c := range countBy(decided, func(m jobMeta) string { return reasonCategory(r, m.Decision.Reason, canned) }) {
+		if err := sw.row(append([]interface{}{c.key}, c.cells()...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDecisions(sw sheetWriter, r *http.Request, decided []jobMeta) error {
+	canned := reasons.list()
+	header := []interface{}{"ID", tr(r, "queue"), tr(r, "team"), tr(r, "submitted_at"), tr(r, "decided_at"), tr(r, "decision"), tr(r, "reviewer"), tr(r, "reason_category"), tr(r, "reason")}
+	if err := sw.row(header...); err != nil {
+		return err
+	}
+	for _, m := range decided {
+		d := m.Decision
+		row := []interface{}{m.ID, m.Queue, m.Team, m.SubmittedAt.Format(time.RFC3339), d.At.Format(time.RFC3339), tr(r, d.State+"_state"), d.By, reasonCategory(r, d.Reason, canned), d.Reason}
+		if err := sw.row(row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decidedIn lists the jobs of visible decided between from and to, oldest
+// decision first, optionally only those routed to team.
+func decidedIn(from, to time.Time, team string, visible []*queue) []jobMeta {
+	decided := []jobMeta{}
+	metadata.RLock()
+	for _, m := range metadata.entries {
+		d := m.Decision
+		if d == nil || !inRange(d.At, from, to) || (team != "" && m.Team != team) || !inQueues(m.Queue, visible) {
+			continue
+		}
+		dec := *d
+		decided = append(decided, jobMeta{ID: m.ID, Queue: m.Queue, Team: m.Team, SubmittedAt: m.SubmittedAt, Decision: &dec})
+	}
+	metadata.RUnlock()
+
+	sort.Slice(decided, func(i, j int) bool {
+		if !decided[i].Decision.At.Equal(decided[j].Decision.At) {
+			return decided[i].Decision.At.Before(decided[j].Decision.At)
+		}
+		return decided[i].ID < decided[j].ID
+	})
+	return decided
+}
+
+// spreadsheetsHandler serves /reports/<name>.csv and /reports/<name>.xlsx
+// over the decisions in the from/to range the statistics page uses.
+func spreadsheetsHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file := strings.TrimPrefix(r.URL.Path, spreadsheetsPath)
+	ext := path.Ext(file)
+	var report *spreadsheet
+	for i := range spreadsheets {
+		if spreadsheets[i].Name == strings.TrimSuffix(file, ext) {
+			report = &spreadsheets[i]
+		}
+	}
+	if report == nil || (ext != ".csv" && ext != ".xlsx") {
+		notFound(rw, r)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	team, ok := requestTeam(rw, r)
+	if !ok {
+		return
+	}
+	decided := decidedIn(from, to, team, accessibleQueues(r))
+
+	name := "decisions-by-" + report.Name
+	if report.Name == "decisions" {
+		name = "decisions"
+	}
+	if !from.IsZero() {
+		name += "-from-" + from.Format(dayFormat)
+	}
+	if !to.IsZero() {
+		name += "-to-" + to.AddDate(0, 0, -1).Format(dayFormat)
+	}
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, ext))
+
+	contentType := "text/csv; charset=utf-8"
+	if ext == ".xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	rw.Header().Set("Content-Type", contentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var sw sheetWriter = newCSVSheet(rw)
+	if ext == ".xlsx" {
+		if sw, err = newXLSXSheet(rw, tr(r, report.Title)); err != nil {
+			warnf("Spreadsheet failed: %s [%v]\n", file, err)
+			return
+		}
+	}
+
+	// Headers are sent by now, so a failure can only cut the download short
+	if err := report.write(sw, r, decided); err != nil {
+		warnf("Spreadsheet failed: %s [%v]\n", file, err)
+		return
+	}
+	if err := sw.close(); err != nil {
+		warnf("Spreadsheet failed: %s [%v]\n", file, err)
+	}
+}
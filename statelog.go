@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	stateLogFile      = "state.log"
+	stateSnapshotFile = "state.snapshot.json"
+)
+
+// The state log is the source of truth for which state each job is in.
+// Every creation, decision and erasure appends one JSON line, and the
+// state maps are rebuilt at startup by replaying it. The first start
+// without a log lists the state directories once and writes the result as
+// the log's opening events.
+//
+// Every snapshot_interval, and at shutdown, the state maps are written to
+// a snapshot along with the log size they reflect, so startup only
+// replays the log from there. Metadata is still read from data/meta.
+
+const (
+	stateAdd    = "add"
+	stateMove   = "move"
+	stateRemove = "remove"
+)
+
+type stateEvent struct {
+	Seq   uint64    `json:"seq"`
+	Type  string    `json:"type"`
+	ID    int       `json:"id"`
+	Queue string    `json:"queue,omitempty"`
+	From  string    `json:"from,omitempty"`
+	State string    `json:"state,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// jobState is where the log last put a job.
+type jobState struct {
+	Queue string `json:"queue,omitempty"`
+	State string `json:"state"`
+}
+
+var stateLog struct {
+	sync.Mutex
+	file *os.File
+	seq  uint64
+	// snapshotSeq is the sequence number of the last snapshot taken
+	snapshotSeq uint64
+}
+
+type stateSnapshot struct {
+	Offset  int64            `json:"offset"`
+	Seq     uint64           `json:"seq"`
+	TakenAt time.Time        `json:"taken_at"`
+	Jobs    map[int]jobState `json:"jobs"`
+}
+
+func stateLogPath() string {
+	return path.Join(contentPath, stateLogFile)
+}
+
+func stateSnapshotPath() string {
+	return path.Join(contentPath, stateSnapshotFile)
+}
+
+// recordState appends e to the state log. Failures are reported but don't
+// undo the change on disk; the reindex command recovers jobs the log lost.
+func recordState(e stateEvent) {
+	stateLog.Lock()
+	defer stateLog.Unlock()
+
+	if stateLog.file == nil {
+		return
+	}
+	stateLog.seq++
+	e.Seq = stateLog.seq
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		_, err = stateLog.file.Write(append(data, '\n'))
+	}
+	if err == nil {
+		err = stateLog.file.Sync()
+	}
+	if err != nil {
+		warnf("State log write failed: ID: %d [%v]\n", e.ID, err)
+	}
+}
+
+// loadStates fills the state maps of every queue from the snapshot and
+// the state log, creating the log from the state directories if there is
+// none yet. Without a snapshot the whole log is replayed anyway, and the
+// state directories are checked against it as well; otherwise startup
+// doesn't list them, and the reindex command finds what the log missed.
+func loadStates() error {
+	snap := loadSnapshot()
+	jobs, seq, err := replayStateLog(stateLogPath(), snap)
+	bootstrapped := false
+	if os.IsNotExist(err) {
+		jobs, seq, err = bootstrapStateLog()
+		bootstrapped = true
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := openStateLog(seq); err != nil {
+		return err
+	}
+	stateLog.snapshotSeq = snap.Seq
+	applyStates(jobs)
+	if !bootstrapped && snap.Seq == 0 {
+		reconcileStates(jobs)
+	}
+	go snapshotStates()
+	return nil
+}
+
+// loadSnapshot returns the last snapshot, or an empty one starting at the
+// beginning of the log if there is none or it can't be read.
+func loadSnapshot() stateSnapshot {
+	empty := stateSnapshot{Jobs: map[int]jobState{}}
+	data, err := os.ReadFile(stateSnapshotPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf("State snapshot read failed: %v\n", err)
+		}
+		return empty
+	}
+	var snap stateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil || snap.Jobs == nil {
+		warnf("State snapshot ignored: %v\n", err)
+		return empty
+	}
+	return snap
+}
+
+func snapshotStates() {
+	if cfg.SnapshotInterval.Duration == 0 {
+		return
+	}
+	for range time.Tick(cfg.SnapshotInterval.Duration) {
+		if !isLeader() {
+			continue
+		}
+		if err := takeSnapshot(); err != nil {
+			warnf("State snapshot failed: %v\n", err)
+		}
+	}
+}
+
+// takeSnapshot writes the state maps unless nothing was logged since the
+// last snapshot. The log lock is held throughout, so every logged event
+// is reflected in the maps; a change already in the maps but not yet
+// logged is replayed again from the tail, which is harmless.
+func takeSnapshot() error {
+	stateLog.Lock()
+	defer stateLog.Unlock()
+
+	if stateLog.file == nil || stateLog.seq == stateLog.snapshotSeq {
+		return nil
+	}
+	info, err := stateLog.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	snap := stateSnapshot{Offset: info.Size(), Seq: stateLog.seq, TakenAt: time.Now().UTC(), Jobs: map[int]jobState{}}
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range q.ids(dir) {
+				snap.Jobs[id] = jobState{Queue: q.name, State: dir}
+			}
+		}
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(stateSnapshotPath(), data); err != nil {
+		return err
+	}
+	stateLog.snapshotSeq = snap.Seq
+	return nil
+}
+
+// openStateLog opens the log for appending, ending a torn last line first
+// so the next event starts on a line of its own.
+func openStateLog(seq uint64) error {
+	f, err := os.OpenFile(stateLogPath(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte{'\n'})
+		}
+	}
+	stateLog.Lock()
+	stateLog.file = f
+	stateLog.seq = seq
+	stateLog.Unlock()
+	return nil
+}
+
+// replayStateLog applies the events in name after snap to its jobs and
+// returns them with the last sequence number. The whole log is replayed if
+// it is shorter than the snapshot says. A torn last line from a crash is
+// skipped.
+func replayStateLog(name string, snap stateSnapshot) (map[int]jobState, uint64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	jobs, seq := snap.Jobs, snap.Seq
+	if info, err := f.Stat(); err != nil || info.Size() < snap.Offset {
+		warnf("State snapshot does not match the log, replaying all of it\n")
+		jobs, seq = map[int]jobState{}, 0
+	} else if _, err := f.Seek(snap.Offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e stateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			warnf("State log line %d skipped: %v\n", line, err)
+			continue
+		}
+		if e.Seq > seq {
+			seq = e.Seq
+		}
+		switch e.Type {
+		case stateAdd, stateMove:
+			jobs[e.ID] = jobState{Queue: e.Queue, State: e.State}
+		case stateRemove:
+			delete(jobs, e.ID)
+		}
+	}
+	return jobs, seq, scanner.Err()
+}
+
+// bootstrapStateLog lists the state directories and writes one add event
+// per job found, replacing the log in a single rename.
+func bootstrapStateLog() (map[int]jobState, uint64, error) {
+	infof("State log not present, listing state directories\n")
+
+	jobs, events := listStateDirs()
+	data, err := encodeStateEvents(events)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, uint64(len(events)), writeFileAtomic(stateLogPath(), data)
+}
+
+// listStateDirs returns the jobs in the state directories of every queue,
+// and an add event for each, numbered from the start of a log.
+func listStateDirs() (map[int]jobState, []stateEvent) {
+	jobs := map[int]jobState{}
+	events := []stateEvent{}
+	now := time.Now().UTC()
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range getListOfFiles(path.Join(q.root, dir)) {
+				events = append(events, stateEvent{Seq: uint64(len(events) + 1), Type: stateAdd, ID: id, Queue: q.name, State: dir, At: now})
+				jobs[id] = jobState{Queue: q.name, State: dir}
+			}
+		}
+	}
+	return jobs, events
+}
+
+func encodeStateEvents(events []stateEvent) ([]byte, error) {
+	var data []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return data, nil
+}
+
+// rewriteStateLog replaces the state log, and the state maps, with the
+// given jobs and the events that add them. The snapshot is dropped; the
+// next one is taken from the new log.
+func rewriteStateLog(jobs map[int]jobState, events []stateEvent) error {
+	data, err := encodeStateEvents(events)
+	if err != nil {
+		return err
+	}
+
+	stateLog.Lock()
+	defer stateLog.Unlock()
+
+	if err := writeFileAtomic(stateLogPath(), data); err != nil {
+		return err
+	}
+	if err := os.Remove(stateSnapshotPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if stateLog.file != nil {
+		stateLog.file.Close()
+		stateLog.file = nil
+		f, err := os.OpenFile(stateLogPath(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		stateLog.file = f
+	}
+	stateLog.seq = uint64(len(events))
+	stateLog.snapshotSeq = 0
+
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, sm := range q.shards(dir) {
+				sm.Lock()
+				sm.idMap = map[int]bool{}
+				sm.Unlock()
+			}
+		}
+	}
+	applyStates(jobs)
+	return nil
+}
+
+func applyStates(jobs map[int]jobState) {
+	for id, s := range jobs {
+		q, ok := lookupQueue(s.Queue)
+		if !ok {
+			warnf("State skipped: ID: %d [queue not configured: %s]\n", id, s.Queue)
+			continue
+		}
+		if getIndex(s.State) < 0 {
+			warnf("State skipped: ID: %d [unknown state: %s]\n", id, s.State)
+			continue
+		}
+		sm := q.states(s.State, id)
+		sm.Lock()
+		sm.idMap[id] = true
+		sm.Unlock()
+	}
+}
+
+// reconcileStates logs the job files in the state directories that jobs,
+// the states loaded from the log, doesn't know: those left by a crash
+// between writing a job and logging it, and those put there by hand.
+// ingestMissing then takes in the ones without metadata. It lists every
+// state directory, so it only runs when the log is replayed in full.
+func reconcileStates(jobs map[int]jobState) {
+	for _, q := range queues {
+		for _, dir := range dirs {
+			for _, id := range getListOfFiles(path.Join(q.root, dir)) {
+				if known, ok := jobs[id]; ok {
+					if known.Queue != q.name || known.State != dir {
+						warnf("Job %d also found in %s/%s, kept in %s\n", id, q.root, dir, known.State)
+					}
+					continue
+				}
+				if _, ok := metadata.get(id); !ok {
+					infof("Found job %d in %s/%s, adding it\n", id, q.root, dir)
+				}
+				jobs[id] = jobState{Queue: q.name, State: dir}
+				sm := q.states(dir, id)
+				sm.Lock()
+				sm.idMap[id] = true
+				sm.Unlock()
+				recordState(stateEvent{Type: stateAdd, ID: id, Queue: q.name, State: dir})
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const staticPath = "/static/"
+
+// Assets are linked with a content hash in the query string, so responses
+// for the current version can be cached for good and a changed file is
+// fetched under a new URL.
+const immutableCache = "public, max-age=31536000, immutable"
+
+var assetVersions struct {
+	sync.Mutex
+	sums map[string]string
+}
+
+func init() {
+	// Not in Go's built-in table
+	mime.AddExtensionType(".woff", "font/woff")
+	mime.AddExtensionType(".woff2", "font/woff2")
+	mime.AddExtensionType(".ttf", "font/ttf")
+}
+
+// assetVersion returns a short hash of a static file, "" if it is missing.
+// Hashes are remembered except in dev mode, where files change underneath.
+func assetVersion(name string) string {
+	assetVersions.Lock()
+	defer assetVersions.Unlock()
+
+	if sum, ok := assetVersions.sums[name]; ok && !cfg.Dev {
+		return sum
+	}
+	data, err := fs.ReadFile(staticFS, name)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	if assetVersions.sums == nil {
+		assetVersions.sums = map[string]string{}
+	}
+	assetVersions.sums[name] = hex.EncodeToString(sum[:6])
+	return assetVersions.sums[name]
+}
+
+// assetURL is the "asset" template function: the versioned URL of a file
+// under static/.
+func assetURL(name string) string {
+	if v := assetVersion(name); v != "" {
+		return urlFor(staticPath, name, "?v=", v)
+	}
+	return urlFor(staticPath, name)
+}
+
+// staticHandler serves the embedded static files, overlaid by static_dir.
+// Directories aren't listed.
+func staticHandler(rw http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, staticPath)
+	if name == "" || strings.HasSuffix(name, "/") || !fs.ValidPath(name) {
+		notFound(rw, r)
+		return
+	}
+	if info, err := fs.Stat(staticFS, name); err != nil || info.IsDir() {
+		notFound(rw, r)
+		return
+	}
+
+	if v := r.URL.Query().Get("v"); v != "" && v == assetVersion(name) {
+		rw.Header().Set("Cache-Control", immutableCache)
+	} else {
+		rw.Header().Set("Cache-Control", "public, no-cache")
+	}
+	if v := assetVersion(name); v != "" {
+		rw.Header().Set("ETag", `"`+v+`"`)
+	}
+	http.StripPrefix(strings.TrimSuffix(staticPath, "/"), http.FileServer(http.FS(staticFS))).ServeHTTP(rw, r)
+}
@@ -0,0 +1,13 @@
+// Client-side behaviour for the server-rendered pages. Everything here is
+// optional: pages work without scripts.
+document.addEventListener("DOMContentLoaded", function () {
+    // Canned reason pickers fill in the reason field of their form
+    document.querySelectorAll("select[name=reason_template]").forEach(function (picker) {
+        picker.addEventListener("change", function () {
+            var reason = picker.form && picker.form.elements["reason"];
+            if (reason && picker.selectedIndex > 0) {
+                reason.value = picker.options[picker.selectedIndex].dataset.text;
+            }
+        });
+    });
+});
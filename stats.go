@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	statsPath     = "/stats"
+	apiStatsPath  = "/stats"
+	statsTemplate = "stats.html"
+	dayFormat     = "2006-01-02"
+)
+
+type stateCount struct {
+	State string `json:"state"`
+	Count int    `json:"count"`
+}
+
+type dayCount struct {
+	Day      string         `json:"day"`
+	Total    int            `json:"total"`
+	ByState  map[string]int `json:"by_state"`
+	BarWidth int            `json:"-"`
+}
+
+type reviewerCount struct {
+	Reviewer         string         `json:"reviewer"`
+	Decisions        int            `json:"decisions"`
+	ByState          map[string]int `json:"by_state"`
+	AvgReviewSeconds float64        `json:"avg_review_seconds"`
+	timed            int
+}
+
+func (rc reviewerCount) AvgReviewTime() string {
+	return humanDuration(time.Duration(rc.AvgReviewSeconds * float64(time.Second)))
+}
+
+type jobTiming struct {
+	ID            int     `json:"id"`
+	ReviewSeconds float64 `json:"review_seconds"`
+	Sessions      int     `json:"sessions"`
+}
+
+func (jt jobTiming) ReviewTime() string {
+	return humanDuration(time.Duration(jt.ReviewSeconds * float64(time.Second)))
+}
+
+const slowestJobs = 10
+
+type stats struct {
+	Team               string          `json:"team,omitempty"`
+	Submitter          string          `json:"submitter,omitempty"`
+	From               string          `json:"from,omitempty"`
+	To                 string          `json:"to,omitempty"`
+	States             []stateCount    `json:"states"`
+	Decided            int             `json:"decided"`
+	AvgDecisionSeconds float64         `json:"avg_time_to_decision_seconds"`
+	AvgReviewSeconds   float64         `json:"avg_review_seconds"`
+	SlowestJobs        []jobTiming     `json:"slowest_jobs"`
+	PerDay             []dayCount      `json:"decisions_per_day"`
+	Reviewers          []reviewerCount `json:"reviewers"`
+}
+
+func (s stats) AvgDecisionTime() string {
+	return humanDuration(time.Duration(s.AvgDecisionSeconds * float64(time.Second)))
+}
+
+func (s stats) AvgReviewTime() string {
+	return humanDuration(time.Duration(s.AvgReviewSeconds * float64(time.Second)))
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%.1fh", d.Hours())
+	}
+	return fmt.Sprintf("%.1fd", d.Hours()/24)
+}
+
+// parseDateRange reads optional from/to query parameters, given either as
+// dates or RFC 3339 timestamps. A to date is inclusive.
+func parseDateRange(r *http.Request) (time.Time, time.Time, error) {
+	return dateRange(r.FormValue("from"), r.FormValue("to"))
+}
+
+// dateRange reads a from/to pair as parseDateRange does.
+func dateRange(fromValue, toValue string) (time.Time, time.Time, error) {
+	from, _, err := parseBound(fromValue)
+	if err != nil {
+		return from, time.Time{}, fmt.Errorf("invalid from: %q", fromValue)
+	}
+	to, isDate, err := parseBound(toValue)
+	if err != nil {
+		return from, to, fmt.Errorf("invalid to: %q", toValue)
+	}
+	if isDate {
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+func parseBound(v string) (time.Time, bool, error) {
+	if v == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.Parse(dayFormat, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+func inRange(t, from, to time.Time) bool {
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !t.Before(to) {
+		return false
+	}
+	return true
+}
+
+// computeStats aggregates over the jobs in visible, or only those routed to
+// team and those from submitter if given.
+func computeStats(from, to time.Time, team string, submitter string, visible []*queue) stats {
+	s := stats{Team: team, Submitter: submitter}
+	if !from.IsZero() {
+		s.From = from.Format(dayFormat)
+	}
+	if !to.IsZero() {
+		s.To = to.AddDate(0, 0, -1).Format(dayFormat)
+	}
+
+	for _, dir := range dirs {
+		count := 0
+		for _, q := range visible {
+			for _, id := range q.ids(dir) {
+				m, _ := metadata.get(id)
+				if (team == "" || m.Team == team) && (submitter == "" || m.Submitter == submitter) {
+					count++
+				}
+			}
+		}
+		s.States = append(s.States, stateCount{State: dir, Count: count})
+	}
+
+	days := map[string]*dayCount{}
+	reviewers := map[string]*reviewerCount{}
+	var total time.Duration
+	var reviewTotal float64
+	timed := 0
+
+	metadata.RLock()
+	for _, m := range metadata.entries {
+		d := m.Decision
+		if d == nil || !inRange(d.At, from, to) || (team != "" && m.Team != team) || !inQueues(m.Queue, visible) {
+			continue
+		}
+		if submitter != "" && m.Submitter != submitter {
+			continue
+		}
+
+		s.Decided++
+		if !m.SubmittedAt.IsZero() && d.At.After(m.SubmittedAt) {
+			total += d.At.Sub(m.SubmittedAt)
+		}
+
+		day := d.At.Format(dayFormat)
+		if days[day] == nil {
+			days[day] = &dayCount{Day: day, ByState: map[string]int{}}
+		}
+		days[day].Total++
+		days[day].ByState[d.State]++
+
+		if reviewers[d.By] == nil {
+			reviewers[d.By] = &reviewerCount{Reviewer: d.By, ByState: map[string]int{}}
+		}
+		reviewers[d.By].Decisions++
+		reviewers[d.By].ByState[d.State]++
+
+		if d.ReviewSeconds > 0 {
+			timed++
+			reviewTotal += d.ReviewSeconds
+			reviewers[d.By].timed++
+			reviewers[d.By].AvgReviewSeconds += d.ReviewSeconds
+			s.SlowestJobs = append(s.SlowestJobs, jobTiming{ID: m.ID, ReviewSeconds: d.ReviewSeconds, Sessions: m.Sessions})
+		}
+	}
+	metadata.RUnlock()
+
+	if s.Decided > 0 {
+		s.AvgDecisionSeconds = (total / time.Duration(s.Decided)).Seconds()
+	}
+	if timed > 0 {
+		s.AvgReviewSeconds = reviewTotal / float64(timed)
+	}
+	sort.Slice(s.SlowestJobs, func(i, j int) bool {
+		return s.SlowestJobs[i].ReviewSeconds > s.SlowestJobs[j].ReviewSeconds
+	})
+	if len(s.SlowestJobs) > slowestJobs {
+		s.SlowestJobs = s.SlowestJobs[:slowestJobs]
+	}
+
+	maxDay := 0
+	for _, day := range days {
+		s.PerDay = append(s.PerDay, *day)
+		if day.Total > maxDay {
+			maxDay = day.Total
+		}
+	}
+	sort.Slice(s.PerDay, func(i, j int) bool {
+		return s.PerDay[i].Day < s.PerDay[j].Day
+	})
+	for i := range s.PerDay {
+		s.PerDay[i].BarWidth = 100 * s.PerDay[i].Total / maxDay
+	}
+
+	for _, rc := range reviewers {
+		if rc.timed > 0 {
+			rc.AvgReviewSeconds /= float64(rc.timed)
+		}
+		s.Reviewers = append(s.Reviewers, *rc)
+	}
+	sort.Slice(s.Reviewers, func(i, j int) bool {
+		if s.Reviewers[i].Decisions != s.Reviewers[j].Decisions {
+			return s.Reviewers[i].Decisions > s.Reviewers[j].Decisions
+		}
+		return s.Reviewers[i].Reviewer < s.Reviewers[j].Reviewer
+	})
+
+	return s
+}
+
+func statsHandler(rw http.ResponseWriter, r *http.Request) {
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	team, ok := requestTeam(rw, r)
+	if !ok {
+		return
+	}
+	renderTemplate(rw, r, statsTemplate, computeStats(from, to, team, r.FormValue("submitter"), accessibleQueues(r)))
+}
+
+func apiStatsHandler(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	team, ok := requestTeam(rw, r)
+	if !ok {
+		return
+	}
+	writeJSON(rw, http.StatusOK, computeStats(from, to, team, r.FormValue("submitter"), accessibleQueues(r)))
+}
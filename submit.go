@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// errScanUnavailable is returned, wrapped, when a submission can't be
+// scanned for viruses for now.
+var errScanUnavailable = errors.New("virus scan unavailable")
+
+// submitRefusal is a submission turned away by one of the checks. It is
+// final: the same submission would be turned away again.
+type submitRefusal struct {
+	status int
+	apiError
+	// note is the message with anything Details adds, for a reader who
+	// doesn't get the details
+	note string
+}
+
+func (e *submitRefusal) Error() string {
+	if e.note != "" {
+		return e.note
+	}
+	return e.Message
+}
+
+// submitJob takes body into q as a new job after the checks every
+// submission goes through: virus scan, schema, scoring, rules, the
+// ingest hook and the quota. sub carries what the submission supplied
+// beyond the body, as for createJob; form, if not nil, holds its
+// attachments, already checked for name and size. An infected submission
+// is quarantined. A refusal is returned as a *submitRefusal.
+func submitJob(q *queue, body *spooledBody, form *multipart.Form, sub jobMeta) (jobMeta, error) {
+	scan, err := scanSubmission(body, form)
+	if err != nil {
+		return jobMeta{}, fmt.Errorf("%w: %v", errScanUnavailable, err)
+	}
+	if scan != nil && scan.Result == scanInfected {
+		return jobMeta{}, refuseInfected(body, form, q, sub, *scan)
+	}
+
+	s := submission{
+		queue:       q.name,
+		submitter:   sub.Submitter,
+		contentType: sniffContentType(body.head),
+		fields:      sub.Fields,
+	}
+	if inspectsContent(q) {
+		if s.body, err = body.bytes(); err != nil {
+			return jobMeta{}, err
+		}
+	}
+	if err := checkSchema(q, s.body); err != nil {
+		return jobMeta{}, err
+	}
+	if cfg.Scoring.URL != "" {
+		// Scoring is advisory, a failing service must not block submissions
+		if s.score, err = scoreSubmission(q.name, s.contentType, s.body); err != nil {
+			warnf("Scoring failed: [%v]\n", err)
+		}
+	}
+	out := evaluateRules(s)
+	if out.Team != "" {
+		sub.Team = out.Team
+	}
+	if out.Score != nil {
+		s.score.Score = out.Score
+	}
+	if err := checkIngestHook(s, sub.Team, out.Tags); err != nil {
+		return jobMeta{}, &submitRefusal{
+			status:   http.StatusUnprocessableEntity,
+			apiError: apiError{Code: codeHookRefused, Message: err.Error()},
+		}
+	}
+
+	sub.Tags = out.Tags
+	sub.Score = s.score.Score
+	sub.Labels = s.score.Labels
+	sub.Scan = scan
+	m, err := createWithinQuota(q, body, sub)
+	if err != nil {
+		return jobMeta{}, err
+	}
+
+	if _, err := addAttachments(m.ID, form); err != nil {
+		return m, fmt.Errorf("attachments of job %d: %v", m.ID, err)
+	}
+	applyRules(m.ID, out)
+	m, _ = metadata.get(m.ID)
+	if out.Decision == "" {
+		checkDuplicate(m)
+		m, _ = metadata.get(m.ID)
+	}
+	return m, nil
+}
+
+// createWithinQuota creates the job unless its submitter is over quota
+// and the quota rejects, deferring it if the quota defers. Counting and
+// creating are done under quotaLock, so two submissions can't both take
+// the last place.
+func createWithinQuota(q *queue, body jobBody, sub jobMeta) (jobMeta, error) {
+	if cfg.Quota.limit(sub.Submitter) != 0 {
+		quotaLock.Lock()
+		defer quotaLock.Unlock()
+	}
+	fits, pending, limit := checkQuota(sub.Submitter)
+	if !fits && cfg.Quota.Action == quotaReject {
+		return jobMeta{}, &submitRefusal{
+			status: http.StatusTooManyRequests,
+			apiError: apiError{
+				Code:    codeQuotaExceeded,
+				Message: fmt.Sprintf("%s already has %d jobs waiting for review, the limit is %d", sub.Submitter, pending, limit),
+				Details: map[string]int{"pending": pending, "limit": limit},
+			},
+		}
+	}
+	sub.Deferred = !fits
+	return createJob(q, body, sub)
+}
+
+// checkSchema validates body against the schema of q, if it has one.
+func checkSchema(q *queue, body []byte) error {
+	if q.schema == nil {
+		return nil
+	}
+	errs := q.schema.validate(body)
+	if len(errs) == 0 {
+		return nil
+	}
+	message := "body does not match the schema of the queue"
+	reasons := []string{}
+	for _, e := range errs {
+		reasons = append(reasons, e.Field+": "+e.Message)
+	}
+	return &submitRefusal{
+		status: http.StatusUnprocessableEntity,
+		apiError: apiError{
+			Code:    codeSchemaViolation,
+			Message: message,
+			Details: map[string][]schemaError{"errors": errs},
+		},
+		note: message + ":\n" + strings.Join(reasons, "\n"),
+	}
+}
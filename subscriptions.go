@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	subscriptionsFile    = "subscriptions.json"
+	apiSubscriptionsPath = "/notifications"
+)
+
+var validAPISubscriptionsPath = regexp.MustCompile("^/api/v[0-9]+/notifications$")
+
+// What a submitter hears about: only the final decision on their jobs, or
+// every change on the way there.
+const (
+	notifyDecision = "decision"
+	notifyAll      = "all"
+)
+
+// subscription is where and when a submitter wants to hear about their
+// jobs, delivered through the same channels as escalation notices.
+type subscription struct {
+	delivery
+	Events    string    `json:"events"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// subscriptionStore keeps subscriptions by submitter. Relayed submitters
+// have no account, so an admin may manage theirs on their behalf.
+type subscriptionStore struct {
+	sync.RWMutex
+	bySubmitter map[string]subscription
+}
+
+var subscriptions = subscriptionStore{bySubmitter: map[string]subscription{}}
+
+func (s *subscriptionStore) load() error {
+	data, err := os.ReadFile(path.Join(contentPath, subscriptionsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := json.Unmarshal(data, &s.bySubmitter); err != nil {
+		return fmt.Errorf("parse %s: %v", subscriptionsFile, err)
+	}
+	return nil
+}
+
+// persist must be called with the lock held.
+func (s *subscriptionStore) persist() error {
+	data, err := json.MarshalIndent(s.bySubmitter, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(contentPath, subscriptionsFile), data)
+}
+
+func (s *subscriptionStore) get(submitter string) (subscription, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sub, ok := s.bySubmitter[submitter]
+	return sub, ok
+}
+
+func (s *subscriptionStore) set(submitter string, sub subscription) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.bySubmitter[submitter] = sub
+	return s.persist()
+}
+
+func (s *subscriptionStore) remove(submitter string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.bySubmitter[submitter]; !ok {
+		return false, nil
+	}
+	delete(s.bySubmitter, submitter)
+	return true, s.persist()
+}
+
+// wants tells whether an event is one the subscriber asked for.
+func (sub subscription) wants(e jobEvent) bool {
+	if sub.Events == notifyAll {
+		return true
+	}
+	return e.Type == eventDecided && (e.State == "accept" || e.State == "reject")
+}
+
+// parseSubscription reads a subscription from the form fields email
+// (repeatable), webhook and events.
+func parseSubscription(r *http.Request) (subscription, error) {
+	sub := subscription{Events: strings.TrimSpace(r.FormValue("events"))}
+	switch sub.Events {
+	case "":
+		sub.Events = notifyDecision
+	case notifyDecision, notifyAll:
+	default:
+		return sub, fmt.Errorf("unknown events: %q", sub.Events)
+	}
+
+	for _, value := range r.Form["email"] {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(value)
+		if err != nil {
+			return sub, fmt.Errorf("invalid email: %q", value)
+		}
+		sub.Email = append(sub.Email, addr.Address)
+	}
+	if sub.Webhook = strings.TrimSpace(r.FormValue("webhook")); sub.Webhook != "" {
+		u, err := url.Parse(sub.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return sub, fmt.Errorf("invalid webhook: %q", sub.Webhook)
+		}
+	}
+	if sub.empty() {
+		return sub, errors.New("missing email or webhook")
+	}
+	sub.UpdatedAt = time.Now().UTC()
+	return sub, nil
+}
+
+// notifySubmitter tells the submitter of a job about an event, if they
+// subscribed to it. Escalation notes are for reviewers and left out.
+func notifySubmitter(submitter string, e jobEvent) {
+	if submitter == "" {
+		return
+	}
+	sub, ok := subscriptions.get(submitter)
+	if !ok || !sub.wants(e) {
+		return
+	}
+
+	var what string
+	switch {
+	case e.Type == eventDecided && e.State == "accept":
+		what = "accepted"
+	case e.Type == eventDecided && e.State == "reject":
+		what = "rejected"
+	case e.Type == eventDecided:
+		what = "escalated"
+		e.Reason = ""
+	default:
+		what = e.Type
+	}
+	label := fmt.Sprintf("Job %d", e.ID)
+	if m, ok := metadata.get(e.ID); ok {
+		label = jobLabel(m)
+	}
+	subject := fmt.Sprintf("%s %s", label, what)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s was %s at %s.\n", label, what, e.At.Format(time.RFC3339))
+	if e.Reason != "" {
+		fmt.Fprintf(&text, "\n%s\n", e.Reason)
+	}
+	fmt.Fprintf(&text, "\n%s\n", urlFor(portalPath))
+
+	// Reviewers stay anonymous to submitters
+	e.User = ""
+	if err := deliver(sub.delivery, subject, text.String(), e); err != nil {
+		warnf("Submitter notify failed: ID: %d [%v]\n", e.ID, err)
+	}
+}
+
+// subscriptionTarget tells whose subscription a request is about: the
+// caller's own, or for admins, that of the submitter given.
+func subscriptionTarget(r *http.Request) (string, error) {
+	submitter := strings.TrimSpace(r.URL.Query().Get("submitter"))
+	if submitter == "" || submitter == currentUser(r).Name {
+		return currentUser(r).Name, nil
+	}
+	if !isAdmin(r) {
+		return "", errNotRelay
+	}
+	return submitter, nil
+}
+
+// apiSubscriptionsHandler shows (GET), sets (PUT) and removes (DELETE)
+// the caller's notification preferences.
+func apiSubscriptionsHandler(rw http.ResponseWriter, r *http.Request) {
+	submitter, err := subscriptionTarget(r)
+	if err != nil {
+		httpError(rw, r, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sub, ok := subscriptions.get(submitter)
+		if !ok {
+			notFound(rw, r)
+			return
+		}
+		writeJSON(rw, http.StatusOK, sub)
+	case http.MethodPut:
+		sub, err := parseSubscription(r)
+		if err != nil {
+			httpError(rw, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := subscriptions.set(submitter, sub); err != nil {
+			warnf("Subscription failed: %s [%v]\n", strconv.Quote(submitter), err)
+			httpError(rw, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(rw, http.StatusOK, sub)
+	case http.MethodDelete:
+		found, err := subscriptions.remove(submitter)
+		if err != nil {
+			warnf("Subscription removal failed: %s [%v]\n", strconv.Quote(submitter), err)
+			httpError(rw, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			notFound(rw, r)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.Header().Set("Allow", "GET, PUT, DELETE")
+		httpError(rw, r, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
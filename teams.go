@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	teamsFile     = "teams.json"
+	teamsPath     = "/admin/teams"
+	teamsTemplate = "teams.html"
+)
+
+var validTeam = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type team struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// teamStore holds the admin-managed teams. Jobs routed to a team are only
+// offered to its members; reviewers outside every team see all jobs.
+type teamStore struct {
+	sync.RWMutex
+	teams []team
+}
+
+var teams = teamStore{}
+
+func (s *teamStore) load() error {
+	data, err := os.ReadFile(path.Join(contentPath, teamsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := json.Unmarshal(data, &s.teams); err != nil {
+		return fmt.Errorf("parse %s: %v", teamsFile, err)
+	}
+	return nil
+}
+
+// persist must be called with the lock held.
+func (s *teamStore) persist() error {
+	data, err := json.MarshalIndent(s.teams, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(contentPath, teamsFile), data)
+}
+
+func (s *teamStore) list() []team {
+	s.RLock()
+	defer s.RUnlock()
+
+	return append([]team{}, s.teams...)
+}
+
+func (s *teamStore) names() []string {
+	names := []string{}
+	for _, t := range s.list() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func (s *teamStore) exists(name string) bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, t := range s.teams {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// of returns the teams userName belongs to.
+func (s *teamStore) of(userName string) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	names := []string{}
+	for _, t := range s.teams {
+		for _, member := range t.Members {
+			if member == userName {
+				names = append(names, t.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+func (s *teamStore) save(t team) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.teams {
+		if s.teams[i].Name == t.Name {
+			s.teams[i] = t
+			return s.persist()
+		}
+	}
+	s.teams = append(s.teams, t)
+	sort.Slice(s.teams, func(i, j int) bool {
+		return s.teams[i].Name < s.teams[j].Name
+	})
+	return s.persist()
+}
+
+func (s *teamStore) remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i := range s.teams {
+		if s.teams[i].Name == name {
+			s.teams = append(s.teams[:i], s.teams[i+1:]...)
+			return s.persist()
+		}
+	}
+	return fmt.Errorf("team not present: %s", name)
+}
+
+func jobTeam(id int) string {
+	m, _ := metadata.get(id)
+	return m.Team
+}
+
+// inQueue reports whether a job routed to jobTeam is offered to userName.
+func inQueue(jobTeam string, userName string) bool {
+	if jobTeam == "" {
+		return true
+	}
+	member := teams.of(userName)
+	if len(member) == 0 {
+		return true
+	}
+	for _, name := range member {
+		if name == jobTeam {
+			return true
+		}
+	}
+	return false
+}
+
+// canSeeJob lets admins through and otherwise applies team routing.
+func canSeeJob(r *http.Request, id int) bool {
+	return isAdmin(r) || inQueue(jobTeam(id), currentUser(r).Name)
+}
+
+// requestTeam reads an optional ?team= scope for list and stat views.
+func requestTeam(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.FormValue("team")
+	if name != "" && !teams.exists(name) {
+		httpError(rw, r, "unknown team", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+func splitMembers(v string) []string {
+	return strings.FieldsFunc(v, func(c rune) bool {
+		return c == ',' || unicode.IsSpace(c)
+	})
+}
+
+func teamsHandler(rw http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(rw, r) {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderTemplate(rw, r, teamsTemplate, teams.list())
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if !validTeam.MatchString(name) {
+		httpError(rw, r, "invalid team name", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch r.FormValue("action") {
+	case "save":
+		t := team{Name: name, Members: splitMembers(r.FormValue("members"))}
+		for _, member := range t.Members {
+			if !knownUser(member) {
+				httpError(rw, r, fmt.Sprintf("unknown user: %s", member), http.StatusBadRequest)
+				return
+			}
+		}
+		err = teams.save(t)
+	case "delete":
+		err = teams.remove(name)
+	default:
+		httpError(rw, r, "invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		warnf("Team update failed: %v\n", err)
+		httpError(rw, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(rw, r, urlFor(teamsPath), http.StatusSeeOther)
+}
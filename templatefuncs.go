@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// dateLayouts are the layouts the date template function knows by name.
+// Any other layout is used as a Go time layout.
+var dateLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"time":     "15:04",
+	"datetime": "2006-01-02 15:04",
+	"rfc3339":  time.RFC3339,
+}
+
+// registerTemplateFunc makes fn available to all templates, page and
+// report templates alike, as name. It must be called before templates are
+// parsed, from an init function; each name can be taken once.
+func registerTemplateFunc(name string, fn interface{}) {
+	if _, ok := templateFuncs[name]; ok {
+		panic(fmt.Sprintf("template function %s registered twice", name))
+	}
+	templateFuncs[name] = fn
+}
+
+func init() {
+	registerTemplateFunc("date", formatDate)
+	registerTemplateFunc("markdown", func(src string) string { return renderMarkdown([]byte(src)) })
+	registerTemplateFunc("truncate", truncate)
+	registerTemplateFunc("duration", formatDuration)
+	registerTemplateFunc("ago", func(t time.Time) string { return humanDuration(time.Since(t)) })
+	registerTemplateFunc("jobTitle", func(id int) string { m, _ := metadata.get(id); return m.Title })
+	registerTemplateFunc("drafts", draftsEnabled)
+	registerTemplateFunc("jobSummary", func(id int) string { m, _ := metadata.get(id); return m.Summary })
+}
+
+// formatDate formats a time.Time or *time.Time with a named or Go layout,
+// as in {{date "datetime" .At}}. Zero and nil times come out empty.
+func formatDate(layout string, t interface{}) (string, error) {
+	if named, ok := dateLayouts[layout]; ok {
+		layout = named
+	}
+	switch v := t.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	case *time.Time:
+		if v == nil || v.IsZero() {
+			return "", nil
+		}
+		return v.Format(layout), nil
+	}
+	return "", fmt.Errorf("date: %T is not a time", t)
+}
+
+// truncate shortens s to n characters, ending in an ellipsis when it had
+// to cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	if n < 1 {
+		return ""
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// formatDuration renders a time.Duration or seconds as humanDuration does.
+func formatDuration(d interface{}) (string, error) {
+	switch v := d.(type) {
+	case time.Duration:
+		return humanDuration(v), nil
+	case float64:
+		return humanDuration(time.Duration(v * float64(time.Second))), nil
+	case int:
+		return humanDuration(time.Duration(v) * time.Second), nil
+	}
+	return "", fmt.Errorf("duration: %T is not a duration", d)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"text/template"
+)
+
+//go:embed tmpl static
+var assets embed.FS
+
+const (
+	layoutTemplate = "layout"
+	layoutGlob     = "layout/*.html"
+	pageGlob       = "*.html"
+)
+
+// overlayFS serves files from upper when present and falls back to lower,
+// so an override directory only needs the files it actually changes.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.lower.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	seen := map[string]bool{}
+	entries := []fs.DirEntry{}
+	found := false
+
+	for _, fsys := range []fs.FS{o.upper, o.lower} {
+		list, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, entry := range list {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func assetFS(dir string, overrideDir string) fs.FS {
+	embedded, err := fs.Sub(assets, dir)
+	if err != nil {
+		panic(err)
+	}
+	if overrideDir == "" {
+		return embedded
+	}
+	return overlayFS{upper: os.DirFS(overrideDir), lower: embedded}
+}
+
+const devTemplateDir = "tmpl"
+
+func templateDir() string {
+	if cfg.TemplateDir == "" && cfg.Dev {
+		return devTemplateDir
+	}
+	return cfg.TemplateDir
+}
+
+// loadTemplates parses the shared layout once and clones it for every page,
+// so each page can define its own "title" and "content" blocks.
+func loadTemplates() (map[string]*template.Template, error) {
+	fsys := assetFS("tmpl", templateDir())
+
+	base, err := template.New("").Funcs(templateFuncs).ParseFS(fsys, layoutGlob)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := fs.Glob(fsys, pageGlob)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]*template.Template{}
+	for _, name := range names {
+		page, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := page.ParseFS(fsys, name); err != nil {
+			return nil, err
+		}
+		pages[name] = page
+	}
+
+	return pages, nil
+}
+
+// localizeTemplate binds the locale-dependent template functions for a
+// single request without touching the shared parsed set.
+func localizeTemplate(t *template.Template, locale string) (*template.Template, error) {
+	clone, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return clone.Funcs(template.FuncMap{
+		"lang": func() string { return locale },
+		"T": func(key string, args ...interface{}) string {
+			return translate(locale, key, args...)
+		},
+	}), nil
+}
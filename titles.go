@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultTitleLength   = 80
+	defaultSummaryLength = 200
+)
+
+// titlesConfig controls the titles and summaries derived for text jobs
+// that don't bring a title of their own, so lists and notifications can
+// say more than a number. Pattern, if set, is tried first on the body;
+// its first group, or the whole match without one, becomes the title.
+// Otherwise the first non-empty line does.
+type titlesConfig struct {
+	Pattern       string `json:"pattern"`
+	MaxLength     int    `json:"max_length"`
+	SummaryLength int    `json:"summary_length"`
+
+	pattern *regexp.Regexp
+}
+
+func (c *titlesConfig) validate() error {
+	if c.MaxLength < 0 || c.SummaryLength < 0 {
+		return errors.New("titles lengths must not be negative")
+	}
+	if c.MaxLength == 0 {
+		c.MaxLength = defaultTitleLength
+	}
+	if c.SummaryLength == 0 {
+		c.SummaryLength = defaultSummaryLength
+	}
+	c.pattern = nil
+	if c.Pattern != "" {
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid titles.pattern: %v", err)
+		}
+		c.pattern = re
+	}
+	return nil
+}
+
+// deriveTitle gives a text job without a title one taken from the start
+// of its body, and sets its summary from the text that follows. A title
+// in the front matter counts as the job's own.
+func deriveTitle(m *jobMeta, head []byte) {
+	if !isTextContent(m.ContentType) {
+		return
+	}
+	fm, _, body := splitFrontMatter(head)
+	if fm != nil && m.Title == "" {
+		m.Title = fm.Title
+	}
+	text := strings.ToValidUTF8(string(body), "")
+
+	first, rest := firstLine(text)
+	if m.Title == "" {
+		title := ""
+		if re := cfg.Titles.pattern; re != nil {
+			if match := re.FindStringSubmatch(text); len(match) > 1 && match[1] != "" {
+				title = match[1]
+			} else if len(match) > 0 {
+				title = match[0]
+			}
+		}
+		if title == "" {
+			title, text = first, rest
+		}
+		m.Title = truncate(cfg.Titles.MaxLength, strings.Join(strings.Fields(title), " "))
+		m.DerivedTitle = m.Title != ""
+	}
+	m.Summary = truncate(cfg.Titles.SummaryLength, strings.Join(strings.Fields(text), " "))
+}
+
+// firstLine returns the first line of text with anything on it, without
+// markdown heading or quote markers, and the text after it.
+func firstLine(text string) (string, string) {
+	for text != "" {
+		line := text
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			line, text = text[:i], text[i+1:]
+		} else {
+			text = ""
+		}
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#>"))
+		if line != "" {
+			return line, text
+		}
+	}
+	return "", ""
+}
+
+// backfillTitle derives the title and summary of a job recorded before
+// titles were.
+func backfillTitle(q *queue, m jobMeta, state string) {
+	file, err := openBody(q.file(m.ID, state))
+	if err != nil {
+		warnf("Title failed: ID: %d [%v]\n", m.ID, err)
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, frontMatterLimit)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		warnf("Title failed: ID: %d [%v]\n", m.ID, err)
+		return
+	}
+	derived := m
+	deriveTitle(&derived, head[:n])
+	if derived.Summary == "" && derived.Title == "" {
+		return
+	}
+	_, err = metadata.update(m.ID, func(m *jobMeta) error {
+		deriveTitle(m, head[:n])
+		return nil
+	})
+	if err != nil {
+		warnf("Title failed: ID: %d [%v]\n", m.ID, err)
+	}
+}
+
+// jobLabel names a job the way notifications refer to it.
+func jobLabel(m jobMeta) string {
+	if m.Title == "" {
+		return fmt.Sprintf("Job %d", m.ID)
+	}
+	return fmt.Sprintf("Job %d (%s)", m.ID, m.Title)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+)
+
+// tracker mirrors jobs outward into a task tracker, so teams can follow
+// review work where they already plan it. Each tracker keeps the ID of its
+// item for a job in the job's metadata under its own name.
+type tracker interface {
+	name() string
+	// sync brings the item for the job e is about up to date, creating it
+	// if ref is empty, and returns the item's ID.
+	sync(e jobEvent, m jobMeta, ref string) (string, error)
+}
+
+const trackerBuffer = 1000
+
+var (
+	trackers      []tracker
+	trackerEvents chan jobEvent
+)
+
+// trackerComment is the note a tracker item gets when its job moves.
+func trackerComment(e jobEvent) string {
+	comment := fmt.Sprintf("Job %d: %s", e.ID, e.State)
+	if e.Reason != "" {
+		comment += "\n\n" + e.Reason
+	}
+	return comment
+}
+
+// configuredTrackers lists the trackers with a configuration.
+func configuredTrackers() []tracker {
+	list := []tracker{}
+	if cfg.Jira.enabled() {
+		list = append(list, jiraTracker{})
+	}
+	if cfg.GitHub.enabled() {
+		list = append(list, githubTracker{})
+	}
+	return list
+}
+
+// syncTrackers passes e on to every tracker, recording the items they
+// create.
+func syncTrackers(e jobEvent) {
+	for _, t := range trackers {
+		m, ok := metadata.get(e.ID)
+		if !ok {
+			return
+		}
+		ref := m.Tracked[t.name()]
+		created, err := t.sync(e, m, ref)
+		if err != nil {
+			warnf("Tracker sync failed: %s: ID: %d [%v]\n", t.name(), e.ID, err)
+			continue
+		}
+		if created == ref {
+			continue
+		}
+
+		_, err = metadata.update(e.ID, func(m *jobMeta) error {
+			// The map is shared with the stored copy, so replace it
+			tracked := map[string]string{}
+			for name, ref := range m.Tracked {
+				tracked[name] = ref
+			}
+			tracked[t.name()] = created
+			m.Tracked = tracked
+			return nil
+		})
+		if err != nil {
+			warnf("Tracker sync failed: %s: ID: %d [%v]\n", t.name(), e.ID, err)
+			continue
+		}
+		infof("Tracker item created: %s: ID: %d [%s]\n", t.name(), e.ID, created)
+	}
+}
+
+// queueTrackers hands an event to the trackers. Like broker events they
+// are dropped rather than delaying requests when a tracker falls behind.
+func queueTrackers(e jobEvent) {
+	if trackerEvents == nil {
+		return
+	}
+	if e.Type != eventCreated && e.Type != eventDecided && e.Type != eventAppealed {
+		return
+	}
+	select {
+	case trackerEvents <- e:
+	default:
+		warnf("Tracker buffer full, dropped %s event: ID: %d\n", e.Type, e.ID)
+	}
+}
+
+// startTrackers runs the configured trackers. Events are handled one at a
+// time, so an item is always created before it is updated.
+func startTrackers() {
+	trackers = configuredTrackers()
+	if len(trackers) == 0 {
+		return
+	}
+	trackerEvents = make(chan jobEvent, trackerBuffer)
+	go func() {
+		for e := range trackerEvents {
+			syncTrackers(e)
+		}
+	}()
+}